@@ -0,0 +1,221 @@
+package mop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+/*
+Alerts are rules stored in the profile, each pairing a ticker with a condition written with the same variables
+the filter understands, e.g. `last > 200` or `changePercent <= -3`. The `AlertWatcher` evaluates every rule after
+each quotes refresh and fires when its condition becomes true, i.e. on the edge from false to true, so a price
+sitting above the threshold does not fire on every refresh. A fired rule stays quiet for its cooldown even when
+the condition flips back and forth in the meantime.
+
+Firing an alert shows a banner above the quotes table, has the screen ring the terminal bell when
+`Profile.AlertBell` is set, see Bell, and runs `Profile.AlertCommand` through the shell with the `AlertEvent` as
+JSON on its standard input. Conditions that cannot be evaluated and failures of the command are reported by Errors.
+*/
+
+// Alert is a rule stored in the profile. Cooldown is in seconds, the default cooldown is used when it is 0.
+type Alert struct {
+	Ticker    string
+	Condition string
+	Cooldown  int `json:",omitempty"`
+}
+
+// AlertEvent describes a fired alert. It is the JSON document written to the alert command.
+type AlertEvent struct {
+	Ticker    string    `json:"ticker"`
+	Condition string    `json:"condition"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	Stock     Stock     `json:"stock"`
+}
+
+// Cooldown applied to rules that do not set their own, and how long the banner of a fired alert stays visible.
+const defaultAlertCooldown = 5 * time.Minute
+const alertBannerTime = time.Minute
+
+// Number of fired alerts kept for History.
+const alertHistorySize = 100
+
+type AlertWatcher struct {
+	profile *Profile
+	mutex   sync.Mutex
+	rules   map[Alert]*alertState // Compiled rules, keyed by the rule in the profile.
+	fired   []AlertEvent          // Alerts fired recently, newest last.
+	history []AlertEvent          // Last alerts fired, newest last.
+	ring    bool                  // True when an alert fired since the last call to Bell and the bell is on.
+	hookErr error                 // Why the alert command failed the last time it ran, nil when it succeeded.
+}
+
+type alertState struct {
+	expression *govaluate.EvaluableExpression
+	err        error     // Error compiling or evaluating the condition.
+	active     bool      // True while the condition holds.
+	firedAt    time.Time // Time the rule fired last.
+}
+
+// This function creates a watcher for the alerts of the profile.
+func NewAlertWatcher(profile *Profile) *AlertWatcher {
+	return &AlertWatcher{
+		profile: profile,
+		rules:   make(map[Alert]*alertState),
+	}
+}
+
+// This function evaluates every alert against the stocks and notifies the ones that fire. Rules whose ticker is not among the stocks keep their state. It returns the alerts fired.
+func (watcher *AlertWatcher) Check(stocks []Stock, now time.Time) []AlertEvent {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	bySymbol := make(map[string]Stock, len(stocks))
+	for _, stock := range stocks {
+		bySymbol[stock.Ticker] = stock
+	}
+
+	var events []AlertEvent
+	current := make(map[Alert]*alertState, len(watcher.profile.Alerts))
+	for _, alert := range watcher.profile.Alerts {
+		state := watcher.rules[alert]
+		if state == nil {
+			state = &alertState{}
+			state.expression, state.err = govaluate.NewEvaluableExpression(alert.Condition)
+		}
+		current[alert] = state
+
+		stock, ok := bySymbol[alert.Ticker]
+		if !ok || state.expression == nil || !stock.LastTrade.Valid {
+			continue
+		}
+		result, err := state.expression.Evaluate(stockValues(stock))
+		if err != nil {
+			state.err = err
+			continue
+		}
+		truthy, ok := result.(bool)
+		if !ok {
+			state.err = fmt.Errorf("the condition is not true or false but %v", result)
+			continue
+		}
+		state.err = nil
+
+		cooldown := defaultAlertCooldown
+		if alert.Cooldown > 0 {
+			cooldown = time.Duration(alert.Cooldown) * time.Second
+		}
+		if truthy && !state.active && now.Sub(state.firedAt) >= cooldown {
+			state.firedAt = now
+			events = append(events, AlertEvent{
+				Ticker:    alert.Ticker,
+				Condition: alert.Condition,
+				Message:   fmt.Sprintf("%s: %s (last %s)", alert.Ticker, alert.Condition, formatPrice(stock.LastTrade.Value)),
+				Time:      now,
+				Stock:     stock,
+			})
+		}
+		state.active = truthy
+	}
+	watcher.rules = current
+
+	for _, event := range events {
+		watcher.notify(event)
+	}
+	watcher.ring = watcher.ring || (len(events) > 0 && watcher.profile.AlertBell)
+	watcher.fired = append(watcher.fired, events...)
+	watcher.history = append(watcher.history, events...)
+	if len(watcher.history) > alertHistorySize {
+		watcher.history = watcher.history[len(watcher.history)-alertHistorySize:]
+	}
+
+	return events
+}
+
+// This function returns the message of the most recent alert fired within the last minute, prefixed with the number of others, or an empty string.
+func (watcher *AlertWatcher) Banner(now time.Time) string {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	recent := watcher.fired[:0]
+	for _, event := range watcher.fired {
+		if now.Sub(event.Time) < alertBannerTime {
+			recent = append(recent, event)
+		}
+	}
+	watcher.fired = recent
+
+	switch len(recent) {
+	case 0:
+		return ``
+	case 1:
+		return `Alert ` + recent[0].Message
+	}
+	return fmt.Sprintf(`Alert %s (+%d more)`, recent[len(recent)-1].Message, len(recent)-1)
+}
+
+// This function reports whether the terminal bell should ring for the alerts fired since it was last called. The screen rings it, so that the bell does not cut into what it writes to the terminal.
+func (watcher *AlertWatcher) Bell() bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	ring := watcher.ring
+	watcher.ring = false
+	return ring
+}
+
+// This function describes why the conditions of rules could not be evaluated on the last check, in the order of the rules, and why the alert command failed the last time it ran.
+func (watcher *AlertWatcher) Errors() []string {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	var errors []string
+	for _, alert := range watcher.profile.Alerts {
+		if state := watcher.rules[alert]; state != nil && state.err != nil {
+			errors = append(errors, fmt.Sprintf("alert %s %s: %v", alert.Ticker, alert.Condition, state.err))
+		}
+	}
+	if watcher.hookErr != nil {
+		errors = append(errors, fmt.Sprintf("alert command: %v", watcher.hookErr))
+	}
+	return errors
+}
+
+// This function returns a copy of the last alerts fired, oldest first.
+func (watcher *AlertWatcher) History() []AlertEvent {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	return append([]AlertEvent(nil), watcher.history...)
+}
+
+// This function runs the alert command for the event, called with the mutex held. The command runs in the background: its failure is kept for Errors once it exits.
+func (watcher *AlertWatcher) notify(event AlertEvent) {
+	if watcher.profile.AlertCommand == `` {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		watcher.hookErr = err
+		return
+	}
+	command := exec.Command(`sh`, `-c`, watcher.profile.AlertCommand)
+	command.Stdin = bytes.NewReader(data)
+	if err := command.Start(); err != nil {
+		watcher.hookErr = err
+		return
+	}
+	go func() {
+		err := command.Wait()
+		watcher.mutex.Lock()
+		watcher.hookErr = err
+		watcher.mutex.Unlock()
+	}()
+}
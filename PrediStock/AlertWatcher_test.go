@@ -0,0 +1,54 @@
+package mop
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertErrors(t *testing.T) {
+	profile, err := NewProfile(filepath.Join(t.TempDir(), `.moprc`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.AlertBell, profile.AlertCommand = true, `cat > /dev/null; exit 3`
+	profile.Alerts = []Alert{
+		{Ticker: `AAPL`, Condition: `last > foo`},
+		{Ticker: `AAPL`, Condition: `last + 1`},
+		{Ticker: `AAPL`, Condition: `last > 100`},
+	}
+	watcher := NewAlertWatcher(profile)
+	now := time.Date(2026, time.October, 14, 15, 0, 0, 0, time.UTC)
+
+	if fired := watcher.Check([]Stock{{Ticker: `AAPL`, LastTrade: Number{190, true}}}, now); len(fired) != 1 {
+		t.Fatalf("got %d alerts fired, want 1", len(fired))
+	}
+	if !watcher.Bell() || watcher.Bell() {
+		t.Error("the bell should ring once for the alert fired")
+	}
+
+	// The command runs in the background: its failure shows once it exited.
+	want := []string{`alert AAPL last > foo: `, `alert AAPL last + 1: the condition is not true or false`, `alert command: exit status 3`}
+	deadline := time.Now().Add(5 * time.Second)
+	errors := watcher.Errors()
+	for len(errors) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		errors = watcher.Errors()
+	}
+	if len(errors) != len(want) {
+		t.Fatalf("got errors %q, want %d", errors, len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(errors[i], want[i]) {
+			t.Errorf("got error %q, want %q", errors[i], want[i])
+		}
+	}
+
+	// Fixed conditions no longer report an error; nothing fires, so the bell stays quiet.
+	profile.Alerts = profile.Alerts[2:]
+	watcher.Check([]Stock{{Ticker: `AAPL`, LastTrade: Number{191, true}}}, now.Add(time.Minute))
+	if errors := watcher.Errors(); len(errors) != 1 || watcher.Bell() {
+		t.Errorf("got errors %q and the bell rung, want the command error only", errors)
+	}
+}
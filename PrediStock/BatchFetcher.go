@@ -0,0 +1,99 @@
+package mop
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Providers limit the number of symbols, and the length of the URL, of one request, so long lists of tickers
+are not fetched in one go. `fetchBatches` splits them in batches of `QuotesBatch` tickers of the profile (50
+by default) and sends up to `FetchWorkers` requests at once (4 by default):
+
+- The stocks of all batches are merged back in the order of the tickers in the profile.
+- A batch that fails does not fail the others: each of its tickers is reported with the error in the
+  failures, and the table shows the stocks of the batches that succeeded.
+*/
+
+// Default number of tickers per quotes request, and of requests sent at once.
+const defaultQuotesBatch = 50
+const defaultFetchWorkers = 4
+
+// This function returns how many tickers are requested at once, see BatchFetcher.go.
+func (profile *Profile) QuotesBatchSize() int {
+	if profile.QuotesBatch <= 0 {
+		return defaultQuotesBatch
+	}
+	return profile.QuotesBatch
+}
+
+// This function returns how many requests are sent at once, see BatchFetcher.go.
+func (profile *Profile) FetchWorkerCount() int {
+	if profile.FetchWorkers <= 0 {
+		return defaultFetchWorkers
+	}
+	return profile.FetchWorkers
+}
+
+// This function fetches the quotes of the tickers in batches of the given size, at most `workers` at a time. It returns the stocks in the order of the tickers, and the error of every ticker whose batch failed.
+func fetchBatches(ctx context.Context, provider QuoteProvider, tickers []string, size, workers int) ([]Stock, map[string]error) {
+	var batches [][]string
+	for start := 0; start < len(tickers); start += size {
+		end := start + size
+		if end > len(tickers) {
+			end = len(tickers)
+		}
+		batches = append(batches, tickers[start:end])
+	}
+
+	results := make([][]Stock, len(batches))
+	failures := make(map[string]error)
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	slots := make(chan struct{}, workers)
+	for i, batch := range batches {
+		wait.Add(1)
+		go func(i int, batch []string) {
+			defer wait.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			stocks, err := provider.FetchQuotes(ctx, batch)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				for _, ticker := range batch {
+					failures[ticker] = err
+				}
+				return
+			}
+			results[i] = stocks
+		}(i, batch)
+	}
+	wait.Wait()
+
+	var stocks []Stock
+	for _, batch := range results {
+		stocks = append(stocks, batch...)
+	}
+	sortByTickers(stocks, tickers)
+
+	return stocks, failures
+}
+
+// This function sorts the stocks in the order of the tickers. Stocks of other tickers come last.
+func sortByTickers(stocks []Stock, tickers []string) {
+	order := make(map[string]int, len(tickers))
+	for i, ticker := range tickers {
+		order[strings.ToUpper(ticker)] = i
+	}
+	position := func(stock Stock) int {
+		if i, ok := order[strings.ToUpper(stock.Ticker)]; ok {
+			return i
+		}
+		return len(tickers)
+	}
+	sort.SliceStable(stocks, func(i, j int) bool { return position(stocks[i]) < position(stocks[j]) })
+}
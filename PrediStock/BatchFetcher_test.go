@@ -0,0 +1,56 @@
+package mop
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// batchProvider records the batches it is asked for, fails those holding the ticker `failing`, and returns the stocks of the others in reverse order.
+type batchProvider struct {
+	*fakeProvider
+	failing string
+	mutex   sync.Mutex
+	batches []string
+}
+
+func (provider *batchProvider) FetchQuotes(ctx context.Context, tickers []string) ([]Stock, error) {
+	provider.mutex.Lock()
+	provider.batches = append(provider.batches, strings.Join(tickers, `,`))
+	provider.mutex.Unlock()
+
+	for _, ticker := range tickers {
+		if ticker == provider.failing {
+			return nil, errors.New(`batch failed`)
+		}
+	}
+	stocks, err := provider.fakeProvider.FetchQuotes(ctx, tickers)
+	for i, j := 0, len(stocks)-1; i < j; i, j = i+1, j-1 {
+		stocks[i], stocks[j] = stocks[j], stocks[i]
+	}
+	return stocks, err
+}
+
+func TestFetchBatches(t *testing.T) {
+	provider := &batchProvider{
+		fakeProvider: &fakeProvider{prices: map[string]float64{`AAPL`: 190, `MSFT`: 420, `GOOG`: 150, `TSLA`: 250}},
+		failing:      `TSLA`,
+	}
+	tickers := []string{`MSFT`, `AAPL`, `GOOG`, `TSLA`, `IBM`}
+	stocks, failures := fetchBatches(context.Background(), provider, tickers, 2, 2)
+
+	sort.Strings(provider.batches)
+	if got := strings.Join(provider.batches, ` `); got != `GOOG,TSLA IBM MSFT,AAPL` {
+		t.Errorf("got batches %s", got)
+	}
+	// The stocks come in the order of the tickers; IBM, unknown to the provider, is neither a stock nor a failure.
+	if got := strings.Join(tickersOf(stocks), `,`); got != `MSFT,AAPL` {
+		t.Errorf("got stocks %s, want MSFT,AAPL", got)
+	}
+	if len(failures) != 2 || failures[`GOOG`] == nil || failures[`TSLA`] == nil || failures[`GOOG`].Error() != `batch failed` {
+		t.Errorf("got failures %v, want GOOG and TSLA", failures)
+	}
+}
@@ -0,0 +1,323 @@
+package mop
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+/*
+The chart view takes over the whole screen to draw the price history of a single ticker as candlesticks or
+OHLC bars, with volume bars underneath, price labels on the right and dates along the bottom:
+
+- Left/Right arrows (or the number keys 1-6) switch between the ranges listed in `ChartPeriods`.
+- `c` toggles between candlesticks and OHLC bars.
+- `m` cycles through the moving average overlays: none, 20 bars, 20 and 50 bars.
+- Esc returns to the quotes table.
+
+Ranges are fetched in the background, see FetchWorker.go: the main loop applies them from the Fetched channel.
+
+When there are more bars than columns, neighbouring bars are merged so the whole range always fits.
+*/
+type ChartView struct {
+	screen   *Screen
+	provider QuoteProvider
+	ticker   string
+	period   int          // Index into ChartPeriods.
+	ohlc     bool         // True when drawing OHLC bars instead of candlesticks.
+	overlay  int          // Index into chartOverlays.
+	bars     []Bar        // Bars of the current range.
+	err      error        // Error fetching the current range, if any.
+	loading  bool         // True while the current range is being fetched.
+	worker   *fetchWorker // Fetches the ranges.
+}
+
+// Moving average overlays cycled by the `m` key, with the color tag each average is drawn in.
+var chartOverlays = [][]int{nil, {20}, {20, 50}}
+var chartOverlayTags = []string{`tag`, `header`}
+
+const (
+	chartAxisWidth = 11 // Width of the price labels on the right.
+	chartVolume    = 5  // Height of the volume area.
+)
+
+// This function creates the chart view of the ticker, draws it and starts fetching its first range.
+func NewChartView(screen *Screen, provider QuoteProvider, ticker string) *ChartView {
+	view := &ChartView{
+		screen:   screen,
+		provider: provider,
+		ticker:   strings.ToUpper(ticker),
+		period:   1,
+		worker:   newFetchWorker(),
+	}
+
+	return view.fetch().Draw()
+}
+
+// This function returns the channel delivering the fetched ranges, to be applied with FetchResult.Apply, which draws the chart. It returns nil for a nil view, so that it can be selected on while no chart is shown.
+func (view *ChartView) Fetched() <-chan FetchResult {
+	if view == nil {
+		return nil
+	}
+	return view.worker.results
+}
+
+// This function handles a key press while the chart is shown. It returns true when the user leaves the chart, which cancels the fetch under way.
+func (view *ChartView) Handle(event termbox.Event) bool {
+	switch {
+	case event.Key == termbox.KeyEsc || event.Ch == 'q' || event.Ch == 'Q':
+		view.worker.stop()
+		return true
+	case event.Key == termbox.KeyArrowLeft:
+		view.period = (view.period + len(ChartPeriods) - 1) % len(ChartPeriods)
+		view.fetch()
+	case event.Key == termbox.KeyArrowRight:
+		view.period = (view.period + 1) % len(ChartPeriods)
+		view.fetch()
+	case event.Ch >= '1' && int(event.Ch-'1') < len(ChartPeriods):
+		view.period = int(event.Ch - '1')
+		view.fetch()
+	case event.Ch == 'c' || event.Ch == 'C':
+		view.ohlc = !view.ohlc
+	case event.Ch == 'm' || event.Ch == 'M':
+		view.overlay = (view.overlay + 1) % len(chartOverlays)
+	}
+	view.Draw()
+
+	return false
+}
+
+// This function redraws the whole chart, e.g. after the terminal has been resized.
+func (view *ChartView) Draw() *ChartView {
+	screen := view.screen
+	screen.Clear()
+	screen.width, screen.height = termbox.Size()
+
+	screen.DrawLineFlush(0, 0, view.title(), false)
+	screen.DrawLineFlush(0, screen.height-1, `<tag>Left/Right 1-6</> range  <tag>c</> candles/ohlc  <tag>m</> averages  <tag>Esc</> back`, false)
+
+	width := screen.width - chartAxisWidth
+	priceTop, priceBottom := 2, screen.height-chartVolume-4
+	if view.err != nil || len(view.bars) == 0 || width < 10 || priceBottom-priceTop < 4 {
+		message := `No chart data`
+		if view.loading {
+			message = `Loading...`
+		}
+		if view.err != nil {
+			message = view.err.Error()
+		}
+		screen.DrawLineFlush(0, 2, `<loss>`+message+`</>`, false)
+		termbox.Flush()
+		return view
+	}
+
+	bars := resampleBars(view.bars, width)
+	low, high := bars[0].Low, bars[0].High
+	for _, bar := range bars {
+		low, high = math.Min(low, bar.Low), math.Max(high, bar.High)
+	}
+	if high == low {
+		high, low = high+0.5, low-0.5
+	}
+	row := func(price float64) int {
+		return priceBottom - int(math.Round((price-low)/(high-low)*float64(priceBottom-priceTop)))
+	}
+
+	gain, loss := screen.markup.tags[`gain`], screen.markup.tags[`loss`]
+	for x, bar := range bars {
+		color := gain
+		if bar.Close < bar.Open {
+			color = loss
+		}
+		view.drawBar(x, bar, row, color)
+	}
+
+	for i, period := range chartOverlays[view.overlay] {
+		color := screen.markup.tags[chartOverlayTags[i%len(chartOverlayTags)]]
+		for x, average := range resampleAverages(movingAverage(Closes(view.bars), period), width) {
+			if !math.IsNaN(average) {
+				termbox.SetCell(x, row(average), '•', color, termbox.ColorDefault)
+			}
+		}
+	}
+
+	for y := priceTop; y <= priceBottom; y += 3 {
+		price := high - float64(y-priceTop)/float64(priceBottom-priceTop)*(high-low)
+		screen.DrawLineFlush(width+1, y, fmt.Sprintf(`%10s`, humanize(price, 2)), false)
+	}
+
+	view.drawVolume(bars, priceBottom+2, gain, loss)
+	view.drawDates(bars, screen.height-2)
+	termbox.Flush()
+
+	return view
+}
+
+// This function drops the range shown and asks the worker for the current one, cancelling the fetch of the previous range.
+func (view *ChartView) fetch() *ChartView {
+	view.bars, view.err, view.loading = nil, nil, true
+	ticker, period := view.ticker, ChartPeriods[view.period]
+	view.worker.cancelAll()
+	view.worker.submit(func(ctx context.Context) func() {
+		bars, err := view.provider.FetchChart(ctx, ticker, period)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return func() {
+			view.bars, view.err, view.loading = bars, err, false
+			view.Draw()
+		}
+	})
+
+	return view
+}
+
+// -----------------------------------------------------------------------------
+func (view *ChartView) title() string {
+	str := `<b>` + view.ticker + `</b> `
+	for i, period := range ChartPeriods {
+		if i == view.period {
+			str += ` <r>` + period + `</r>`
+		} else {
+			str += ` ` + period
+		}
+	}
+	if len(view.bars) > 0 {
+		last := view.bars[len(view.bars)-1]
+		str += fmt.Sprintf(`   O %s  H %s  L %s  C %s  V %s`, humanize(last.Open, 2), humanize(last.High, 2),
+			humanize(last.Low, 2), humanize(last.Close, 2), integer(Integer{Value: last.Volume, Valid: true}, ``))
+	}
+	for i, period := range chartOverlays[view.overlay] {
+		tag := chartOverlayTags[i%len(chartOverlayTags)]
+		str += fmt.Sprintf(`   <%s>• MA%d</>`, tag, period)
+	}
+
+	return str
+}
+
+// -----------------------------------------------------------------------------
+func (view *ChartView) drawBar(x int, bar Bar, row func(float64) int, color termbox.Attribute) {
+	top, bottom := row(bar.High), row(bar.Low)
+	openRow, closeRow := row(bar.Open), row(bar.Close)
+	bodyTop, bodyBottom := openRow, closeRow
+	if bodyTop > bodyBottom {
+		bodyTop, bodyBottom = bodyBottom, bodyTop
+	}
+
+	for y := top; y <= bottom; y++ {
+		char := '│'
+		switch {
+		case view.ohlc && y == openRow && y == closeRow:
+			char = '┼'
+		case view.ohlc && y == openRow:
+			char = '┤'
+		case view.ohlc && y == closeRow:
+			char = '├'
+		case !view.ohlc && y >= bodyTop && y <= bodyBottom:
+			char = '┃'
+		}
+		termbox.SetCell(x, y, char, color, termbox.ColorDefault)
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (view *ChartView) drawVolume(bars []Bar, top int, gain, loss termbox.Attribute) {
+	largest := int64(0)
+	for _, bar := range bars {
+		if bar.Volume > largest {
+			largest = bar.Volume
+		}
+	}
+	if largest == 0 {
+		return
+	}
+
+	levels := []rune(`▁▂▃▄▅▆▇█`)
+	for x, bar := range bars {
+		color := gain
+		if bar.Close < bar.Open {
+			color = loss
+		}
+		eighths := int(bar.Volume * int64(chartVolume*len(levels)) / largest)
+		for y := top + chartVolume - 1; y >= top && eighths > 0; y-- {
+			level := eighths
+			if level > len(levels) {
+				level = len(levels)
+			}
+			termbox.SetCell(x, y, levels[level-1], color, termbox.ColorDefault)
+			eighths -= level
+		}
+	}
+	view.screen.DrawLineFlush(len(bars)+1, top+chartVolume-1, `Vol `+integer(Integer{Value: largest, Valid: true}, ``), false)
+}
+
+// -----------------------------------------------------------------------------
+func (view *ChartView) drawDates(bars []Bar, y int) {
+	layout := `Jan 02`
+	if ChartPeriods[view.period] == `1d` {
+		layout = `15:04`
+	} else if ChartPeriods[view.period] == `5y` {
+		layout = `Jan 2006`
+	}
+
+	for x := 0; x < len(bars); x += 16 {
+		view.screen.DrawLineFlush(x, y, `<time>`+bars[x].Time.Local().Format(layout)+`</>`, false)
+	}
+}
+
+// -----------------------------------------------------------------------------
+func resampleBars(bars []Bar, width int) []Bar {
+	if len(bars) <= width {
+		return bars
+	}
+
+	resampled := make([]Bar, width)
+	for i := range resampled {
+		from, to := i*len(bars)/width, (i+1)*len(bars)/width
+		merged := bars[from]
+		for _, bar := range bars[from+1 : to] {
+			merged.High = math.Max(merged.High, bar.High)
+			merged.Low = math.Min(merged.Low, bar.Low)
+			merged.Close = bar.Close
+			merged.Volume += bar.Volume
+		}
+		resampled[i] = merged
+	}
+
+	return resampled
+}
+
+// This function returns the averages of the bars merged by resampleBars: the average at the last bar of every merged bar, so that an average over 20 bars stays one over 20 bars of the range rather than 20 columns.
+func resampleAverages(averages []float64, width int) []float64 {
+	if len(averages) <= width {
+		return averages
+	}
+
+	resampled := make([]float64, width)
+	for i := range resampled {
+		resampled[i] = averages[(i+1)*len(averages)/width-1]
+	}
+
+	return resampled
+}
+
+// -----------------------------------------------------------------------------
+func movingAverage(values []float64, period int) []float64 {
+	averages := make([]float64, len(values))
+	sum := 0.0
+	for i, value := range values {
+		sum += value
+		if i >= period {
+			sum -= values[i-period]
+		}
+		averages[i] = math.NaN()
+		if i >= period-1 {
+			averages[i] = sum / float64(period)
+		}
+	}
+
+	return averages
+}
@@ -2,7 +2,9 @@ package mop
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -15,6 +17,8 @@ const defaultTagColor = "yellow"
 const defaultHeaderColor = "lightgray"
 const defaultTimeColor = "lightgray"
 const defaultColor = "lightgray"
+const defaultStaleColor = "darkgray"
+const defaultBaseCurrency = "USD"
 
 type Profile struct {
 	Tickers       []string // List of stock tickers to display.
@@ -25,6 +29,17 @@ type Profile struct {
 	Grouped       bool     // True when stocks are grouped by advancing/declining.
 	Filter        string   // Filter in human form
 	UpDownJump    int      // Number of lines to go up/down when scrolling.
+	Provider      string   // Name of the quote provider, see RegisterProvider.
+	StreamURL     string   // WebSocket server pushing quote updates, polling only when empty.
+	StoreDir      string   // Directory of the local quote store, next to the profile when empty.
+	NoStore       bool     // True when fetched quotes are not recorded in the local store.
+	Alerts        []Alert  // Alert rules evaluated after every quotes refresh.
+	AlertBell     bool     // True when a fired alert rings the terminal bell.
+	AlertCommand  string   // Shell command run with every fired alert as JSON on stdin.
+	Holdings      Holdings // Lots held per ticker.
+	BaseCurrency  string   // Currency the position columns are converted to.
+	ShowHoldings  bool     // True when the position columns are shown.
+	LedgerFile    string   // Transaction ledger, next to the profile when empty.
 	Colors        struct { // User defined colors
 		Gain    string
 		Loss    string
@@ -32,11 +47,23 @@ type Profile struct {
 		Header  string
 		Time    string
 		Default string
+		Stale   string // Rows whose last fetch failed, and unknown tickers.
 	}
 	ShowTimestamp    bool                          
+	Sparkline        bool                           // True when the intraday sparkline column is shown.
+	Watchlists       []Watchlist                    // Named lists of tickers besides the main list.
+	Watchlist        string                         // Name of the active watchlist, empty for the main list.
+	MarketHeader     []MarketItem                   // Symbols of the market header, the default header when empty.
+	Proxy            string                         // Proxy of the provider's requests, the proxy of the environment when empty.
+	RequestTimeout   int                            // Deadline of one provider request in seconds, 10 when 0.
+	QuotesBatch      int                            // Tickers per quotes request, 50 when 0.
+	FetchWorkers     int                            // Provider requests sent at once, 4 when 0.
 	filterExpression *govaluate.EvaluableExpression 
 	selectedColumn   int                           
+	main             listSettings                   // Settings of the main list while a watchlist is active.
+	selectedTicker   string                         // Ticker under the row cursor, kept across sorting, filtering and refreshes.
 	filename         string                        
+	readOnly         bool                           // True when changes are kept in memory only, see ReadOnly.
 }
 
 func IsSupportedColor(colorName string) bool {
@@ -76,6 +103,7 @@ func NewProfile(filename string) (*Profile, error) {
 			InitColor(&profile.Colors.Header, defaultHeaderColor)
 			InitColor(&profile.Colors.Time, defaultTimeColor)
 			InitColor(&profile.Colors.Default, defaultColor)
+			InitColor(&profile.Colors.Stale, defaultStaleColor)
 
 			profile.SetFilter(profile.Filter)
 		}
@@ -88,6 +116,14 @@ func NewProfile(filename string) (*Profile, error) {
 	if profile.UpDownJump < 1 {
 		profile.UpDownJump = 10
 	}
+	if profile.BaseCurrency == `` {
+		profile.BaseCurrency = defaultBaseCurrency
+	}
+	profile.BaseCurrency = strings.ToUpper(profile.BaseCurrency)
+	if active := profile.Watchlist; active != `` && err == nil {
+		profile.Watchlist = ``
+		profile.switchWatchlist(active)
+	}
 
 	return profile, err
 }
@@ -102,13 +138,18 @@ func (profile *Profile) InitDefaultProfile() {
 	profile.Ascending = true 
 	profile.Filter = ""
 	profile.UpDownJump = 10
+	profile.Provider = defaultProvider
+	profile.AlertBell = true
+	profile.BaseCurrency = defaultBaseCurrency
 	profile.Colors.Gain = defaultGainColor
 	profile.Colors.Loss = defaultLossColor
 	profile.Colors.Tag = defaultTagColor
 	profile.Colors.Header = defaultHeaderColor
 	profile.Colors.Time = defaultTimeColor
 	profile.Colors.Default = defaultColor
+	profile.Colors.Stale = defaultStaleColor
 	profile.ShowTimestamp = false
+	profile.Sparkline = true
 	profile.Save()
 }
 // This function takes a pointer to a color string and a default color value. It converts the color string to lowercase and checks if it is a supported color. If the color is not supported, it assigns the default color value to the provided color string.
@@ -120,13 +161,25 @@ func InitColor(color *string, defaultValue string) {
 }
 // This function serializes the `Profile` object into a formatted JSON string and writes it to the file specified by `profile.filename`. If the serialization fails, it returns an error. Otherwise, it writes the data to the file with appropriate file permissions (`0644`).
 func (profile *Profile) Save() error {
-	data, err := json.MarshalIndent(profile, "", "    ")
+	profile.captureWatchlist()
+	if profile.readOnly {
+		return nil
+	}
+	saved := *profile
+	saved.Tickers, saved.SortColumn, saved.Ascending = profile.main.Tickers, profile.main.SortColumn, profile.main.Ascending
+	saved.Filter, saved.Grouped = profile.main.Filter, profile.main.Grouped
+
+	data, err := json.MarshalIndent(&saved, "", "    ")
 	if err != nil {
 		return err
 	}
 
 	return ioutil.WriteFile(profile.filename, data, 0644)
 }
+// This function stops `Save` from writing the profile, so that a one-shot command can change the tickers or settings for its own run without persisting them.
+func (profile *Profile) ReadOnly() {
+	profile.readOnly = true
+}
 // This function adds new tickers to the `Profile`'s `Tickers` list, ensuring no duplicates are added. It first creates a map of existing tickers for quick lookup, then appends each unique ticker from the input list. If any tickers are added, the list is sorted, and the profile is saved. The function returns the number of added tickers and any error encountered during the save process.
 func (profile *Profile) AddTickers(tickers []string) (added int, err error) {
 	added, err = 0, nil
@@ -201,3 +254,101 @@ func (profile *Profile) ToggleTimestamp() error {
 	profile.ShowTimestamp = !profile.ShowTimestamp
 	return profile.Save()
 }
+// This function toggles the `Sparkline` state of the `Profile`, showing or hiding the intraday sparkline column. After updating the state, it saves the profile.
+func (profile *Profile) ToggleSparkline() error {
+	profile.Sparkline = !profile.Sparkline
+	return profile.Save()
+}
+// This function adds an alert for the ticker after checking that its condition is a valid expression. After adding the alert, it saves the profile.
+func (profile *Profile) AddAlert(ticker, condition string) error {
+	if _, err := govaluate.NewEvaluableExpression(condition); err != nil {
+		return err
+	}
+	profile.Alerts = append(profile.Alerts, Alert{Ticker: ticker, Condition: condition})
+	return profile.Save()
+}
+// This function removes every alert of the ticker. If any alerts are removed, the profile is saved. The function returns the number of removed alerts and any error encountered during the save process.
+func (profile *Profile) RemoveAlerts(ticker string) (removed int, err error) {
+	kept := make([]Alert, 0, len(profile.Alerts))
+	for _, alert := range profile.Alerts {
+		if alert.Ticker == ticker {
+			removed++
+		} else {
+			kept = append(kept, alert)
+		}
+	}
+
+	if removed > 0 {
+		profile.Alerts = kept
+		err = profile.Save()
+	}
+
+	return
+}
+// This function adds a lot to the holdings of the ticker and adds the ticker to the list when it is missing. After updating the holdings, it saves the profile.
+func (profile *Profile) AddLot(ticker string, lot Lot) error {
+	if profile.Holdings == nil {
+		profile.Holdings = make(Holdings)
+	}
+	profile.Holdings[ticker] = append(profile.Holdings[ticker], lot)
+	if added, err := profile.AddTickers([]string{ticker}); added > 0 || err != nil {
+		return err
+	}
+	return profile.Save()
+}
+// This function replaces the lot of the ticker at the given index, counted from 0, and saves the profile.
+func (profile *Profile) SetLot(ticker string, index int, lot Lot) error {
+	if index < 0 || index >= len(profile.Holdings[ticker]) {
+		return fmt.Errorf("%s has no lot %d", ticker, index+1)
+	}
+	profile.Holdings[ticker][index] = lot
+	return profile.Save()
+}
+// This function removes the lot of the ticker at the given index, counted from 0, and saves the profile. The ticker stays in the list.
+func (profile *Profile) RemoveLot(ticker string, index int) error {
+	lots := profile.Holdings[ticker]
+	if index < 0 || index >= len(lots) {
+		return fmt.Errorf("%s has no lot %d", ticker, index+1)
+	}
+	if len(lots) == 1 {
+		delete(profile.Holdings, ticker)
+	} else {
+		profile.Holdings[ticker] = append(lots[:index:index], lots[index+1:]...)
+	}
+	return profile.Save()
+}
+// This function removes every lot of the ticker. If the ticker had lots, the profile is saved.
+func (profile *Profile) RemoveLots(ticker string) error {
+	if _, ok := profile.Holdings[ticker]; !ok {
+		return nil
+	}
+	delete(profile.Holdings, ticker)
+	return profile.Save()
+}
+// This function toggles the `ShowHoldings` state of the `Profile`, showing or hiding the position columns. After updating the state, it saves the profile.
+func (profile *Profile) ToggleHoldings() error {
+	profile.ShowHoldings = !profile.ShowHoldings
+	return profile.Save()
+}
+// This function returns the path of the transaction ledger: `LedgerFile` when set, otherwise `.mop/ledger.csv` in the directory holding the profile.
+func (profile *Profile) LedgerPath() string {
+	if profile.LedgerFile != `` {
+		return profile.LedgerFile
+	}
+	return filepath.Join(filepath.Dir(profile.filename), `.mop`, `ledger.csv`)
+}
+// This function returns the file caching the cookie and crumb of the Yahoo provider, `.mop/yahoo-session.json` in the directory holding the profile.
+func (profile *Profile) AuthCachePath() string {
+	return filepath.Join(filepath.Dir(profile.filename), `.mop`, `yahoo-session.json`)
+}
+// This function returns the file caching the symbols known to exist, `.mop/symbols.json` in the directory holding the profile, see SymbolDirectory.
+func (profile *Profile) SymbolsPath() string {
+	return filepath.Join(filepath.Dir(profile.filename), `.mop`, `symbols.json`)
+}
+// This function returns the directory of the local quote store: `StoreDir` when set, otherwise `.mop/store` in the directory holding the profile.
+func (profile *Profile) StorePath() string {
+	if profile.StoreDir != `` {
+		return profile.StoreDir
+	}
+	return filepath.Join(filepath.Dir(profile.filename), `.mop`, `store`)
+}
@@ -0,0 +1,343 @@
+package mop
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+The `Server` runs the market and quotes refresh loop without a screen and publishes what it fetches over HTTP,
+so that editors, status bars or a small web page can show the same data as the terminal UI:
+
+	GET  /api/quotes            stocks as the quotes table shows them: filtered, sorted and grouped
+	GET  /api/market            the market summary shown in the header
+	GET  /api/watchlists        the watchlists and their tickers, with the name of the active one
+	POST /api/watchlists?name=  switch to the named watchlist, or to the main list when the name is empty
+	GET  /api/filter?expr=      stocks matching a filter expression, without changing the filter of the profile
+	GET  /api/alerts            the alert rules, the last alerts fired and why rules or the alert command fail
+	GET  /api/events            Server-Sent Events: `quotes` and `market` after every refresh, `alert` when one fires
+
+Every response is JSON; errors are reported as `{"error": "..."}` with a 4xx status. A refresh that fails
+publishes its error the same way, as the `quotes` or `market` event. The `Server` is an
+`http.Handler`, so it can be exercised with `net/http/httptest` after calling `Refresh` with a fake provider.
+*/
+type Server struct {
+	market     *Market
+	quotes     *Quotes
+	profile    *Profile
+	layout     *Layout
+	mux        *http.ServeMux
+	refresh    sync.Mutex                // Serializes fetching and the changes of the profile.
+	mutex      sync.Mutex                // Guards the snapshot and the subscribers below.
+	stocks     []Stock                   // Stocks as the quotes table shows them.
+	all        []Stock                   // Every stock fetched, unfiltered.
+	summary    json.RawMessage           // Market summary as JSON.
+	alertsSent time.Time                 // Time of the last alert sent to the subscribers.
+	clients    map[chan serverEvent]bool // Channels of the event stream subscribers.
+}
+
+type serverEvent struct {
+	name string
+	data []byte
+}
+
+// Number of events buffered per subscriber; a subscriber that falls further behind misses events.
+const serverEventBuffer = 16
+
+// This function creates a server publishing the data of the market and the quotes. Nothing is fetched until `Refresh` or `Run` is called.
+func NewServer(market *Market, quotes *Quotes) *Server {
+	server := &Server{
+		market:     market,
+		quotes:     quotes,
+		profile:    quotes.profile,
+		layout:     NewLayout(),
+		mux:        http.NewServeMux(),
+		summary:    json.RawMessage(`{}`),
+		alertsSent: market.Now(),
+		clients:    make(map[chan serverEvent]bool),
+	}
+	server.mux.HandleFunc(`/api/quotes`, server.handleQuotes)
+	server.mux.HandleFunc(`/api/market`, server.handleMarket)
+	server.mux.HandleFunc(`/api/watchlists`, server.handleWatchlists)
+	server.mux.HandleFunc(`/api/filter`, server.handleFilter)
+	server.mux.HandleFunc(`/api/alerts`, server.handleAlerts)
+	server.mux.HandleFunc(`/api/events`, server.handleEvents)
+
+	return server
+}
+
+// This function refreshes the market and the quotes at the intervals of the profile until `stop` is closed. The first refresh happens immediately.
+func (server *Server) Run(stop <-chan struct{}) {
+	marketQueue := time.NewTicker(time.Duration(server.profile.MarketRefresh) * time.Second)
+	quotesQueue := time.NewTicker(time.Duration(server.profile.QuotesRefresh) * time.Second)
+	defer marketQueue.Stop()
+	defer quotesQueue.Stop()
+
+	server.Refresh()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-marketQueue.C:
+			server.refreshMarket()
+		case <-quotesQueue.C:
+			server.refreshQuotes()
+		}
+	}
+}
+
+// This function fetches the market summary and the quotes, then publishes them to the event stream subscribers.
+func (server *Server) Refresh() {
+	server.refreshMarket()
+	server.refreshQuotes()
+}
+
+// This function implements `http.Handler`.
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mux.ServeHTTP(writer, request)
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) refreshMarket() {
+	server.refresh.Lock()
+	defer server.refresh.Unlock()
+
+	server.market.Fetch()
+	summary, err := json.Marshal(server.market)
+	if ok, message := server.market.Ok(); !ok {
+		summary, err = json.Marshal(map[string]string{`error`: message})
+	}
+	if err != nil {
+		return
+	}
+	server.mutex.Lock()
+	server.summary = summary
+	server.mutex.Unlock()
+	server.publish(`market`, summary)
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) refreshQuotes() {
+	server.refresh.Lock()
+	defer server.refresh.Unlock()
+
+	if ok, message := server.quotes.Fetch().Ok(); !ok {
+		if data, err := json.Marshal(map[string]string{`error`: message}); err == nil {
+			server.publish(`quotes`, data)
+		}
+		return
+	}
+	stocks := server.layout.Stocks(server.quotes)
+	if stocks == nil {
+		stocks = []Stock{}
+	}
+
+	server.mutex.Lock()
+	server.stocks, server.all = stocks, server.quotes.Stocks()
+	var fired []AlertEvent
+	for _, event := range server.quotes.AlertHistory() {
+		if event.Time.After(server.alertsSent) {
+			fired = append(fired, event)
+			server.alertsSent = event.Time
+		}
+	}
+	server.mutex.Unlock()
+
+	if data, err := json.Marshal(stocks); err == nil {
+		server.publish(`quotes`, data)
+	}
+	for _, event := range fired {
+		if data, err := json.Marshal(event); err == nil {
+			server.publish(`alert`, data)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) publish(name string, data []byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for client := range server.clients {
+		select {
+		case client <- serverEvent{name, data}:
+		default:
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleQuotes(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet) {
+		return
+	}
+	server.mutex.Lock()
+	stocks := server.stocks
+	server.mutex.Unlock()
+
+	if stocks == nil {
+		stocks = []Stock{}
+	}
+	writeJSON(writer, http.StatusOK, stocks)
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleMarket(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet) {
+		return
+	}
+	server.mutex.Lock()
+	summary := server.summary
+	server.mutex.Unlock()
+
+	writeJSON(writer, http.StatusOK, summary)
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleWatchlists(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if request.Method == http.MethodPost {
+		server.refresh.Lock()
+		err := server.quotes.SelectWatchlist(request.FormValue(`name`))
+		server.refresh.Unlock()
+		if err != nil {
+			writeError(writer, http.StatusNotFound, err)
+			return
+		}
+		server.refreshQuotes()
+	}
+
+	type watchlist struct {
+		Name    string   `json:"name"`
+		Tickers []string `json:"tickers"`
+	}
+	server.refresh.Lock()
+	lists := []watchlist{}
+	for _, name := range append([]string{``}, server.profile.WatchlistNames()...) {
+		tickers, _ := server.profile.WatchlistTickers(name)
+		lists = append(lists, watchlist{name, tickers})
+	}
+	active := server.profile.ActiveWatchlist()
+	server.refresh.Unlock()
+
+	writeJSON(writer, http.StatusOK, map[string]interface{}{`active`: active, `watchlists`: lists})
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleFilter(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet) {
+		return
+	}
+	expression := request.FormValue(`expr`)
+	if expression == `` {
+		writeError(writer, http.StatusBadRequest, fmt.Errorf("missing expr parameter"))
+		return
+	}
+	server.mutex.Lock()
+	stocks := server.all
+	server.mutex.Unlock()
+
+	matched, err := MatchFilter(expression, stocks)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, matched)
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleAlerts(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet) {
+		return
+	}
+	server.refresh.Lock()
+	rules := append([]Alert{}, server.profile.Alerts...)
+	server.refresh.Unlock()
+
+	fired, failing := server.quotes.AlertHistory(), server.quotes.AlertErrors()
+	if fired == nil {
+		fired = []AlertEvent{}
+	}
+	if failing == nil {
+		failing = []string{}
+	}
+	writeJSON(writer, http.StatusOK, map[string]interface{}{`rules`: rules, `fired`: fired, `errors`: failing})
+}
+
+// -----------------------------------------------------------------------------
+func (server *Server) handleEvents(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet) {
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	client := make(chan serverEvent, serverEventBuffer)
+	server.mutex.Lock()
+	server.clients[client] = true
+	summary, stocks := server.summary, server.stocks
+	server.mutex.Unlock()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.clients, client)
+		server.mutex.Unlock()
+	}()
+
+	writer.Header().Set(`Content-Type`, `text/event-stream`)
+	writer.Header().Set(`Cache-Control`, `no-cache`)
+	writer.WriteHeader(http.StatusOK)
+
+	// New subscribers start from the current snapshot.
+	fmt.Fprintf(writer, "event: market\ndata: %s\n\n", summary)
+	if stocks != nil {
+		if data, err := json.Marshal(stocks); err == nil {
+			fmt.Fprintf(writer, "event: quotes\ndata: %s\n\n", data)
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event := <-client:
+			fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+func allowMethods(writer http.ResponseWriter, request *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if request.Method == method {
+			return true
+		}
+	}
+	writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", request.Method))
+	return false
+}
+
+// -----------------------------------------------------------------------------
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		status, data = http.StatusInternalServerError, []byte(`{"error":"encoding failed"}`)
+	}
+	writer.Header().Set(`Content-Type`, `application/json`)
+	writer.WriteHeader(status)
+	writer.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+func writeError(writer http.ResponseWriter, status int, err error) {
+	writeJSON(writer, status, map[string]string{`error`: err.Error()})
+}
@@ -0,0 +1,233 @@
+package mop
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider quotes the tickers of its prices and fails every fetch while err is set. Market symbols without a price are at 1. Its clock is stopped on a weekday morning, when the U.S. exchanges are open.
+type fakeProvider struct {
+	mutex  sync.Mutex
+	prices map[string]float64
+	err    error
+}
+
+func (provider *fakeProvider) FetchQuotes(ctx context.Context, tickers []string) ([]Stock, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.err != nil {
+		return nil, provider.err
+	}
+	var stocks []Stock
+	for _, ticker := range tickers {
+		if price, ok := provider.prices[ticker]; ok {
+			stocks = append(stocks, Stock{Ticker: ticker, LastTrade: Number{price, true}, Currency: `USD`})
+		}
+	}
+	return stocks, nil
+}
+
+func (provider *fakeProvider) FetchMarket(ctx context.Context, symbols []string) ([]Stock, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	stocks := make([]Stock, 0, len(symbols))
+	for _, symbol := range symbols {
+		price, ok := provider.prices[symbol]
+		if !ok {
+			price = 1
+		}
+		stocks = append(stocks, Stock{Ticker: symbol, LastTrade: Number{price, true}})
+	}
+	return stocks, nil
+}
+
+func (provider *fakeProvider) FetchChart(ctx context.Context, ticker string, period string) ([]Bar, error) {
+	return nil, nil
+}
+
+func (provider *fakeProvider) FetchFundamentals(ctx context.Context, ticker string) (Fundamentals, error) {
+	return Fundamentals{}, nil
+}
+
+func (provider *fakeProvider) Now() time.Time {
+	return time.Date(2026, time.October, 14, 15, 0, 0, 0, time.UTC)
+}
+
+func (provider *fakeProvider) fail(err error) {
+	provider.mutex.Lock()
+	provider.err = err
+	provider.mutex.Unlock()
+}
+
+// This function creates a server fetching from the provider, registered as the `fake` provider, for a profile in a temporary directory.
+func newFakeServer(t *testing.T, provider *fakeProvider) *Server {
+	RegisterProvider(`fake`, func(profile *Profile) (QuoteProvider, error) { return provider, nil })
+
+	profile, err := NewProfile(filepath.Join(t.TempDir(), `.moprc`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Provider, profile.NoStore = `fake`, true
+	profile.Tickers = []string{`AAPL`, `MSFT`}
+
+	quoteProvider, err := NewQuoteProvider(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	market := NewMarket(quoteProvider, profile)
+	return NewServer(market, NewQuotes(market, profile))
+}
+
+func TestServerErrors(t *testing.T) {
+	server := newFakeServer(t, &fakeProvider{prices: map[string]float64{`AAPL`: 190}})
+	server.Refresh()
+
+	tests := []struct {
+		method, target string
+		status         int
+		message        string
+	}{
+		{http.MethodPost, `/api/quotes`, http.StatusMethodNotAllowed, `method POST not allowed`},
+		{http.MethodDelete, `/api/watchlists`, http.StatusMethodNotAllowed, `method DELETE not allowed`},
+		{http.MethodGet, `/api/filter`, http.StatusBadRequest, `missing expr parameter`},
+		{http.MethodPost, `/api/watchlists?name=nope`, http.StatusNotFound, `unknown watchlist "nope"`},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(test.method, test.target, nil))
+
+		var body map[string]string
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: %v in %q", test.method, test.target, err, recorder.Body.String())
+		}
+		if recorder.Code != test.status || body[`error`] != test.message {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.method, test.target, recorder.Code, body[`error`], test.status, test.message)
+		}
+		if kind := recorder.Header().Get(`Content-Type`); kind != `application/json` {
+			t.Errorf("%s %s: content type %q", test.method, test.target, kind)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, `/api/filter?expr=last>`, nil))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), `"error"`) {
+		t.Errorf("invalid filter: got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestServerQuotes(t *testing.T) {
+	server := newFakeServer(t, &fakeProvider{prices: map[string]float64{`AAPL`: 190, `MSFT`: 410}})
+	server.Refresh()
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, `/api/quotes`, nil))
+	var stocks []Stock
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stocks); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusOK || len(stocks) != 2 {
+		t.Fatalf("got %d %s", recorder.Code, recorder.Body.String())
+	}
+	prices := map[string]float64{}
+	for _, stock := range stocks {
+		prices[stock.Ticker] = stock.LastTrade.Value
+	}
+	if prices[`AAPL`] != 190 || prices[`MSFT`] != 410 {
+		t.Errorf("got prices %v", prices)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	provider := &fakeProvider{prices: map[string]float64{`AAPL`: 190}}
+	server := newFakeServer(t, provider)
+	server.Refresh()
+
+	web := httptest.NewServer(server)
+	defer web.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, web.URL+`/api/events`, nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if kind := response.Header.Get(`Content-Type`); kind != `text/event-stream` {
+		t.Fatalf("content type %q", kind)
+	}
+	reader := bufio.NewReader(response.Body)
+
+	// The snapshot comes first, then the events of every refresh.
+	expect := func(name, data string) {
+		t.Helper()
+		event, payload := readEvent(t, reader)
+		if event != name || !strings.Contains(payload, data) {
+			t.Fatalf("got event %q with %s, want %q with %s", event, payload, name, data)
+		}
+	}
+	expect(`market`, `{`)
+	expect(`quotes`, `"ticker":"AAPL","last":190`)
+
+	provider.mutex.Lock()
+	provider.prices[`AAPL`] = 191
+	provider.mutex.Unlock()
+	server.Refresh()
+	expect(`market`, `{`)
+	expect(`quotes`, `"ticker":"AAPL","last":191`)
+
+	provider.fail(errors.New("service unavailable"))
+	server.refreshQuotes()
+	expect(`quotes`, `"error":"Error fetching stock quotes`)
+}
+
+// This function reads the next event of a Server-Sent Events stream, failing the test when none arrives in time.
+func readEvent(t *testing.T, reader *bufio.Reader) (name, data string) {
+	t.Helper()
+	type event struct {
+		name, data string
+		err        error
+	}
+	read := make(chan event, 1)
+	go func() {
+		var got event
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				got.err = err
+				break
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == `` && got.name != `` {
+				break
+			}
+			if strings.HasPrefix(line, `event: `) {
+				got.name = strings.TrimPrefix(line, `event: `)
+			} else if strings.HasPrefix(line, `data: `) {
+				got.data = strings.TrimPrefix(line, `data: `)
+			}
+		}
+		read <- got
+	}()
+
+	select {
+	case got := <-read:
+		if got.err != nil {
+			t.Fatal(got.err)
+		}
+		return got.name, got.data
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return
+}
@@ -11,14 +11,12 @@ It supports both ascending and descending sorting orders, and the sorting criter
 - `NewSorter`: Initializes the `Sorter` with the user's profile settings.
 - `SortByCurrentColumn`: Applies the appropriate sorting strategy based on the current profile settings (ascending/descending).
 - Helper functions:
-  - `c`: Converts and normalizes string values (e.g., percentage or monetary values).
-  - `m`: Converts string representations of values with multipliers (K, M, B, T) into numerical values.
+  - `lessNumber`, `lessInteger`: Compare numeric stock fields, ordering missing values before any reported value.
+  - `intradayChange`: Relative change over the intraday sparkline, used to sort by the sparkline column.
 */
 
 import (
 	"sort"
-	"strconv"
-	"strings"
 )
 
 type Sorter struct {
@@ -47,6 +45,11 @@ type byYieldAsc struct{ sortable }
 type byMarketCapAsc struct{ sortable }
 type byPreOpenAsc struct{ sortable }
 type byAfterHoursAsc struct{ sortable }
+type byIntradayAsc struct{ sortable }
+type byValueAsc struct{ sortable }
+type byDayPnLAsc struct{ sortable }
+type byUnrealizedPnLAsc struct{ sortable }
+type byWeightAsc struct{ sortable }
 
 type byTickerDesc struct{ sortable }
 type byLastTradeDesc struct{ sortable }
@@ -65,109 +68,144 @@ type byYieldDesc struct{ sortable }
 type byMarketCapDesc struct{ sortable }
 type byPreOpenDesc struct{ sortable }
 type byAfterHoursDesc struct{ sortable }
+type byIntradayDesc struct{ sortable }
+type byValueDesc struct{ sortable }
+type byDayPnLDesc struct{ sortable }
+type byUnrealizedPnLDesc struct{ sortable }
+type byWeightDesc struct{ sortable }
 
 func (list byTickerAsc) Less(i, j int) bool {
 	return list.sortable[i].Ticker < list.sortable[j].Ticker
 }
 func (list byLastTradeAsc) Less(i, j int) bool {
-	return list.sortable[i].LastTrade < list.sortable[j].LastTrade
+	return lessNumber(list.sortable[i].LastTrade, list.sortable[j].LastTrade)
 }
 func (list byChangeAsc) Less(i, j int) bool {
-	return c(list.sortable[i].Change) < c(list.sortable[j].Change)
+	return lessNumber(list.sortable[i].Change, list.sortable[j].Change)
 }
 func (list byChangePctAsc) Less(i, j int) bool {
-	return c(list.sortable[i].ChangePct) < c(list.sortable[j].ChangePct)
+	return lessNumber(list.sortable[i].ChangePct, list.sortable[j].ChangePct)
 }
 func (list byOpenAsc) Less(i, j int) bool {
-	return list.sortable[i].Open < list.sortable[j].Open
+	return lessNumber(list.sortable[i].Open, list.sortable[j].Open)
 }
 func (list byLowAsc) Less(i, j int) bool {
-	return list.sortable[i].Low < list.sortable[j].Low
+	return lessNumber(list.sortable[i].Low, list.sortable[j].Low)
 }
 func (list byHighAsc) Less(i, j int) bool {
-	return list.sortable[i].High < list.sortable[j].High
+	return lessNumber(list.sortable[i].High, list.sortable[j].High)
 }
 func (list byLow52Asc) Less(i, j int) bool {
-	return list.sortable[i].Low52 < list.sortable[j].Low52
+	return lessNumber(list.sortable[i].Low52, list.sortable[j].Low52)
 }
 func (list byHigh52Asc) Less(i, j int) bool {
-	return list.sortable[i].High52 < list.sortable[j].High52
+	return lessNumber(list.sortable[i].High52, list.sortable[j].High52)
 }
 func (list byVolumeAsc) Less(i, j int) bool {
-	return m(list.sortable[i].Volume) < m(list.sortable[j].Volume)
+	return lessInteger(list.sortable[i].Volume, list.sortable[j].Volume)
 }
 func (list byAvgVolumeAsc) Less(i, j int) bool {
-	return m(list.sortable[i].AvgVolume) < m(list.sortable[j].AvgVolume)
+	return lessInteger(list.sortable[i].AvgVolume, list.sortable[j].AvgVolume)
 }
 func (list byPeRatioAsc) Less(i, j int) bool {
-	return list.sortable[i].PeRatio < list.sortable[j].PeRatio
+	return lessNumber(list.sortable[i].PeRatio, list.sortable[j].PeRatio)
 }
 func (list byDividendAsc) Less(i, j int) bool {
-	return list.sortable[i].Dividend < list.sortable[j].Dividend
+	return lessNumber(list.sortable[i].Dividend, list.sortable[j].Dividend)
 }
 func (list byYieldAsc) Less(i, j int) bool {
-	return list.sortable[i].Yield < list.sortable[j].Yield
+	return lessNumber(list.sortable[i].Yield, list.sortable[j].Yield)
 }
 func (list byMarketCapAsc) Less(i, j int) bool {
-	return m(list.sortable[i].MarketCap) < m(list.sortable[j].MarketCap)
+	return lessNumber(list.sortable[i].MarketCap, list.sortable[j].MarketCap)
 }
 func (list byPreOpenAsc) Less(i, j int) bool {
-	return c(list.sortable[i].PreOpen) < c(list.sortable[j].PreOpen)
+	return lessNumber(list.sortable[i].PreOpen, list.sortable[j].PreOpen)
 }
 func (list byAfterHoursAsc) Less(i, j int) bool {
-	return c(list.sortable[i].AfterHours) < c(list.sortable[j].AfterHours)
+	return lessNumber(list.sortable[i].AfterHours, list.sortable[j].AfterHours)
+}
+func (list byValueAsc) Less(i, j int) bool {
+	return lessNumber(list.sortable[i].Value, list.sortable[j].Value)
+}
+func (list byDayPnLAsc) Less(i, j int) bool {
+	return lessNumber(list.sortable[i].DayPnL, list.sortable[j].DayPnL)
+}
+func (list byUnrealizedPnLAsc) Less(i, j int) bool {
+	return lessNumber(list.sortable[i].UnrealizedPnL, list.sortable[j].UnrealizedPnL)
+}
+func (list byWeightAsc) Less(i, j int) bool {
+	return lessNumber(list.sortable[i].Weight, list.sortable[j].Weight)
+}
+func (list byIntradayAsc) Less(i, j int) bool {
+	return lessNumber(intradayChange(list.sortable[i]), intradayChange(list.sortable[j]))
 }
 
 func (list byTickerDesc) Less(i, j int) bool {
 	return list.sortable[j].Ticker < list.sortable[i].Ticker
 }
 func (list byLastTradeDesc) Less(i, j int) bool {
-	return list.sortable[j].LastTrade < list.sortable[i].LastTrade
+	return lessNumber(list.sortable[j].LastTrade, list.sortable[i].LastTrade)
 }
 func (list byChangeDesc) Less(i, j int) bool {
-	return c(list.sortable[j].Change) < c(list.sortable[i].Change)
+	return lessNumber(list.sortable[j].Change, list.sortable[i].Change)
 }
 func (list byChangePctDesc) Less(i, j int) bool {
-	return c(list.sortable[j].ChangePct) < c(list.sortable[i].ChangePct)
+	return lessNumber(list.sortable[j].ChangePct, list.sortable[i].ChangePct)
 }
 func (list byOpenDesc) Less(i, j int) bool {
-	return list.sortable[j].Open < list.sortable[i].Open
+	return lessNumber(list.sortable[j].Open, list.sortable[i].Open)
 }
 func (list byLowDesc) Less(i, j int) bool {
-	return list.sortable[j].Low < list.sortable[i].Low
+	return lessNumber(list.sortable[j].Low, list.sortable[i].Low)
 }
 func (list byHighDesc) Less(i, j int) bool {
-	return list.sortable[j].High < list.sortable[i].High
+	return lessNumber(list.sortable[j].High, list.sortable[i].High)
 }
 func (list byLow52Desc) Less(i, j int) bool {
-	return list.sortable[j].Low52 < list.sortable[i].Low52
+	return lessNumber(list.sortable[j].Low52, list.sortable[i].Low52)
 }
 func (list byHigh52Desc) Less(i, j int) bool {
-	return list.sortable[j].High52 < list.sortable[i].High52
+	return lessNumber(list.sortable[j].High52, list.sortable[i].High52)
 }
 func (list byVolumeDesc) Less(i, j int) bool {
-	return m(list.sortable[j].Volume) < m(list.sortable[i].Volume)
+	return lessInteger(list.sortable[j].Volume, list.sortable[i].Volume)
 }
 func (list byAvgVolumeDesc) Less(i, j int) bool {
-	return m(list.sortable[j].AvgVolume) < m(list.sortable[i].AvgVolume)
+	return lessInteger(list.sortable[j].AvgVolume, list.sortable[i].AvgVolume)
 }
 func (list byPeRatioDesc) Less(i, j int) bool {
-	return list.sortable[j].PeRatio < list.sortable[i].PeRatio
+	return lessNumber(list.sortable[j].PeRatio, list.sortable[i].PeRatio)
 }
 func (list byDividendDesc) Less(i, j int) bool {
-	return list.sortable[j].Dividend < list.sortable[i].Dividend
+	return lessNumber(list.sortable[j].Dividend, list.sortable[i].Dividend)
 }
 func (list byYieldDesc) Less(i, j int) bool {
-	return list.sortable[j].Yield < list.sortable[i].Yield
+	return lessNumber(list.sortable[j].Yield, list.sortable[i].Yield)
 }
 func (list byMarketCapDesc) Less(i, j int) bool {
-	return m(list.sortable[j].MarketCap) < m(list.sortable[i].MarketCap)
+	return lessNumber(list.sortable[j].MarketCap, list.sortable[i].MarketCap)
 }
 func (list byPreOpenDesc) Less(i, j int) bool {
-	return c(list.sortable[j].PreOpen) < c(list.sortable[i].PreOpen)
+	return lessNumber(list.sortable[j].PreOpen, list.sortable[i].PreOpen)
 }
 func (list byAfterHoursDesc) Less(i, j int) bool {
-	return c(list.sortable[j].AfterHours) < c(list.sortable[i].AfterHours)
+	return lessNumber(list.sortable[j].AfterHours, list.sortable[i].AfterHours)
+}
+func (list byValueDesc) Less(i, j int) bool {
+	return lessNumber(list.sortable[j].Value, list.sortable[i].Value)
+}
+func (list byDayPnLDesc) Less(i, j int) bool {
+	return lessNumber(list.sortable[j].DayPnL, list.sortable[i].DayPnL)
+}
+func (list byUnrealizedPnLDesc) Less(i, j int) bool {
+	return lessNumber(list.sortable[j].UnrealizedPnL, list.sortable[i].UnrealizedPnL)
+}
+func (list byWeightDesc) Less(i, j int) bool {
+	return lessNumber(list.sortable[j].Weight, list.sortable[i].Weight)
+}
+func (list byIntradayDesc) Less(i, j int) bool {
+	return lessNumber(intradayChange(list.sortable[j]), intradayChange(list.sortable[i]))
 }
 func NewSorter(profile *Profile) *Sorter {
 	return &Sorter{
@@ -196,6 +234,11 @@ func (sorter *Sorter) SortByCurrentColumn(stocks []Stock) *Sorter {
 			byMarketCapAsc{stocks},
 			byPreOpenAsc{stocks},
 			byAfterHoursAsc{stocks},
+			byIntradayAsc{stocks},
+			byValueAsc{stocks},
+			byDayPnLAsc{stocks},
+			byUnrealizedPnLAsc{stocks},
+			byWeightAsc{stocks},
 		}
 	} else {
 		interfaces = []sort.Interface{
@@ -216,40 +259,42 @@ func (sorter *Sorter) SortByCurrentColumn(stocks []Stock) *Sorter {
 			byMarketCapDesc{stocks},
 			byPreOpenDesc{stocks},
 			byAfterHoursDesc{stocks},
+			byIntradayDesc{stocks},
+			byValueDesc{stocks},
+			byDayPnLDesc{stocks},
+			byUnrealizedPnLDesc{stocks},
+			byWeightDesc{stocks},
 		}
 	}
 
+	if sorter.profile.SortColumn < 0 || sorter.profile.SortColumn >= len(interfaces) {
+		return sorter
+	}
 	sort.Sort(interfaces[sorter.profile.SortColumn])
 
 	return sorter
 }
-func c(str string) float32 {
-	c := "$"
-	for _, v := range currencies {
-		if strings.Contains(str, v) {
-			c = v
-		}
+// -----------------------------------------------------------------------------
+func lessNumber(a, b Number) bool {
+	if !a.Valid || !b.Valid {
+		return !a.Valid && b.Valid
 	}
-	trimmed := strings.Replace(strings.Trim(str, ` %`), c, ``, 1)
-	value, _ := strconv.ParseFloat(trimmed, 32)
-	return float32(value)
+	return a.Value < b.Value
 }
-func m(str string) float32 {
-	if len(str) == 0 {
-		return 0
+
+// -----------------------------------------------------------------------------
+func lessInteger(a, b Integer) bool {
+	if !a.Valid || !b.Valid {
+		return !a.Valid && b.Valid
 	}
-	multiplier := 1.0
-	switch str[len(str)-1:] {
-	case `T`:
-		multiplier = 1000000000000.0
-	case `B`:
-		multiplier = 1000000000.0
-	case `M`:
-		multiplier = 1000000.0
-	case `K`:
-		multiplier = 1000.0
+	return a.Value < b.Value
+}
+
+// -----------------------------------------------------------------------------
+func intradayChange(stock Stock) Number {
+	points := stock.Intraday
+	if len(points) < 2 || points[0] == 0 {
+		return Number{}
 	}
-	trimmed := strings.Trim(str, ` $TBMK`)
-	value, _ := strconv.ParseFloat(trimmed, 32)
-	return float32(value * multiplier)
+	return Number{Value: points[len(points)-1]/points[0] - 1, Valid: true}
 }
@@ -0,0 +1,166 @@
+package mop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Fundamentals holds the figures of a ticker that are only fetched on demand for the detail pane.
+type Fundamentals struct {
+	Exchange             string    `json:"exchange"`
+	EPS                  Number    `json:"eps"`                  // Trailing twelve months earnings per share.
+	ForwardPE            Number    `json:"forwardPe"`            // P/E ratio on expected earnings.
+	Beta                 Number    `json:"beta"`                 // Volatility relative to the market.
+	SharesOutstanding    Integer   `json:"sharesOutstanding"`    // Number of shares issued.
+	EarningsDate         time.Time `json:"earningsDate"`         // Next (or last) earnings announcement, zero when unknown.
+	FiftyDayAverage      Number    `json:"fiftyDayAverage"`      // 50-day moving average of the close.
+	TwoHundredDayAverage Number    `json:"twoHundredDayAverage"` // 200-day moving average of the close.
+	Bid                  Number    `json:"bid"`
+	Ask                  Number    `json:"ask"`
+	BidSize              Integer   `json:"bidSize"`
+	AskSize              Integer   `json:"askSize"`
+}
+
+/*
+The detail pane replaces the quotes table with every field known about one stock: the whole `Stock` as last
+fetched, including the fields the table has no column for, followed by its `Fundamentals`, which are fetched
+from the provider when the pane opens and again when `r` is pressed. They are fetched in the background, see
+FetchWorker.go, and drawn when the main loop applies them from the Fetched channel. Esc returns to the table.
+*/
+type DetailPane struct {
+	screen       *Screen
+	provider     QuoteProvider
+	stock        Stock
+	fundamentals Fundamentals
+	err          error        // Error fetching the fundamentals, if any.
+	loading      bool         // True while the fundamentals are being fetched.
+	worker       *fetchWorker // Fetches the fundamentals.
+	template     *template.Template
+}
+
+// This function creates the detail pane of the stock, draws it and starts fetching its fundamentals.
+func NewDetailPane(screen *Screen, provider QuoteProvider, stock Stock) *DetailPane {
+	pane := &DetailPane{
+		screen:   screen,
+		provider: provider,
+		stock:    stock,
+		worker:   newFetchWorker(),
+		template: buildDetailTemplate(stock.Currency),
+	}
+
+	return pane.fetch().Draw()
+}
+
+// This function returns the channel delivering the fetched fundamentals, to be applied with FetchResult.Apply, which draws the pane. It returns nil for a nil pane, so that it can be selected on while no pane is shown.
+func (pane *DetailPane) Fetched() <-chan FetchResult {
+	if pane == nil {
+		return nil
+	}
+	return pane.worker.results
+}
+
+// This function handles a key press while the pane is shown. It returns true when the user leaves the pane, which cancels the fetch under way.
+func (pane *DetailPane) Handle(event termbox.Event) bool {
+	switch {
+	case event.Key == termbox.KeyEsc || event.Ch == 'q' || event.Ch == 'Q':
+		pane.worker.stop()
+		return true
+	case event.Ch == 'r' || event.Ch == 'R':
+		pane.fetch().Draw()
+	}
+
+	return false
+}
+
+// This function redraws the pane, e.g. after the terminal has been resized.
+func (pane *DetailPane) Draw() *DetailPane {
+	vars := struct {
+		Stock        Stock
+		Fundamentals Fundamentals
+		Err          error
+		Loading      bool
+	}{
+		pane.stock,
+		pane.fundamentals,
+		pane.err,
+		pane.loading,
+	}
+
+	buffer := new(bytes.Buffer)
+	pane.template.Execute(buffer, vars)
+	pane.screen.Clear().Draw(buffer.String())
+
+	return pane
+}
+
+// This function asks the worker for the fundamentals, cancelling the fetch still under way. The fundamentals shown stay until the new ones arrive.
+func (pane *DetailPane) fetch() *DetailPane {
+	pane.loading = true
+	ticker := pane.stock.Ticker
+	pane.worker.cancelAll()
+	pane.worker.submit(func(ctx context.Context) func() {
+		fundamentals, err := pane.provider.FetchFundamentals(ctx, ticker)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return func() {
+			pane.fundamentals, pane.err, pane.loading = fundamentals, err, false
+			pane.Draw()
+		}
+	})
+
+	return pane
+}
+
+// -----------------------------------------------------------------------------
+func buildDetailTemplate(currencyCode string) *template.Template {
+	markup := `<header><b>{{.Stock.Ticker}}</b></> {{.Fundamentals.Exchange}} {{.Stock.Currency}}  {{direction .Stock.Direction (money .Stock.LastTrade)}} {{direction .Stock.Direction (money .Stock.Change)}} ({{direction .Stock.Direction (pct .Stock.ChangePct)}})
+
+<u>Quote                                 </u>   <u>Fundamentals                      </u>
+<tag>Open</>             {{left (money .Stock.Open)}}   <tag>EPS (ttm)</>       {{right (money .Fundamentals.EPS)}}
+<tag>Day's range</>      {{left (printf "%s - %s" (money .Stock.Low) (money .Stock.High))}}   <tag>P/E (ttm)</>       {{right (num .Stock.PeRatio)}}
+<tag>52w range</>        {{left (printf "%s - %s" (money .Stock.Low52) (money .Stock.High52))}}   <tag>P/E (fallback)</>  {{right (num .Stock.PeRatioX)}}
+<tag>Volume</>           {{left (int .Stock.Volume)}}   <tag>Forward P/E</>     {{right (num .Fundamentals.ForwardPE)}}
+<tag>Avg volume</>       {{left (int .Stock.AvgVolume)}}   <tag>Beta</>            {{right (num .Fundamentals.Beta)}}
+<tag>Bid</>              {{left (printf "%s x %s" (money .Fundamentals.Bid) (int .Fundamentals.BidSize))}}   <tag>Shares out</>      {{right (int .Fundamentals.SharesOutstanding)}}
+<tag>Ask</>              {{left (printf "%s x %s" (money .Fundamentals.Ask) (int .Fundamentals.AskSize))}}   <tag>Market cap</>      {{right (money .Stock.MarketCap)}}
+<tag>50-day average</>   {{left (money .Fundamentals.FiftyDayAverage)}}   <tag>Market cap (fb)</> {{right (money .Stock.MarketCapX)}}
+<tag>200-day average</>  {{left (money .Fundamentals.TwoHundredDayAverage)}}   <tag>Dividend</>        {{right (money .Stock.Dividend)}}
+<tag>Pre-market</>       {{left (pct .Stock.PreOpen)}}   <tag>Yield</>           {{right (frac .Stock.Yield)}}
+<tag>After hours</>      {{left (pct .Stock.AfterHours)}}   <tag>Earnings date</>   {{right (date .Fundamentals.EarningsDate)}}
+{{if .Err}}
+<loss>Unable to fetch fundamentals: {{.Err}}</>
+{{else if .Loading}}
+<stale>Fetching fundamentals...</>
+{{end}}
+<r> r refresh  Esc back </r>`
+
+	return template.Must(template.New(`detail`).Funcs(template.FuncMap{
+		`money`: func(value interface{}) string { return currency(value, currencyCode) },
+		`num`:   func(value Number) string { return blank(value, ``) },
+		`pct`:   func(value Number) string { return percent(value, ``) },
+		`frac`:  func(value Number) string { return fraction(value, ``) },
+		`int`:   func(value Integer) string { return integer(value, ``) },
+		`left`:  func(str string) string { return fmt.Sprintf(`%21s`, str) },
+		`right`: func(str string) string { return fmt.Sprintf(`%16s`, str) },
+		`date`: func(value time.Time) string {
+			if value.IsZero() {
+				return `-`
+			}
+			return value.Local().Format(`Jan 2, 2006`)
+		},
+		`direction`: func(direction int, str string) string {
+			if direction > 0 {
+				return `<gain>` + str + `</>`
+			} else if direction < 0 {
+				return `<loss>` + str + `</>`
+			}
+			return str
+		},
+	}).Parse(markup))
+}
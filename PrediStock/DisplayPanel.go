@@ -2,6 +2,7 @@ package mop
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -99,16 +100,116 @@ func (screen *Screen) ScrollBottom() {
 	}
 }
 
+// This function moves the row cursor by `n` rows, down when positive and up when negative, and scrolls so that the selected row stays visible. Without a visible selection the cursor starts at the top row.
+func (screen *Screen) MoveSelection(profile *Profile, n int) {
+	tickers := screen.layout.tickers
+	if len(tickers) == 0 {
+		return
+	}
+	current := screen.selectedRow(profile)
+	if current < 0 {
+		current, n = 0, 0
+	}
+	current += n
+	if current < 0 {
+		current = 0
+	} else if current > len(tickers)-1 {
+		current = len(tickers) - 1
+	}
+	profile.selectedTicker = tickers[current]
+
+	if current < screen.offset {
+		screen.offset = current
+	} else if visible := screen.height - screen.headerLine - 1; visible > 0 && current >= screen.offset+visible {
+		screen.offset = current - visible + 1
+	}
+}
+
+// This function selects the row displayed on screen line `y`, e.g. after a mouse click. It returns false when the line shows no stock.
+func (screen *Screen) SelectAt(profile *Profile, y int) bool {
+	row := y - screen.headerLine - 1 + screen.offset
+	if y <= screen.headerLine || row < 0 || row >= len(screen.layout.tickers) {
+		return false
+	}
+	profile.selectedTicker = screen.layout.tickers[row]
+	return true
+}
+
+// -----------------------------------------------------------------------------
+func (screen *Screen) selectedRow(profile *Profile) int {
+	for i, ticker := range screen.layout.tickers {
+		if ticker == profile.selectedTicker {
+			return i
+		}
+	}
+	return -1
+}
+
+// This function draws the status line above the quotes table, which is also where the line editor prompts: the banner of recently fired alerts, or else a failing sign-in to the provider, fetch or alert, or else the name of the active watchlist.
+func (screen *Screen) DrawStatus(quotes *Quotes) {
+	screen.ClearLine(0, 3)
+	if banner := quotes.AlertBanner(); banner != `` {
+		screen.DrawLine(0, 3, `<r> `+banner+` </r>`)
+	} else if status := quotes.AuthStatus(); status != `` {
+		screen.DrawLine(0, 3, `<loss>`+status+`</>`)
+	} else if status := quotes.FetchStatus(); status != `` {
+		screen.DrawLine(0, 3, `<loss>`+status+`</>`)
+	} else if status := quotes.AlertStatus(); status != `` {
+		screen.DrawLine(0, 3, `<loss>`+status+`</>`)
+	} else if name := quotes.profile.ActiveWatchlist(); name != `` {
+		screen.DrawLine(0, 3, `<tag>Watchlist</> `+name)
+	}
+}
+
+// This function rings the terminal bell once what was drawn reached the terminal, so that the bell does not land in the middle of it.
+func (screen *Screen) Bell() {
+	termbox.Flush()
+	os.Stdout.WriteString("\a")
+}
+
 func (screen *Screen) DrawOldQuotes(quotes *Quotes) {
 	screen.draw(screen.layout.Quotes(quotes), true)
 	termbox.Flush()
 }
 
+// This function redraws the rows of the given tickers after a streamed update, leaving the rest of the screen alone. The whole table is redrawn when the update changed the order of the rows.
+func (screen *Screen) DrawChanged(quotes *Quotes, tickers []string) {
+	before := append([]string(nil), screen.layout.tickers...)
+	str := screen.layout.Quotes(quotes)
+	if !screen.cleared || strings.Join(before, `,`) != strings.Join(screen.layout.tickers, `,`) {
+		screen.draw(str, true)
+		termbox.Flush()
+		return
+	}
+
+	changed := make(map[string]bool, len(tickers))
+	for _, ticker := range tickers {
+		changed[ticker] = true
+	}
+	lines := strings.Split(str, "\n")
+	rows := len(screen.layout.tickers)
+	for row := screen.headerLine + 1; row < len(lines) && row <= screen.headerLine+1+rows; row++ {
+		y := row - screen.offset
+		if y <= screen.headerLine || y >= screen.height {
+			continue
+		}
+		// The line after the last row is the totals row, if any, which follows every change. The line is blanked first, as the row may have become shorter, e.g. when its stale note went away.
+		if i := row - screen.headerLine - 1; i == rows || changed[screen.layout.tickers[i]] {
+			for x := 0; x < screen.width; x++ {
+				termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+			}
+			screen.DrawLineFlush(0, y, lines[row], false)
+		}
+	}
+	termbox.Flush()
+}
+
 func (screen *Screen) DrawOldMarket(market *Market) {
 	screen.draw(screen.layout.Market(market), false)
 	termbox.Flush()
 }
 
+// This function draws the market, the quotes, the time or a string. The market and the quotes are drawn as last fetched and asked to refresh; the main loop draws them again once the fetch is applied.
 func (screen *Screen) Draw(objects ...interface{}) *Screen {
 	zonename, _ := time.Now().In(time.Local).Zone()
 	if screen.pausedAt != nil {
@@ -118,10 +219,12 @@ func (screen *Screen) Draw(objects ...interface{}) *Screen {
 		switch ptr.(type) {
 		case *Market:
 			object := ptr.(*Market)
-			screen.draw(screen.layout.Market(object.Fetch()), false)
+			object.Refresh()
+			screen.draw(screen.layout.Market(object), false)
 		case *Quotes:
 			object := ptr.(*Quotes)
-			screen.draw(screen.layout.Quotes(object.Fetch()), true)
+			object.Refresh()
+			screen.draw(screen.layout.Quotes(object), true)
 		case time.Time:
 			timestamp := ptr.(time.Time).Format(`3:04:05pm ` + zonename)
 			screen.DrawLineInverted(0, 0, `<right><time>`+timestamp+`</></right>`)
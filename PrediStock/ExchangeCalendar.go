@@ -0,0 +1,413 @@
+package mop
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // Exchange time zones must resolve on systems without a zoneinfo database.
+)
+
+/*
+The exchange calendar tells which trading session an exchange is in at a given time, in the exchange's own
+time zone: pre-market, regular hours, after hours or closed. It knows the regular hours, the lunch breaks of
+Tokyo and Hong Kong, the holidays and the early closes of:
+
+- `NYSE` (also used for NASDAQ): 09:30-16:00 New York, pre-market from 04:00, after hours until 20:00.
+- `LSE`: 08:00-16:30 London.
+- `XETRA`: 09:00-17:30 Frankfurt.
+- `TSE`: 09:00-11:30 and 12:30-15:30 Tokyo.
+- `HKEX`: 09:30-12:00 and 13:00-16:00 Hong Kong.
+
+Holidays are computed from their rules, Easter and the equinoxes included. The lunar holidays of Hong Kong
+cannot be, so they are listed for 2024 to 2027; later years only get the fixed-date ones.
+
+Tickers are assigned to an exchange by their Yahoo suffix (`.L`, `.DE`, `.T`, `.HK`, none for the U.S.);
+currencies, futures, crypto currencies and other suffixes have no calendar and are always considered open.
+*/
+
+// Session is the trading session an exchange is in.
+type Session int
+
+const (
+	SessionClosed Session = iota
+	SessionPreMarket
+	SessionRegular
+	SessionAfterHours
+)
+
+// How often quotes and market data are refreshed while every exchange they trade on is closed.
+const closedRefresh = 5 * time.Minute
+
+// Exchange describes the trading hours of an exchange. Times are minutes after midnight in the exchange's time zone.
+type Exchange struct {
+	Name      string
+	Location  *time.Location
+	PreOpen   int    // Start of the pre-market, equal to Open when there is none.
+	Open      int    // Start of regular hours.
+	Close     int    // End of regular hours.
+	PostClose int    // End of the after hours session, equal to Close when there is none.
+	Lunch     [2]int // Lunch break during regular hours, zero when there is none.
+
+	holidays func(year int) map[string]int // Closures of a year by date: 0 when closed all day, the time of an early close otherwise.
+	years    map[int]map[string]int        // Cached holidays per year.
+	mutex    sync.Mutex                    // Guards the cache.
+}
+
+// Exchanges lists the exchanges of the calendar by name.
+var Exchanges = map[string]*Exchange{
+	`NYSE`:  {Name: `NYSE`, Location: exchangeZone(`America/New_York`), PreOpen: 4 * 60, Open: 9*60 + 30, Close: 16 * 60, PostClose: 20 * 60, holidays: nyseHolidays},
+	`LSE`:   {Name: `LSE`, Location: exchangeZone(`Europe/London`), PreOpen: 8 * 60, Open: 8 * 60, Close: 16*60 + 30, PostClose: 16*60 + 30, holidays: lseHolidays},
+	`XETRA`: {Name: `XETRA`, Location: exchangeZone(`Europe/Berlin`), PreOpen: 9 * 60, Open: 9 * 60, Close: 17*60 + 30, PostClose: 17*60 + 30, holidays: xetraHolidays},
+	`TSE`:   {Name: `TSE`, Location: exchangeZone(`Asia/Tokyo`), PreOpen: 9 * 60, Open: 9 * 60, Close: 15*60 + 30, PostClose: 15*60 + 30, Lunch: [2]int{11*60 + 30, 12*60 + 30}, holidays: tseHolidays},
+	`HKEX`:  {Name: `HKEX`, Location: exchangeZone(`Asia/Hong_Kong`), PreOpen: 9*60 + 30, Open: 9*60 + 30, Close: 16 * 60, PostClose: 16 * 60, Lunch: [2]int{12 * 60, 13 * 60}, holidays: hkexHolidays},
+}
+
+// Exchanges of the tickers by Yahoo suffix, and of the indexes shown in the market header.
+var exchangeSuffixes = map[string]string{`.L`: `LSE`, `.DE`: `XETRA`, `.T`: `TSE`, `.HK`: `HKEX`}
+var exchangeIndexes = map[string]string{`^N225`: `TSE`, `^HSI`: `HKEX`, `^FTSE`: `LSE`, `^GDAXI`: `XETRA`}
+
+// This function returns the exchange a ticker trades on, or nil when it has no calendar.
+func ExchangeOf(ticker string) *Exchange {
+	ticker = strings.ToUpper(ticker)
+	if name, ok := exchangeIndexes[ticker]; ok {
+		return Exchanges[name]
+	}
+	if strings.HasPrefix(ticker, `^`) {
+		return Exchanges[`NYSE`]
+	}
+	if strings.Contains(ticker, `=`) {
+		return nil
+	}
+	if dash := strings.LastIndex(ticker, `-`); dash >= 0 && len(ticker)-dash-1 >= 3 && !strings.Contains(ticker, `.`) {
+		return nil // Crypto currencies such as BTC-USD; share classes such as BRK-B have a shorter suffix.
+	}
+	if dot := strings.LastIndex(ticker, `.`); dot >= 0 {
+		if name, ok := exchangeSuffixes[ticker[dot:]]; ok {
+			return Exchanges[name]
+		}
+		if len(ticker)-dot-1 <= 2 {
+			return nil
+		}
+	}
+	return Exchanges[`NYSE`]
+}
+
+// This function returns the session the exchange is in at the given time.
+func (exchange *Exchange) SessionAt(at time.Time) Session {
+	local := at.In(exchange.Location)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return SessionClosed
+	}
+	closes, post := exchange.Close, exchange.PostClose
+	if early, ok := exchange.closures(local.Year())[local.Format(storeDayLayout)]; ok {
+		if early == 0 {
+			return SessionClosed
+		}
+		post = early + (post - closes)
+		closes = early
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	switch {
+	case minute >= exchange.Open && minute < closes:
+		if minute >= exchange.Lunch[0] && minute < exchange.Lunch[1] {
+			return SessionClosed
+		}
+		return SessionRegular
+	case minute >= exchange.PreOpen && minute < exchange.Open:
+		return SessionPreMarket
+	case minute >= closes && minute < post:
+		return SessionAfterHours
+	}
+	return SessionClosed
+}
+
+// This function returns the name of the session.
+func (session Session) String() string {
+	switch session {
+	case SessionPreMarket:
+		return `pre-market`
+	case SessionRegular:
+		return `open`
+	case SessionAfterHours:
+		return `after hours`
+	}
+	return `closed`
+}
+
+// This function reports whether the session is the pre-market or the after hours session.
+func (session Session) Extended() bool {
+	return session == SessionPreMarket || session == SessionAfterHours
+}
+
+// This function writes the session as its name in JSON.
+func (session Session) MarshalText() ([]byte, error) {
+	return []byte(session.String()), nil
+}
+
+// This function reports whether every exchange the tickers trade on is closed at the given time. Tickers without a calendar are always open.
+func allClosed(tickers []string, at time.Time) bool {
+	for _, ticker := range tickers {
+		if exchange := ExchangeOf(ticker); exchange == nil || exchange.SessionAt(at) != SessionClosed {
+			return false
+		}
+	}
+	return true
+}
+
+// -----------------------------------------------------------------------------
+func (exchange *Exchange) closures(year int) map[string]int {
+	exchange.mutex.Lock()
+	defer exchange.mutex.Unlock()
+
+	if exchange.years == nil {
+		exchange.years = make(map[int]map[string]int)
+	}
+	if _, ok := exchange.years[year]; !ok {
+		exchange.years[year] = exchange.holidays(year)
+	}
+	return exchange.years[year]
+}
+
+// -----------------------------------------------------------------------------
+func exchangeZone(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("loading time zone %s: %v", name, err))
+	}
+	return location
+}
+
+// -----------------------------------------------------------------------------
+func nyseHolidays(year int) map[string]int {
+	closed := make(map[string]int)
+	add := func(day time.Time) { closed[day.Format(storeDayLayout)] = 0 }
+	early := func(day time.Time) {
+		if _, ok := closed[day.Format(storeDayLayout)]; !ok && !weekend(day) {
+			closed[day.Format(storeDayLayout)] = 13 * 60
+		}
+	}
+
+	if newYear := calendarDay(year, time.January, 1); newYear.Weekday() != time.Saturday {
+		add(observedUS(newYear))
+	}
+	add(nthWeekday(year, time.January, time.Monday, 3))
+	add(nthWeekday(year, time.February, time.Monday, 3))
+	add(easter(year).AddDate(0, 0, -2))
+	add(nthWeekday(year, time.May, time.Monday, -1))
+	if year >= 2022 {
+		add(observedUS(calendarDay(year, time.June, 19)))
+	}
+	add(observedUS(calendarDay(year, time.July, 4)))
+	add(nthWeekday(year, time.September, time.Monday, 1))
+	thanksgiving := nthWeekday(year, time.November, time.Thursday, 4)
+	add(thanksgiving)
+	add(observedUS(calendarDay(year, time.December, 25)))
+
+	early(calendarDay(year, time.July, 3))
+	early(thanksgiving.AddDate(0, 0, 1))
+	early(calendarDay(year, time.December, 24))
+
+	return closed
+}
+
+// -----------------------------------------------------------------------------
+func lseHolidays(year int) map[string]int {
+	closed := make(map[string]int)
+	add := func(day time.Time) { closed[day.Format(storeDayLayout)] = 0 }
+
+	add(nextWeekday(calendarDay(year, time.January, 1)))
+	add(easter(year).AddDate(0, 0, -2))
+	add(easter(year).AddDate(0, 0, 1))
+	add(nthWeekday(year, time.May, time.Monday, 1))
+	add(nthWeekday(year, time.May, time.Monday, -1))
+	add(nthWeekday(year, time.August, time.Monday, -1))
+	christmas := nextWeekday(calendarDay(year, time.December, 25))
+	add(christmas)
+	add(nextWeekday(christmas.AddDate(0, 0, 1)))
+
+	for _, day := range []time.Time{calendarDay(year, time.December, 24), calendarDay(year, time.December, 31)} {
+		if !weekend(day) {
+			closed[day.Format(storeDayLayout)] = 12*60 + 30
+		}
+	}
+
+	return closed
+}
+
+// -----------------------------------------------------------------------------
+func xetraHolidays(year int) map[string]int {
+	closed := make(map[string]int)
+	for _, day := range []time.Time{
+		calendarDay(year, time.January, 1),
+		easter(year).AddDate(0, 0, -2),
+		easter(year).AddDate(0, 0, 1),
+		calendarDay(year, time.May, 1),
+		calendarDay(year, time.December, 24),
+		calendarDay(year, time.December, 25),
+		calendarDay(year, time.December, 26),
+		calendarDay(year, time.December, 31),
+	} {
+		closed[day.Format(storeDayLayout)] = 0
+	}
+
+	return closed
+}
+
+// -----------------------------------------------------------------------------
+func tseHolidays(year int) map[string]int {
+	// National holidays, valid from 2020 on. The equinox formulas hold from 1980 to 2099.
+	vernal := int(20.8431+0.242194*float64(year-1980)) - (year-1980)/4
+	autumnal := int(23.2488+0.242194*float64(year-1980)) - (year-1980)/4
+	national := []time.Time{
+		calendarDay(year, time.January, 1),
+		nthWeekday(year, time.January, time.Monday, 2),
+		calendarDay(year, time.February, 11),
+		calendarDay(year, time.February, 23),
+		calendarDay(year, time.March, vernal),
+		calendarDay(year, time.April, 29),
+		calendarDay(year, time.May, 3),
+		calendarDay(year, time.May, 4),
+		calendarDay(year, time.May, 5),
+		nthWeekday(year, time.July, time.Monday, 3),
+		calendarDay(year, time.August, 11),
+		nthWeekday(year, time.September, time.Monday, 3),
+		calendarDay(year, time.September, autumnal),
+		nthWeekday(year, time.October, time.Monday, 2),
+		calendarDay(year, time.November, 3),
+		calendarDay(year, time.November, 23),
+	}
+
+	closed := make(map[string]int)
+	isHoliday := func(day time.Time) bool { _, ok := closed[day.Format(storeDayLayout)]; return ok }
+	for _, day := range national {
+		closed[day.Format(storeDayLayout)] = 0
+	}
+	// A holiday falling on a Sunday moves to the next day that is not a holiday.
+	for _, day := range national {
+		if day.Weekday() == time.Sunday {
+			substitute := day.AddDate(0, 0, 1)
+			for isHoliday(substitute) {
+				substitute = substitute.AddDate(0, 0, 1)
+			}
+			closed[substitute.Format(storeDayLayout)] = 0
+		}
+	}
+	// A day between two holidays is a holiday too.
+	for _, day := range national {
+		if between := day.AddDate(0, 0, 1); !isHoliday(between) && isHoliday(between.AddDate(0, 0, 1)) && !weekend(between) {
+			closed[between.Format(storeDayLayout)] = 0
+		}
+	}
+	// The exchange also closes for the new year.
+	for _, day := range []time.Time{calendarDay(year, time.January, 2), calendarDay(year, time.January, 3), calendarDay(year, time.December, 31)} {
+		closed[day.Format(storeDayLayout)] = 0
+	}
+
+	return closed
+}
+
+// Lunar new year, Ching Ming, Buddha's birthday, Tuen Ng, the day after Mid-Autumn and Chung Yeung closures of
+// Hong Kong, already moved off weekends, and the lunar new year's eve half day.
+var hkexLunarHolidays = map[int][]string{
+	2024: {`2024-02-12`, `2024-02-13`, `2024-04-04`, `2024-05-15`, `2024-06-10`, `2024-09-18`, `2024-10-11`},
+	2025: {`2025-01-29`, `2025-01-30`, `2025-01-31`, `2025-04-04`, `2025-05-05`, `2025-10-07`, `2025-10-29`},
+	2026: {`2026-02-17`, `2026-02-18`, `2026-02-19`, `2026-04-07`, `2026-05-25`, `2026-06-19`, `2026-10-19`},
+	2027: {`2027-02-08`, `2027-02-09`, `2027-04-05`, `2027-05-13`, `2027-06-09`, `2027-09-16`, `2027-10-08`},
+}
+var hkexLunarEves = map[int]string{2024: `2024-02-09`, 2025: `2025-01-28`, 2026: `2026-02-16`, 2027: `2027-02-05`}
+
+// -----------------------------------------------------------------------------
+func hkexHolidays(year int) map[string]int {
+	closed := make(map[string]int)
+	add := func(day time.Time) {
+		if day.Weekday() == time.Sunday {
+			day = day.AddDate(0, 0, 1)
+		}
+		closed[day.Format(storeDayLayout)] = 0
+	}
+
+	add(calendarDay(year, time.January, 1))
+	add(easter(year).AddDate(0, 0, -2))
+	add(easter(year).AddDate(0, 0, 1))
+	add(calendarDay(year, time.May, 1))
+	add(calendarDay(year, time.July, 1))
+	add(calendarDay(year, time.October, 1))
+	add(calendarDay(year, time.December, 25))
+	boxing := calendarDay(year, time.December, 26)
+	if boxing.Weekday() == time.Monday {
+		boxing = boxing.AddDate(0, 0, 1) // Christmas fell on a Sunday and took the Monday.
+	}
+	add(boxing)
+	for _, day := range hkexLunarHolidays[year] {
+		closed[day] = 0
+	}
+
+	halfDays := []time.Time{calendarDay(year, time.December, 24), calendarDay(year, time.December, 31)}
+	if eve, err := time.ParseInLocation(storeDayLayout, hkexLunarEves[year], time.UTC); err == nil {
+		halfDays = append(halfDays, eve)
+	}
+	for _, day := range halfDays {
+		if _, ok := closed[day.Format(storeDayLayout)]; !ok && !weekend(day) {
+			closed[day.Format(storeDayLayout)] = 12 * 60
+		}
+	}
+
+	return closed
+}
+
+// -----------------------------------------------------------------------------
+func calendarDay(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// -----------------------------------------------------------------------------
+func weekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
+
+// This function returns the n-th given weekday of the month, or the last one when n is -1.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n < 0 {
+		last := calendarDay(year, month+1, 0)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(weekday) + 7) % 7))
+	}
+	first := calendarDay(year, month, 1)
+	return first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+7*(n-1))
+}
+
+// This function moves a U.S. holiday falling on a weekend to the Friday before or the Monday after.
+func observedUS(day time.Time) time.Time {
+	switch day.Weekday() {
+	case time.Saturday:
+		return day.AddDate(0, 0, -1)
+	case time.Sunday:
+		return day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+// -----------------------------------------------------------------------------
+func nextWeekday(day time.Time) time.Time {
+	for weekend(day) {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+// This function returns Easter Sunday of the year in the Gregorian calendar.
+func easter(year int) time.Time {
+	a, b, c := year%19, year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	return calendarDay(year, time.Month(month), day)
+}
@@ -0,0 +1,89 @@
+package mop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionAt(t *testing.T) {
+	tests := []struct {
+		exchange string
+		at       string // Local time of the exchange.
+		want     Session
+	}{
+		// An ordinary Wednesday and Saturday.
+		{`NYSE`, `2026-10-14 04:00`, SessionPreMarket},
+		{`NYSE`, `2026-10-14 09:29`, SessionPreMarket},
+		{`NYSE`, `2026-10-14 09:30`, SessionRegular},
+		{`NYSE`, `2026-10-14 16:00`, SessionAfterHours},
+		{`NYSE`, `2026-10-14 20:00`, SessionClosed},
+		{`NYSE`, `2026-10-17 12:00`, SessionClosed},
+
+		// Good Friday, Martin Luther King Jr. Day, Juneteenth, Memorial Day and Labor Day.
+		{`NYSE`, `2024-03-29 10:00`, SessionClosed},
+		{`NYSE`, `2025-01-20 10:00`, SessionClosed},
+		{`NYSE`, `2025-04-18 10:00`, SessionClosed},
+		{`NYSE`, `2025-06-19 10:00`, SessionClosed},
+		{`NYSE`, `2026-01-19 10:00`, SessionClosed},
+		{`NYSE`, `2026-04-03 10:00`, SessionClosed},
+		{`NYSE`, `2026-05-25 10:00`, SessionClosed},
+		{`NYSE`, `2026-09-07 10:00`, SessionClosed},
+		// Independence Day falls on a Saturday in 2026 and is observed on Friday the 3rd, which leaves no early close.
+		{`NYSE`, `2026-07-02 10:00`, SessionRegular},
+		{`NYSE`, `2026-07-03 10:00`, SessionClosed},
+		// Early closes at 13:00, followed by the after hours session until 17:00.
+		{`NYSE`, `2024-07-03 12:00`, SessionRegular},
+		{`NYSE`, `2024-07-03 13:30`, SessionAfterHours},
+		{`NYSE`, `2024-07-03 17:30`, SessionClosed},
+		{`NYSE`, `2024-11-29 13:00`, SessionAfterHours},
+		{`NYSE`, `2024-12-24 12:59`, SessionRegular},
+		{`NYSE`, `2025-11-28 14:00`, SessionAfterHours},
+		{`NYSE`, `2026-11-27 12:00`, SessionRegular},
+		{`NYSE`, `2026-11-27 13:00`, SessionAfterHours},
+		{`NYSE`, `2026-12-24 13:00`, SessionAfterHours},
+
+		// Summer bank holiday, Easter Monday, Boxing Day moved off the weekend, and the 12:30 early closes.
+		{`LSE`, `2025-08-25 10:00`, SessionClosed},
+		{`LSE`, `2026-04-06 10:00`, SessionClosed},
+		{`LSE`, `2026-12-28 10:00`, SessionClosed},
+		{`LSE`, `2024-12-24 12:29`, SessionRegular},
+		{`LSE`, `2024-12-24 12:30`, SessionClosed},
+		{`LSE`, `2026-12-31 12:00`, SessionRegular},
+		{`LSE`, `2026-12-31 13:00`, SessionClosed},
+
+		{`XETRA`, `2025-04-21 10:00`, SessionClosed},
+		{`XETRA`, `2025-04-22 10:00`, SessionRegular},
+		{`XETRA`, `2025-05-01 10:00`, SessionClosed},
+		{`XETRA`, `2025-12-24 10:00`, SessionClosed},
+
+		// Holidays falling on a Sunday move to Monday, or past the holidays that follow; a day between two holidays is one too.
+		{`TSE`, `2024-02-12 10:00`, SessionClosed},
+		{`TSE`, `2024-05-06 10:00`, SessionClosed},
+		{`TSE`, `2024-09-23 10:00`, SessionClosed},
+		{`TSE`, `2025-01-03 10:00`, SessionClosed},
+		{`TSE`, `2026-05-06 10:00`, SessionClosed},
+		{`TSE`, `2026-05-07 10:00`, SessionRegular},
+		{`TSE`, `2026-05-07 12:00`, SessionClosed},
+		{`TSE`, `2026-09-22 10:00`, SessionClosed},
+
+		// Lunar new year and its eve, which closes at noon like Christmas Eve.
+		{`HKEX`, `2024-12-24 11:59`, SessionRegular},
+		{`HKEX`, `2024-12-24 13:30`, SessionClosed},
+		{`HKEX`, `2025-01-28 11:00`, SessionRegular},
+		{`HKEX`, `2025-01-28 12:30`, SessionClosed},
+		{`HKEX`, `2025-01-29 10:00`, SessionClosed},
+		{`HKEX`, `2026-02-17 10:00`, SessionClosed},
+		{`HKEX`, `2026-04-07 10:00`, SessionClosed},
+		{`HKEX`, `2026-10-19 10:00`, SessionClosed},
+	}
+	for _, test := range tests {
+		exchange := Exchanges[test.exchange]
+		at, err := time.ParseInLocation(`2006-01-02 15:04`, test.at, exchange.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exchange.SessionAt(at.UTC()); got != test.want {
+			t.Errorf("%s at %s: got %s, want %s", test.exchange, test.at, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package mop
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+`Quotes` and `Market` are owned by one goroutine, the main loop of the screen or the refresh loop of the
+server: only that goroutine reads their data or changes the profile. What is slow, the requests to the
+provider, runs in a `fetchWorker` of their own, one fetch at a time:
+
+- The owner submits a job holding a copy of everything the fetch needs, e.g. the tickers of the profile.
+- A job submitted while another one still waits replaces it, so refresh ticks piling up during a slow fetch
+  are coalesced into a single fetch.
+- The job returns a function applying its result, which the worker hands back on the `Fetched` channel. The
+  owner calls `Apply` on it when it is ready to, which publishes the new stocks all at once, so the screen
+  never draws a half updated table.
+- Changing the tickers cancels the context of the running job, and its result is dropped.
+
+The chart view and the detail pane fetch through a worker of their own in the same way, which they stop when
+they close, so that a slow provider never holds up the keys, Esc included.
+*/
+
+// FetchResult is the outcome of a fetch, published by Apply on the goroutine owning the data.
+type FetchResult struct {
+	apply func()
+}
+
+// fetchJob runs a fetch and returns the function applying its result, nil when there is nothing to apply.
+type fetchJob func(ctx context.Context) func()
+
+// fetchWorker runs the fetch jobs of one owner in a goroutine of its own.
+type fetchWorker struct {
+	jobs       chan queuedJob     // The job waiting for the worker, if any.
+	results    chan FetchResult   // Results waiting to be applied by the owner.
+	cancel     context.CancelFunc // Cancels the running job, nil while idle.
+	generation int                // Incremented by cancelAll; results of jobs submitted before are dropped.
+	mutex      sync.Mutex         // Guards cancel, the generation and the replacement of a waiting job.
+	done       chan struct{}      // Closed by stop, which ends the worker.
+	stopping   sync.Once
+}
+
+// queuedJob is a job with the generation it was submitted in.
+type queuedJob struct {
+	job        fetchJob
+	generation int
+}
+
+// This function applies the result of a fetch. It must be called by the goroutine owning the data.
+func (result FetchResult) Apply() {
+	if result.apply != nil {
+		result.apply()
+	}
+}
+
+// -----------------------------------------------------------------------------
+func newFetchWorker() *fetchWorker {
+	worker := &fetchWorker{
+		jobs:    make(chan queuedJob, 1),
+		results: make(chan FetchResult),
+		done:    make(chan struct{}),
+	}
+	go worker.run()
+
+	return worker
+}
+
+// This function queues the job, replacing the job still waiting if there is one.
+func (worker *fetchWorker) submit(job fetchJob) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	select {
+	case <-worker.jobs:
+	default:
+	}
+	worker.jobs <- queuedJob{job, worker.generation}
+}
+
+// This function cancels the running job and drops the waiting one, as well as the results not applied yet: they would be out of date.
+func (worker *fetchWorker) cancelAll() {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	select {
+	case <-worker.jobs:
+	default:
+	}
+	worker.generation++
+	if worker.cancel != nil {
+		worker.cancel()
+	}
+}
+
+// This function cancels the jobs and ends the worker once its owner no longer reads the results, e.g. when a view closes.
+func (worker *fetchWorker) stop() {
+	worker.cancelAll()
+	worker.stopping.Do(func() { close(worker.done) })
+}
+
+// -----------------------------------------------------------------------------
+func (worker *fetchWorker) current(generation int) bool {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	return generation == worker.generation
+}
+
+// -----------------------------------------------------------------------------
+func (worker *fetchWorker) run() {
+	for {
+		var queued queuedJob
+		select {
+		case queued = <-worker.jobs:
+		case <-worker.done:
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		worker.mutex.Lock()
+		worker.cancel = cancel
+		if queued.generation != worker.generation {
+			cancel()
+		}
+		worker.mutex.Unlock()
+
+		var apply func()
+		if ctx.Err() == nil {
+			apply = queued.job(ctx)
+		}
+
+		worker.mutex.Lock()
+		worker.cancel = nil
+		worker.mutex.Unlock()
+		cancel()
+
+		// Cancelled jobs still deliver an empty result, so that a caller waiting for it is released.
+		generation := queued.generation
+		select {
+		case worker.results <- FetchResult{apply: func() {
+			if apply != nil && worker.current(generation) {
+				apply()
+			}
+		}}:
+		case <-worker.done:
+			return
+		}
+	}
+}
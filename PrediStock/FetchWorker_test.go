@@ -0,0 +1,113 @@
+package mop
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// This function returns the next result of the worker, failing the test when none comes.
+func nextResult(t *testing.T, worker *fetchWorker) FetchResult {
+	t.Helper()
+	select {
+	case result := <-worker.results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+	}
+	return FetchResult{}
+}
+
+func TestFetchWorkerCoalesces(t *testing.T) {
+	worker := newFetchWorker()
+	defer worker.stop()
+
+	started, release := make(chan bool), make(chan bool)
+	var applied []string
+	job := func(name string, block bool) fetchJob {
+		return func(ctx context.Context) func() {
+			if block {
+				started <- true
+				<-release
+			}
+			return func() { applied = append(applied, name) }
+		}
+	}
+
+	// The jobs submitted while the first one runs replace each other: only the last one runs after it.
+	worker.submit(job(`first`, true))
+	<-started
+	worker.submit(job(`second`, false))
+	worker.submit(job(`third`, false))
+	close(release)
+	nextResult(t, worker).Apply()
+	nextResult(t, worker).Apply()
+
+	select {
+	case result := <-worker.results:
+		result.Apply()
+		t.Errorf("got a third result, applied %v", applied)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(applied) != 2 || applied[0] != `first` || applied[1] != `third` {
+		t.Errorf("applied %v, want first and third", applied)
+	}
+}
+
+func TestFetchWorkerCancelAll(t *testing.T) {
+	worker := newFetchWorker()
+	defer worker.stop()
+
+	// A running fetch sees its context cancelled, and its result is not applied.
+	started, stopped := make(chan bool), make(chan error, 1)
+	applied := map[string]bool{}
+	worker.submit(func(ctx context.Context) func() {
+		started <- true
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return func() { applied[`cancelled`] = true }
+	})
+	<-started
+	worker.cancelAll()
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("got %v, want the context cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the running fetch was not cancelled")
+	}
+	nextResult(t, worker).Apply()
+
+	// A result fetched before cancelAll but applied after it is out of date too; the jobs submitted after are applied.
+	worker.submit(func(ctx context.Context) func() { return func() { applied[`stale`] = true } })
+	stale := nextResult(t, worker)
+	worker.cancelAll()
+	worker.submit(func(ctx context.Context) func() { return func() { applied[`fresh`] = true } })
+	stale.Apply()
+	nextResult(t, worker).Apply()
+
+	if applied[`cancelled`] || applied[`stale`] || !applied[`fresh`] {
+		t.Errorf("applied %v, want fresh only", applied)
+	}
+}
+
+func TestQuotesDropOutdatedFetch(t *testing.T) {
+	server := newFakeServer(t, &fakeProvider{prices: map[string]float64{`AAPL`: 190, `MSFT`: 420}})
+	quotes := server.quotes
+
+	// The tickers change between the fetch and its application: the quotes of the old list are dropped.
+	quotes.Refresh()
+	outdated := nextResult(t, quotes.worker)
+	if _, err := quotes.RemoveTickers([]string{`MSFT`}); err != nil {
+		t.Fatal(err)
+	}
+	outdated.Apply()
+	if stocks := quotes.Stocks(); len(stocks) != 0 {
+		t.Errorf("got %d stocks from the outdated fetch", len(stocks))
+	}
+
+	if stocks := quotes.Fetch().Stocks(); len(stocks) != 1 || stocks[0].Ticker != `AAPL` {
+		t.Errorf("got %+v, want AAPL only", stocks)
+	}
+}
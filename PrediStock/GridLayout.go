@@ -4,12 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
-	"regexp"
 	"strconv"
-	"strings"
 	"text/template"
 	"time"
-	"unicode"
 )
 
 var currencies = map[string]string{
@@ -20,19 +17,42 @@ var currencies = map[string]string{
 	"EUR": "€",
 	"JPY": "¥",
 }
+
+// Width of the intraday sparkline in characters; longer series are downsampled to fit.
+const sparklineWidth = 20
+
+var sparklineBlocks = []rune(`▁▂▃▄▅▆▇█`)
+
+// Position columns are only shown with the holdings and are formatted in the base currency.
+var positionColumns = map[string]bool{`Value`: true, `DayPnL`: true, `UnrealizedPnL`: true, `Weight`: true}
 type Column struct {
 	width     int                   
 	name      string                 
 	title     string              
-	formatter func(...string) string
+	formatter func(value interface{}, currency string) string
 }
 type Layout struct {
 	columns        []Column         
 	sorter         *Sorter           
 	filter         *Filter          
-	regex          *regexp.Regexp   
 	marketTemplate *template.Template 
 	quotesTemplate *template.Template
+	tickers        []string // Tickers in the order they were last displayed, used to move the row cursor.
+}
+
+// marketCell is an item of the market header formatted for display.
+type marketCell struct {
+	Label string
+	Value string
+}
+
+// quoteRow is a stock formatted for display: one padded cell per column.
+type quoteRow struct {
+	Direction int
+	Selected  bool
+	Stale     bool   // True when the quote is out of date or the ticker unknown, see Quotes.Stale.
+	Note      string // Shown after the cells of a stale row: the age of its quote, or why it has none.
+	Cells     []string
 }
 func NewLayout() *Layout {
 	layout := &Layout{}
@@ -40,7 +60,7 @@ func NewLayout() *Layout {
 		{-10, `Ticker`, `Ticker`, nil},
 		{10, `LastTrade`, `Last`, currency},
 		{10, `Change`, `Change`, currency},
-		{10, `ChangePct`, `Change%`, last},
+		{10, `ChangePct`, `Change%`, percent},
 		{10, `Open`, `Open`, currency},
 		{10, `Low`, `Low`, currency},
 		{10, `High`, `High`, currency},
@@ -50,27 +70,36 @@ func NewLayout() *Layout {
 		{11, `AvgVolume`, `AvgVolume`, integer},
 		{9, `PeRatio`, `P/E`, blank},
 		{9, `Dividend`, `Dividend`, zero},
-		{9, `Yield`, `Yield`, percent},
+		{9, `Yield`, `Yield`, fraction},
 		{11, `MarketCap`, `MktCap`, currency},
 		{13, `PreOpen`, `PreMktChg%`, percent},
 		{13, `AfterHours`, `AfterMktChg%`, percent},
+		{sparklineWidth + 2, `Intraday`, `Intraday`, sparkline},
+		{12, `Value`, `Value`, currency},
+		{11, `DayPnL`, `Day P&L`, currency},
+		{12, `UnrealizedPnL`, `Unrlzd P&L`, currency},
+		{9, `Weight`, `Weight`, percent},
 	}
-	layout.regex = regexp.MustCompile(`(\.\d+)[TBMK]?$`)
 	layout.marketTemplate = buildMarketTemplate()
 	layout.quotesTemplate = buildQuotesTemplate()
 
 	return layout
 }
 func (layout *Layout) Market(market *Market) string {
-	if ok, err := market.Ok(); !ok { 
+	if ok, err := market.Ok(); !ok && len(market.Quotes) == 0 { 
 		return err 
 	}
 
-	highlight(market.Dow, market.Sp500, market.Nasdaq,
-		market.Tokyo, market.HongKong, market.London, market.Frankfurt,
-		market.Yield, market.Oil, market.Euro, market.Yen, market.Gold)
+	vars := struct {
+		Rows     [marketRows][]marketCell
+		IsClosed bool
+		Session  Session
+	}{IsClosed: market.IsClosed, Session: market.Session}
+	for _, quote := range market.Quotes {
+		vars.Rows[quote.Row-1] = append(vars.Rows[quote.Row-1], marketCell{quote.Label, marketValue(quote)})
+	}
 	buffer := new(bytes.Buffer)
-	layout.marketTemplate.Execute(buffer, market)
+	layout.marketTemplate.Execute(buffer, vars)
 
 	return buffer.String()
 }
@@ -78,14 +107,11 @@ func (layout *Layout) Market(market *Market) string {
 
 func (layout *Layout) Quotes(quotes *Quotes) string {
 	zonename, _ := time.Now().In(time.Local).Zone()
-	if ok, err := quotes.Ok(); !ok { 
-		return err 
-	}
 
 	vars := struct {
 		Now    string  
 		Header string 
-		Stocks []Stock
+		Stocks []quoteRow
 	}{
 		time.Now().Format(`3:04:05pm ` + zonename),
 		layout.Header(quotes.profile),
@@ -97,10 +123,53 @@ func (layout *Layout) Quotes(quotes *Quotes) string {
 
 	return buffer.String()
 }
+// This function returns the header and rows of the quotes table without the clock and the blank lines the screen keeps above them, for printing outside the terminal UI.
+func (layout *Layout) Table(quotes *Quotes) string {
+	vars := struct {
+		Header string
+		Stocks []quoteRow
+	}{
+		layout.Header(quotes.profile),
+		layout.prettify(quotes),
+	}
+
+	buffer := new(bytes.Buffer)
+	layout.quotesTemplate.ExecuteTemplate(buffer, `table`, vars)
+
+	return buffer.String()
+}
+
+// This function returns the stocks as the quotes table shows them: filtered, sorted and grouped with the settings of the profile.
+func (layout *Layout) Stocks(quotes *Quotes) []Stock {
+	stocks := quotes.Stocks()
+	profile := quotes.profile
+
+	if profile.Filter != "" { 
+		if profile.filterExpression != nil {
+			if layout.filter == nil { 
+				layout.filter = NewFilter(profile)
+			}
+			stocks = layout.filter.Apply(stocks)
+		}
+	}
+
+	if layout.sorter == nil { 
+		layout.sorter = NewSorter(profile)
+	}
+	layout.sorter.SortByCurrentColumn(stocks)
+	if profile.Grouped && (profile.SortColumn < 2 || profile.SortColumn > 3) {
+		stocks = group(stocks)
+	}
+
+	return stocks
+}
 func (layout *Layout) Header(profile *Profile) string {
 	str, selectedColumn := ``, profile.selectedColumn
 
 	for i, col := range layout.columns {
+		if !layout.visible(col, profile) {
+			continue
+		}
 		arrow := arrowFor(i, profile)
 		if i != selectedColumn {
 			str += fmt.Sprintf(`%*s`, col.width, arrow+col.title)
@@ -115,78 +184,148 @@ func (layout *Layout) TotalColumns() int {
 	return len(layout.columns)
 }
 
+// This function reports whether the column with the given number is shown with the settings of the profile.
+func (layout *Layout) ColumnVisible(column int, profile *Profile) bool {
+	return column >= 0 && column < len(layout.columns) && layout.visible(layout.columns[column], profile)
+}
+
 // -----------------------------------------------------------------------------
-func (layout *Layout) prettify(quotes *Quotes) []Stock {
-	pretty := make([]Stock, len(quotes.stocks))
+func (layout *Layout) prettify(quotes *Quotes) []quoteRow {
+	stocks := layout.Stocks(quotes)
+	profile := quotes.profile
+	missing := layout.missing(quotes, stocks)
+
 	tickerWidth := 0
-	for _, stock := range quotes.stocks {
-		value := reflect.ValueOf(&stock).Elem().FieldByName(`Ticker`).String()
-		currentLength := len(value)
-		if currentLength > tickerWidth {
-			tickerWidth = currentLength
+	for _, ticker := range append(tickersOf(stocks), missing...) {
+		if len(ticker) > tickerWidth {
+			tickerWidth = len(ticker)
 		}
 	}
-	for i, stock := range quotes.stocks {
+	pretty := make([]quoteRow, len(stocks))
+	layout.tickers = layout.tickers[:0]
+	for i, stock := range stocks {
+		layout.tickers = append(layout.tickers, stock.Ticker)
 		pretty[i].Direction = stock.Direction
+		pretty[i].Selected = stock.Ticker == profile.selectedTicker
+		if at, stale := quotes.Stale(stock.Ticker); stale {
+			pretty[i].Stale, pretty[i].Note = true, shortAge(quotes.market.Now().Sub(at))+` old`
+		}
 		for _, column := range layout.columns {
-			value := reflect.ValueOf(&stock).Elem().FieldByName(column.name).String()
+			if !layout.visible(column, profile) {
+				continue
+			}
+			value := reflect.ValueOf(stock).FieldByName(column.name).Interface()
+			code := stock.Currency
+			if positionColumns[column.name] {
+				code = profile.BaseCurrency
+			}
+			str := fmt.Sprint(value)
 			if column.formatter != nil {
-				value = column.formatter(value, stock.Currency)
+				str = column.formatter(value, code)
 			}
 			if column.name == `Ticker` && (0-tickerWidth) < column.width {
 				column.width = (0 - tickerWidth)
 			}
-			reflect.ValueOf(&pretty[i]).Elem().FieldByName(column.name).SetString(layout.pad(value, column.width))
+			pretty[i].Cells = append(pretty[i].Cells, layout.pad(str, column.width))
+		}
+	}
+	for _, ticker := range missing {
+		layout.tickers = append(layout.tickers, ticker)
+		row := quoteRow{Selected: ticker == profile.selectedTicker, Stale: true, Note: `no quote yet`}
+		if quotes.Unknown(ticker) {
+			row.Note = `unknown symbol`
 		}
+		row.Cells = []string{layout.pad(ticker, -tickerWidth)}
+		pretty = append(pretty, row)
+	}
+	// A row hidden by the filter cannot stay selected, or the commands acting on the selection would change a stock out of sight.
+	shown := false
+	for _, row := range pretty {
+		shown = shown || row.Selected
+	}
+	if !shown {
+		profile.selectedTicker = ``
+	}
+	if profile.ShowHoldings && holdsAny(stocks) {
+		pretty = append(pretty, layout.totals(stocks, profile, tickerWidth))
 	}
 
-	profile := quotes.profile
+	return pretty
+}
 
-	if profile.Filter != "" { 
-		if profile.filterExpression != nil {
-			if layout.filter == nil { 
-				layout.filter = NewFilter(profile)
-			}
-			pretty = layout.filter.Apply(pretty)
+// This function returns the tickers of the profile that have no quote to show, because the provider does not know them or fetching them failed. They are left out while a filter is set.
+func (layout *Layout) missing(quotes *Quotes, stocks []Stock) []string {
+	if quotes.profile.Filter != `` {
+		return nil
+	}
+	shown := make(map[string]bool, len(stocks))
+	for _, ticker := range tickersOf(stocks) {
+		shown[ticker] = true
+	}
+
+	var missing []string
+	for _, ticker := range quotes.profile.Tickers {
+		if _, stale := quotes.Stale(ticker); !shown[ticker] && (stale || quotes.Unknown(ticker)) {
+			missing = append(missing, ticker)
 		}
 	}
+	return missing
+}
 
-	if layout.sorter == nil { 
-		layout.sorter = NewSorter(profile)
+// This function sums the position columns of the stocks into the row shown below the table.
+func (layout *Layout) totals(stocks []Stock, profile *Profile, tickerWidth int) quoteRow {
+	sums := make(map[string]Number)
+	for _, stock := range stocks {
+		fields := reflect.ValueOf(stock)
+		for name := range positionColumns {
+			if value := fields.FieldByName(name).Interface().(Number); value.Valid {
+				sums[name] = Number{Value: sums[name].Value + value.Value, Valid: true}
+			}
+		}
 	}
-	layout.sorter.SortByCurrentColumn(pretty)
-	if profile.Grouped && (profile.SortColumn < 2 || profile.SortColumn > 3) {
-		pretty = group(pretty)
+
+	row := quoteRow{Direction: sign(sums[`DayPnL`].Value)}
+	for _, column := range layout.columns {
+		if !layout.visible(column, profile) {
+			continue
+		}
+		str := ``
+		if column.name == `Ticker` {
+			str = `Total`
+			if (0 - tickerWidth) < column.width {
+				column.width = (0 - tickerWidth)
+			}
+		} else if positionColumns[column.name] {
+			str = column.formatter(sums[column.name], profile.BaseCurrency)
+		}
+		row.Cells = append(row.Cells, layout.pad(str, column.width))
 	}
 
-	return pretty
+	return row
 }
 
 // -----------------------------------------------------------------------------
-func (layout *Layout) pad(str string, width int) string {
-	match := layout.regex.FindStringSubmatch(str)
-	if len(match) > 0 {
-		switch len(match[1]) {
-		case 2:
-			str = strings.Replace(str, match[1], match[1]+`0`, 1)
-		case 4, 5:
-			str = strings.Replace(str, match[1], match[1][0:3], 1)
-		}
+func (layout *Layout) visible(column Column, profile *Profile) bool {
+	if column.name == `Intraday` {
+		return profile.Sparkline
 	}
+	return !positionColumns[column.name] || profile.ShowHoldings
+}
 
+// -----------------------------------------------------------------------------
+func (layout *Layout) pad(str string, width int) string {
 	return fmt.Sprintf(`%*s`, width, str)
 }
 
 /*
-This function creates and returns a `*template.Template` for rendering a market report. 
-The template displays stock market data (e.g., Dow, S&P 500, NASDAQ), financial indicators (e.g., Yield, Euro, Yen), and commodities (e.g., Oil, Gold). 
-It optionally shows "U.S. markets closed" if the `IsClosed` flag is true.
+This function creates and returns a `*template.Template` for rendering the market header: the items of each of
+its rows, as labels followed by their values. The second row ends with "U.S. markets closed" when the `IsClosed`
+flag is true, and with the pre-market or after hours session otherwise.
 */
 
 func buildMarketTemplate() *template.Template {
-	markup := `<tag>Dow</> {{.Dow.change}} ({{.Dow.percent}}) at {{.Dow.latest}} <tag>S&P 500</> {{.Sp500.change}} ({{.Sp500.percent}}) at {{.Sp500.latest}} <tag>NASDAQ</> {{.Nasdaq.change}} ({{.Nasdaq.percent}}) at {{.Nasdaq.latest}}
-<tag>Tokyo</> {{.Tokyo.change}} ({{.Tokyo.percent}}) at {{.Tokyo.latest}} <tag>HK</> {{.HongKong.change}} ({{.HongKong.percent}}) at {{.HongKong.latest}} <tag>London</> {{.London.change}} ({{.London.percent}}) at {{.London.latest}} <tag>Frankfurt</> {{.Frankfurt.change}} ({{.Frankfurt.percent}}) at {{.Frankfurt.latest}} {{if .IsClosed}}<right>U.S. markets closed</right>{{end}}
-<tag>10-Year Yield</> {{.Yield.latest}} ({{.Yield.change}}) <tag>Euro</> ${{.Euro.latest}} ({{.Euro.change}}) <tag>Yen</> ¥{{.Yen.latest}} ({{.Yen.change}}) <tag>Oil</> ${{.Oil.latest}} ({{.Oil.change}}) <tag>Gold</> ${{.Gold.latest}} ({{.Gold.change}})`
+	markup := `{{range $i, $row := .Rows}}{{if $i}}
+{{end}}{{range $j, $cell := $row}}{{if $j}} {{end}}<tag>{{$cell.Label}}</> {{$cell.Value}}{{end}}{{if eq $i 1}}{{if $.IsClosed}} <right>U.S. markets closed</right>{{else if $.Session.Extended}} <right>U.S. {{$.Session}}</right>{{end}}{{end}}{{end}}`
 
 	return template.Must(template.New(`market`).Parse(markup))
 }
@@ -197,9 +336,9 @@ func buildQuotesTemplate() *template.Template {
 
 
 
-<header>{{.Header}}</>
-{{range.Stocks}}{{if eq .Direction 1}}<gain>{{else if eq .Direction -1}}<loss>{{end}}{{.Ticker}}{{.LastTrade}}{{.Change}}{{.ChangePct}}{{.Open}}{{.Low}}{{.High}}{{.Low52}}{{.High52}}{{.Volume}}{{.AvgVolume}}{{.PeRatio}}{{.Dividend}}{{.Yield}}{{.MarketCap}}{{.PreOpen}}{{.AfterHours}}</>
-{{end}}`
+{{template "table" .}}{{define "table"}}<header>{{.Header}}</>
+{{range.Stocks}}{{if .Stale}}<stale>{{else if eq .Direction 1}}<gain>{{else if eq .Direction -1}}<loss>{{end}}{{if .Selected}}<r>{{end}}{{range .Cells}}{{.}}{{end}}{{if .Selected}}</r>{{end}}{{with .Note}}  {{.}}{{end}}</>
+{{end}}{{end}}`
 
 	return template.Must(template.New(`quotes`).Parse(markup))
 }
@@ -222,6 +361,49 @@ func highlight(collections ...map[string]string) {
 	}
 }
 
+// This function formats the value of an item of the market header in the style configured for it: `+1.2 (0.5) at 123` for both changes, `$123 (+0.5%)` for the percent change, `123 (+1.2)` for the absolute one. A missing symbol is shown as `-`.
+func marketValue(quote MarketQuote) string {
+	if !quote.Latest.Valid {
+		return `-`
+	}
+	values := map[string]string{
+		`change`:  humanize(quote.Change.Float(), 3),
+		`latest`:  quote.item.Prefix + humanize(quote.Latest.Float(), 3),
+		`percent`: humanize(quote.ChangePct.Float(), 3),
+	}
+	if quote.item.Change == `percent` {
+		values[`change`] = values[`percent`] + `%`
+	}
+	highlight(values)
+
+	if quote.item.Change == `both` {
+		return values[`change`] + ` (` + values[`percent`] + `) at ` + values[`latest`]
+	}
+	return values[`latest`] + ` (` + values[`change`] + `)`
+}
+
+// This function formats how old a quote is in its largest unit, e.g. `45s`, `12m`, `5h` or `3d`.
+func shortAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf(`%ds`, int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf(`%dm`, int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf(`%dh`, int(age.Hours()))
+	}
+	return fmt.Sprintf(`%dd`, int(age.Hours()/24))
+}
+
+// -----------------------------------------------------------------------------
+func tickersOf(stocks []Stock) []string {
+	tickers := make([]string, len(stocks))
+	for i, stock := range stocks {
+		tickers[i] = stock.Ticker
+	}
+	return tickers
+}
+
 // -----------------------------------------------------------------------------
 func group(stocks []Stock) []Stock {
 	grouped := make([]Stock, len(stocks))
@@ -243,6 +425,16 @@ func group(stocks []Stock) []Stock {
 	return grouped
 }
 
+// -----------------------------------------------------------------------------
+func holdsAny(stocks []Stock) bool {
+	for _, stock := range stocks {
+		if stock.Quantity.Valid {
+			return true
+		}
+	}
+	return false
+}
+
 // -----------------------------------------------------------------------------
 func arrowFor(column int, profile *Profile) string {
 	if column == profile.SortColumn {
@@ -255,96 +447,138 @@ func arrowFor(column int, profile *Profile) string {
 }
 
 // -----------------------------------------------------------------------------
-func blank(str ...string) string {
-	if len(str) < 1 {
-		return "ERR"
-	}
-	if (len(str[0]) == 3 && str[0][0:3] == `N/A`) || len(str[0]) == 0 {
-		return `-`
-	}
-
-	return str[0]
+func numeric(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case Number:
+		return number.Value, number.Valid
+	case Integer:
+		return float64(number.Value), number.Valid
+	}
+	return 0, false
 }
 
 // -----------------------------------------------------------------------------
-func zero(str ...string) string {
-	if len(str) < 2 {
-		return "ERR"
-	}
-	if str[0] == `0.00` {
+func blank(value interface{}, _ string) string {
+	number, ok := numeric(value)
+	if !ok {
 		return `-`
 	}
 
-	return currency(str[0], str[1])
+	return fmt.Sprintf(`%.2f`, number)
 }
 
 // -----------------------------------------------------------------------------
-func last(str ...string) string {
-	if len(str) < 1 {
-		return "ERR"
-	}
-	if len(str[0]) >= 6 && str[0][0:6] == `N/A - ` {
-		return str[0][6:]
+func zero(value interface{}, code string) string {
+	if number, ok := numeric(value); !ok || number == 0 {
+		return `-`
 	}
 
-	return percent(str[0])
+	return currency(value, code)
 }
 
 // -----------------------------------------------------------------------------
-func currency(str ...string) string {
-	if len(str) < 2 {
-		return "ERR"
+func currency(value interface{}, code string) string {
+	number, ok := numeric(value)
+	if !ok {
+		return `-`
 	}
 	symbol := "$"
-	c, ok := currencies[str[1]]
+	c, ok := currencies[code]
 	if ok {
 		symbol = c
 	}
-	if str[0] == `N/A` || len(str[0]) == 0 {
-		return `-`
-	}
-	if sign := str[0][0:1]; sign == `+` || sign == `-` {
-		return sign + symbol + str[0][1:]
+	if number < 0 {
+		return `-` + symbol + humanize(-number, 2)
 	}
 
-	return symbol + str[0]
+	return symbol + humanize(number, 2)
 }
 // -----------------------------------------------------------------------------
-func percent(str ...string) string {
-	if len(str) < 1 {
-		return "ERR"
+func percent(value interface{}, _ string) string {
+	number, ok := numeric(value)
+	if !ok {
+		return `-`
 	}
-	if str[0] == `N/A` || len(str[0]) == 0 {
+
+	return fmt.Sprintf(`%.2f%%`, number)
+}
+
+// This function formats a fraction, such as the dividend yield Yahoo returns, as a percentage.
+func fraction(value interface{}, _ string) string {
+	number, ok := numeric(value)
+	if !ok {
 		return `-`
 	}
 
-	split := strings.Split(str[0], ".")
-	if len(split) == 2 {
-		digits := len(split[1])
-		if digits > 2 {
-			digits = 2
-		}
-		str[0] = split[0] + "." + split[1][0:digits]
+	return fmt.Sprintf(`%.2f%%`, number*100)
+}
+
+// -----------------------------------------------------------------------------
+func integer(value interface{}, _ string) string {
+	number, ok := numeric(value)
+	if !ok {
+		return `-`
 	}
-	if str[0][len(str)-1] != '%' {
-		str[0] += `%`
+	if number <= 1.0e5 {
+		return fmt.Sprintf(`%.0f`, number)
 	}
-	return str[0]
+
+	return humanize(number, 2)
 }
 
 // -----------------------------------------------------------------------------
-func integer(str ...string) string {
-	if len(str) < 1 {
-		return "ERR"
+func sparkline(value interface{}, _ string) string {
+	points, _ := value.([]float64)
+	if len(points) < 2 {
+		return ``
+	}
+	if len(points) > sparklineWidth {
+		sampled := make([]float64, sparklineWidth)
+		for i := range sampled {
+			sampled[i] = points[i*(len(points)-1)/(sparklineWidth-1)]
+		}
+		points = sampled
 	}
-	if str[0] == `N/A` || len(str[0]) == 0 {
-		return `-`
+
+	low, high := points[0], points[0]
+	for _, point := range points {
+		if point < low {
+			low = point
+		}
+		if point > high {
+			high = point
+		}
 	}
-	if unicode.IsDigit(rune(str[0][len(str[0])-1])) {
-		split := strings.Split(str[0], ".")
-		if len(split) == 2 {
-			return split[0]
+	line := make([]rune, len(points))
+	for i, point := range points {
+		level := len(sparklineBlocks) / 2
+		if high > low {
+			level = int((point - low) / (high - low) * float64(len(sparklineBlocks)-1))
 		}
+		line[i] = sparklineBlocks[level]
 	}
-	return str[0]
+
+	return string(line)
+}
+
+// -----------------------------------------------------------------------------
+func humanize(v float64, precision int) string {
+	unit := ""
+	switch {
+	case v > 1.0e12:
+		v = v / 1.0e12
+		unit = "T"
+	case v > 1.0e9:
+		v = v / 1.0e9
+		unit = "B"
+	case v > 1.0e6:
+		v = v / 1.0e6
+		unit = "M"
+	case v > 1.0e5:
+		v = v / 1.0e3
+		unit = "K"
+	default:
+		unit = ""
+	}
+	return fmt.Sprintf("%0.*f%s", precision, v, unit)
 }
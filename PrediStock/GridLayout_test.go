@@ -0,0 +1,26 @@
+package mop
+
+import "testing"
+
+func TestFilterClearsHiddenSelection(t *testing.T) {
+	server := newFakeServer(t, &fakeProvider{prices: map[string]float64{`AAPL`: 190, `MSFT`: 420}})
+	server.Refresh()
+	quotes, profile, layout := server.quotes, server.profile, NewLayout()
+
+	profile.selectedTicker = `MSFT`
+	profile.SetFilter(`last > 200`)
+	layout.Quotes(quotes)
+	if stock, ok := quotes.Selected(); !ok || stock.Ticker != `MSFT` {
+		t.Fatalf("got %q selected (%v), want MSFT", stock.Ticker, ok)
+	}
+
+	// With MSFT filtered out, the commands acting on the selection must not reach it.
+	profile.SetFilter(`last < 200`)
+	layout.Quotes(quotes)
+	if stock, ok := quotes.Selected(); ok {
+		t.Errorf("got %s selected while its row is hidden", stock.Ticker)
+	}
+	if len(layout.tickers) != 1 || layout.tickers[0] != `AAPL` {
+		t.Errorf("got rows %v, want AAPL only", layout.tickers)
+	}
+}
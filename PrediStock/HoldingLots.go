@@ -0,0 +1,130 @@
+package mop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Holdings are the positions the user owns, stored in the profile as lots per ticker. Every refresh turns the
+lots of each stock into the position columns of the quotes table, converted to `Profile.BaseCurrency` with the
+exchange rates fetched with the market header for the currencies held:
+
+- `Value`: quantity times the last trade.
+- `DayPnL`: quantity times today's change.
+- `UnrealizedPnL`: value minus what the lots cost, fees included.
+- `Weight`: share of the value of all positions, in percent.
+
+Positions in a currency without a known rate are left missing rather than mixed with the base currency.
+*/
+
+// Lot is one purchase of a stock. Price and Fees are in the currency the stock trades in.
+type Lot struct {
+	ID       string  `json:",omitempty"` // Name of the lot in the ledger.
+	Quantity float64
+	Price    float64
+	Date     string  `json:",omitempty"` // Purchase date, 2006-01-02.
+	Fees     float64 `json:",omitempty"`
+}
+
+// Holdings maps each ticker to the lots held.
+type Holdings map[string][]Lot
+
+// This function parses a lot written as `quantity@price [date] [fees]`, e.g. `10@185.50 2024-01-02 1.5`.
+func ParseLot(str string) (Lot, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 || len(fields) > 3 {
+		return Lot{}, fmt.Errorf("expected quantity@price [date] [fees], got %q", str)
+	}
+	parts := strings.SplitN(fields[0], `@`, 2)
+	if len(parts) != 2 {
+		return Lot{}, fmt.Errorf("expected quantity@price, got %q", fields[0])
+	}
+
+	var lot Lot
+	var err error
+	if lot.Quantity, err = strconv.ParseFloat(parts[0], 64); err != nil || lot.Quantity == 0 {
+		return Lot{}, fmt.Errorf("invalid quantity %q", parts[0])
+	}
+	if lot.Price, err = strconv.ParseFloat(parts[1], 64); err != nil || lot.Price < 0 {
+		return Lot{}, fmt.Errorf("invalid price %q", parts[1])
+	}
+	for _, field := range fields[1:] {
+		if _, err := time.Parse(storeDayLayout, field); err == nil {
+			lot.Date = field
+		} else if lot.Fees, err = strconv.ParseFloat(field, 64); err != nil {
+			return Lot{}, fmt.Errorf("invalid date or fees %q", field)
+		}
+	}
+
+	return lot, nil
+}
+
+// This function writes the lot the way ParseLot reads it.
+func (lot Lot) String() string {
+	str := strconv.FormatFloat(lot.Quantity, 'f', -1, 64) + `@` + strconv.FormatFloat(lot.Price, 'f', -1, 64)
+	if lot.Date != `` {
+		str += ` ` + lot.Date
+	}
+	if lot.Fees != 0 {
+		str += ` ` + strconv.FormatFloat(lot.Fees, 'f', -1, 64)
+	}
+	return str
+}
+
+// This function returns the lots of both holdings, those of `extra` after those of `holdings`.
+func mergeHoldings(holdings, extra Holdings) Holdings {
+	if len(extra) == 0 {
+		return holdings
+	}
+	merged := make(Holdings, len(holdings)+len(extra))
+	for ticker, lots := range holdings {
+		merged[ticker] = append(merged[ticker], lots...)
+	}
+	for ticker, lots := range extra {
+		merged[ticker] = append(merged[ticker], lots...)
+	}
+	return merged
+}
+
+// This function fills the position fields of the stocks that have lots in the profile, and clears them on the others, e.g. after their lots were removed. The weights are relative to the value of all positions.
+func attachPositions(stocks []Stock, holdings Holdings, base string, market *Market) {
+	total := 0.0
+	for i := range stocks {
+		stocks[i].Quantity, stocks[i].Value, stocks[i].Weight = Number{}, Number{}, Number{}
+		stocks[i].DayPnL, stocks[i].UnrealizedPnL = Number{}, Number{}
+		lots := holdings[stocks[i].Ticker]
+		if len(lots) == 0 || !stocks[i].LastTrade.Valid {
+			continue
+		}
+
+		quantity, cost := 0.0, 0.0
+		for _, lot := range lots {
+			quantity += lot.Quantity
+			cost += lot.Quantity*lot.Price + lot.Fees
+		}
+		stocks[i].Quantity = Number{Value: quantity, Valid: true}
+
+		convert := func(amount float64) Number {
+			value, ok := market.Convert(amount, stocks[i].Currency, base)
+			return Number{Value: value, Valid: ok}
+		}
+		value := quantity * stocks[i].LastTrade.Value
+		stocks[i].Value = convert(value)
+		stocks[i].UnrealizedPnL = convert(value - cost)
+		if stocks[i].Change.Valid {
+			stocks[i].DayPnL = convert(quantity * stocks[i].Change.Value)
+		}
+		if stocks[i].Value.Valid {
+			total += stocks[i].Value.Value
+		}
+	}
+
+	for i := range stocks {
+		if stocks[i].Value.Valid && total != 0 {
+			stocks[i].Weight = Number{Value: 100 * stocks[i].Value.Value / total, Valid: true}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package mop
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestAttachPositionsRates(t *testing.T) {
+	profile, err := NewProfile(filepath.Join(t.TempDir(), `.moprc`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.8 pounds and 0.9 euros per U.S. dollar; CHF=X has no quote.
+	provider := &fakeProvider{prices: map[string]float64{`GBP=X`: 0.8, `EUR=X`: 0.9}}
+	market := NewMarket(provider, profile)
+	market.fetchRates(context.Background(), []string{`EUR`, `GBp`, `USD`})
+
+	holdings := Holdings{`VOD.L`: {{Quantity: 100, Price: 60}}, `AAPL`: {{Quantity: 1, Price: 170}}, `NESN.SW`: {{Quantity: 1, Price: 90}}}
+	stocks := []Stock{
+		{Ticker: `VOD.L`, LastTrade: Number{80, true}, Change: Number{-2, true}, Currency: `GBp`},
+		{Ticker: `AAPL`, LastTrade: Number{190, true}, Change: Number{1, true}, Currency: `USD`},
+		{Ticker: `NESN.SW`, LastTrade: Number{100, true}, Currency: `CHF`},
+	}
+	attachPositions(stocks, holdings, `EUR`, market)
+
+	// 100 shares at 80 pence are worth 80 pounds, 100 dollars and 90 euros.
+	tests := []struct {
+		name      string
+		got, want Number
+	}{
+		{`VOD.L value`, stocks[0].Value, Number{90, true}},
+		{`VOD.L day P&L`, stocks[0].DayPnL, Number{-2.25, true}},
+		{`VOD.L unrealized P&L`, stocks[0].UnrealizedPnL, Number{22.5, true}},
+		{`VOD.L weight`, stocks[0].Weight, Number{100 * 90 / 261.0, true}},
+		{`AAPL value`, stocks[1].Value, Number{171, true}},
+		{`NESN.SW value`, stocks[2].Value, Number{}},
+		{`NESN.SW quantity`, stocks[2].Quantity, Number{1, true}},
+	}
+	for _, test := range tests {
+		if test.got.Valid != test.want.Valid || !closeTo(test.got.Value, test.want.Value) {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	// The rates of the currencies held are kept up to date by the market fetches, after the symbols of the header.
+	if got := market.symbols(); got[len(got)-1] != `GBP=X` {
+		t.Errorf("got market symbols %v, want GBP=X last", got)
+	}
+}
@@ -1,151 +1,301 @@
 package mop
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-const marketURL = `https://query1.finance.yahoo.com/v7/finance/quote?crumb=%s&symbols=%s`
-const marketURLQueryParts = `&range=1d&interval=5m&indicators=close&includeTimestamps=false&includePrePost=false&corsDomain=finance.yahoo.com&.tsrc=finance`
+// The market header shown when the profile does not configure one.
+var defaultMarketHeader = []MarketItem{
+	{Symbol: `^DJI`, Label: `Dow`, Row: 1},
+	{Symbol: `^GSPC`, Label: `S&P 500`, Row: 1},
+	{Symbol: `^IXIC`, Label: `NASDAQ`, Row: 1},
+	{Symbol: `^N225`, Label: `Tokyo`, Row: 2},
+	{Symbol: `^HSI`, Label: `HK`, Row: 2},
+	{Symbol: `^FTSE`, Label: `London`, Row: 2},
+	{Symbol: `^GDAXI`, Label: `Frankfurt`, Row: 2},
+	{Symbol: `^TNX`, Label: `10-Year Yield`, Row: 3, Change: `absolute`},
+	{Symbol: `EUR=X`, Label: `Euro`, Row: 3, Change: `percent`, Prefix: `$`},
+	{Symbol: `JPY=X`, Label: `Yen`, Row: 3, Change: `percent`, Prefix: `¥`},
+	{Symbol: `CL=F`, Label: `Oil`, Row: 3, Change: `percent`, Prefix: `$`},
+	{Symbol: `GC=F`, Label: `Gold`, Row: 3, Change: `percent`, Prefix: `$`},
+}
 
-type Market struct {
-	IsClosed  bool
-	Dow       map[string]string
-	Nasdaq    map[string]string
-	Sp500     map[string]string
-	Tokyo     map[string]string
-	HongKong  map[string]string
-	London    map[string]string
-	Frankfurt map[string]string
-	Yield     map[string]string
-	Oil       map[string]string
-	Yen       map[string]string
-	Euro      map[string]string
-	Gold      map[string]string
-	errors    string
-	url       string
-	cookies   string
-	crumb     string
-}
-
-func NewMarket() *Market {
-	market := &Market{}
-	market.IsClosed = false
-	market.Dow = make(map[string]string)
-	market.Nasdaq = make(map[string]string)
-	market.Sp500 = make(map[string]string)
+// Number of rows of the market header, above the status line.
+const marketRows = 3
 
-	market.Tokyo = make(map[string]string)
-	market.HongKong = make(map[string]string)
-	market.London = make(map[string]string)
-	market.Frankfurt = make(map[string]string)
+// Currencies some exchanges quote prices in, such as the pence of London, with the currency worth a hundred of them.
+var minorCurrencies = map[string]string{`GBp`: `GBP`, `GBX`: `GBP`, `ZAc`: `ZAR`, `ILA`: `ILS`}
 
-	market.Yield = make(map[string]string)
-	market.Oil = make(map[string]string)
-	market.Yen = make(map[string]string)
-	market.Euro = make(map[string]string)
-	market.Gold = make(map[string]string)
+// MarketItem is one symbol of the market header, as configured in the profile.
+type MarketItem struct {
+	Symbol string // Index, rate, future or crypto currency, e.g. `^VIX`, `DX-Y.NYB` or `BTC-USD`.
+	Label  string `json:",omitempty"` // Name shown in the header, the symbol when empty.
+	Row    int    `json:",omitempty"` // Row of the header, 1 to 3; the row of the previous item when 0.
+	Change string `json:",omitempty"` // How the change is shown: `both` (default) as change, percent and latest value, `percent` or `absolute` after the latest value.
+	Prefix string `json:",omitempty"` // Shown before the latest value, e.g. `$`.
+}
 
-	market.cookies = fetchCookies()
-	market.crumb = fetchCrumb(market.cookies)
-	market.url = fmt.Sprintf(marketURL, market.crumb, `^DJI,^IXIC,^GSPC,^N225,^HSI,^FTSE,^GDAXI,^TNX,CL=F,JPY=X,EUR=X,GC=F`) + marketURLQueryParts
+// MarketQuote is the latest quote of one item of the market header.
+type MarketQuote struct {
+	Symbol    string     `json:"symbol"`
+	Label     string     `json:"label"`
+	Row       int        `json:"row"`
+	Latest    Number     `json:"latest"`
+	Change    Number     `json:"change"`
+	ChangePct Number     `json:"changePercent"`
+	item      MarketItem // Display settings of the item.
+}
+
+type Market struct {
+	IsClosed   bool          // True when the U.S. exchanges are closed, extended hours excluded.
+	Session    Session       // Trading session of the U.S. exchanges.
+	Quotes     []MarketQuote // Quotes of the header items, in the order of the profile.
+	errors     string
+	err        error // Why the last fetch failed, nil when it succeeded.
+	provider   QuoteProvider
+	profile    *Profile           // Profile configuring the header.
+	fetchedAt  time.Time          // Time of the last successful fetch, on the clock of the provider.
+	rates      map[string]float64 // Units of each currency per U.S. dollar, from the market symbols such as `EUR=X`.
+	currencies map[string]bool    // Currencies of the stocks held, whose rate is fetched with the header even when it does not show it.
+	mutex      sync.Mutex         // Guards the rates and currencies, which are used while quotes are fetched.
+	worker     *fetchWorker       // Runs the fetches, see FetchWorker.go.
+}
+
+func NewMarket(provider QuoteProvider, profile *Profile) *Market {
+	market := &Market{}
+	market.IsClosed = false
+	market.provider = provider
+	market.profile = profile
+	market.rates = map[string]float64{`USD`: 1}
+	market.currencies = make(map[string]bool)
+	market.worker = newFetchWorker()
 
 	market.errors = ``
 
 	return market
 }
-func (market *Market) Fetch() (self *Market) {
-	self = market
-	defer func() {
-		if err := recover(); err != nil {
-			market.errors = fmt.Sprintf("Error fetching market data...\n%s", err)
-		} else {
-			market.errors = ""
-		}
-	}()
 
-	client := http.Client{}
-	request, err := http.NewRequest("GET", market.url, nil)
-	if err != nil {
-		panic(err)
+// This function fetches the market data and applies it before returning. It is meant for callers without an event loop, such as the quote command and the server; the screen uses Refresh and Fetched instead.
+func (market *Market) Fetch() (self *Market) {
+	if market.Refresh() {
+		(<-market.Fetched()).Apply()
 	}
+	return market
+}
 
-	request.Header = http.Header{
-		"Accept":          {"*/*"},
-		"Accept-Language": {"en-US,en;q=0.5"},
-		"Connection":      {"keep-alive"},
-		"Content-Type":    {"application/json"},
-		"Cookie":          {market.cookies},
-		"Host":            {"query1.finance.yahoo.com"},
-		"Origin":          {"https://finance.yahoo.com"},
-		"Referer":         {"https://finance.yahoo.com"},
-		"Sec-Fetch-Dest":  {"empty"},
-		"Sec-Fetch-Mode":  {"cors"},
-		"Sec-Fetch-Site":  {"same-site"},
-		"TE":              {"trailers"},
-		"User-Agent":      {userAgent},
+// This function updates the session of the U.S. exchanges and asks the worker for fresh market data, whose result arrives on the Fetched channel. It returns false when nothing was requested because every exchange is closed and the data was fetched recently.
+func (market *Market) Refresh() bool {
+	now := market.Now()
+	market.isMarketOpen(now)
+	if market.fetchedAt.After(now.Add(-closedRefresh)) && market.allClosed(now) {
+		return false
 	}
 
-	response, err := client.Do(request)
-	if err != nil {
-		panic(err)
+	symbols := market.symbols()
+	market.worker.submit(func(ctx context.Context) func() {
+		stocks, err := market.provider.FetchMarket(ctx, symbols)
+		if err != nil {
+			return func() {
+				market.errors = fmt.Sprintf("Error fetching market data...\n%s", err)
+				market.err = err
+			}
+		}
+		return func() {
+			market.fetchedAt, market.errors, market.err = now, ``, nil
+			market.extract(stocks)
+		}
+	})
+
+	return true
+}
+
+// This function returns the channel delivering the result of every fetch requested by Refresh, to be applied with FetchResult.Apply by the goroutine owning the market.
+func (market *Market) Fetched() <-chan FetchResult {
+	return market.worker.results
+}
+
+// This function returns the current time on the clock of the provider, which runs on the session time when a recording is replayed.
+func (market *Market) Now() time.Time {
+	if clock, ok := market.provider.(interface{ Now() time.Time }); ok {
+		return clock.Now()
 	}
+	return time.Now()
+}
+
+// This function converts an amount between two currencies through the U.S. dollar rates of the last market fetch. An empty currency is taken as U.S. dollars, and amounts in pence or cents are converted from their major currency. It returns false when either rate is unknown.
+func (market *Market) Convert(amount float64, from, to string) (float64, bool) {
+	market.mutex.Lock()
+	defer market.mutex.Unlock()
 
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		panic(err)
+	if from == `` {
+		from = `USD`
+	}
+	if major, ok := minorCurrencies[from]; ok {
+		amount, from = amount/100, major
+	}
+	if from == to {
+		return amount, true
+	}
+	fromRate, fromOk := market.rates[from]
+	toRate, toOk := market.rates[to]
+	if !fromOk || !toOk || fromRate == 0 {
+		return 0, false
 	}
 
-	body = market.isMarketOpen(body)
-	return market.extract(body)
+	return amount / fromRate * toRate, true
 }
 func (market *Market) Ok() (bool, string) {
 	return market.errors == ``, market.errors
 }
 
+// This function returns the items of the market header configured in the profile, or the default header. Labels, rows and change styles are filled in: a missing label is the symbol, a missing row that of the previous item, and an unknown change style `both`.
+func (profile *Profile) MarketItems() []MarketItem {
+	items := profile.MarketHeader
+	if len(items) == 0 {
+		items = defaultMarketHeader
+	}
+
+	resolved := make([]MarketItem, 0, len(items))
+	row := 1
+	for _, item := range items {
+		if item.Symbol == `` {
+			continue
+		}
+		if item.Label == `` {
+			item.Label = item.Symbol
+		}
+		if item.Row > 0 {
+			row = item.Row
+		}
+		if row > marketRows {
+			row = marketRows
+		}
+		item.Row = row
+		if item.Change != `percent` && item.Change != `absolute` {
+			item.Change = `both`
+		}
+		resolved = append(resolved, item)
+	}
+	return resolved
+}
+
 // -----------------------------------------------------------------------------
-func (market *Market) isMarketOpen(body []byte) []byte {
-	return body
+func (market *Market) isMarketOpen(now time.Time) {
+	market.Session = Exchanges[`NYSE`].SessionAt(now)
+	market.IsClosed = market.Session == SessionClosed
+}
+
+// Every exchange of the calendar is closed, so none of the indexes in the header moves. Crypto currencies and symbols of other exchanges are taken as always open; currencies and futures follow the exchanges.
+func (market *Market) allClosed(now time.Time) bool {
+	for _, exchange := range Exchanges {
+		if exchange.SessionAt(now) != SessionClosed {
+			return false
+		}
+	}
+	for _, item := range market.profile.MarketItems() {
+		if ExchangeOf(item.Symbol) == nil && !strings.Contains(item.Symbol, `=`) {
+			return false
+		}
+	}
+	return true
 }
 
 // -----------------------------------------------------------------------------
-func assign(results []map[string]interface{}, position int, changeAsPercent bool) map[string]string {
-	out := make(map[string]string)
-	out[`change`] = float2Str(results[position]["regularMarketChange"].(float64))
-	out[`latest`] = float2Str(results[position]["regularMarketPrice"].(float64))
-	if changeAsPercent {
-		out[`change`] = float2Str(results[position]["regularMarketChangePercent"].(float64)) + `%`
-	} else {
-		out[`percent`] = float2Str(results[position]["regularMarketChangePercent"].(float64))
+func (market *Market) symbols() []string {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, item := range market.profile.MarketItems() {
+		if !seen[item.Symbol] {
+			symbols = append(symbols, item.Symbol)
+			seen[item.Symbol] = true
+		}
+	}
+	market.mutex.Lock()
+	rates := rateSymbols(market.currencies)
+	market.mutex.Unlock()
+	rates = append(rates, rateSymbols(map[string]bool{market.profile.BaseCurrency: true})...)
+	for _, symbol := range rates {
+		if !seen[symbol] {
+			symbols = append(symbols, symbol)
+			seen[symbol] = true
+		}
+	}
+	return symbols
+}
+
+// This function fetches the rates of the currencies without one, e.g. of the stocks held, and has every market fetch that follows keep them up to date. A rate that cannot be fetched now is left to those.
+func (market *Market) fetchRates(ctx context.Context, codes []string) {
+	wanted := make(map[string]bool)
+	market.mutex.Lock()
+	for _, code := range codes {
+		if major, ok := minorCurrencies[code]; ok {
+			code = major
+		}
+		if _, known := market.rates[code]; code != `` && !known && !market.currencies[code] {
+			market.currencies[code], wanted[code] = true, true
+		}
+	}
+	market.mutex.Unlock()
+
+	if symbols := rateSymbols(wanted); len(symbols) > 0 {
+		if results, err := market.provider.FetchMarket(ctx, symbols); err == nil {
+			market.storeRates(results)
+		}
+	}
+}
+
+// This function returns the symbols of the U.S. dollar rates of the currencies, sorted.
+func rateSymbols(currencies map[string]bool) []string {
+	var symbols []string
+	for code := range currencies {
+		if code != `USD` {
+			symbols = append(symbols, code+`=X`)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// This function keeps the rates among the results, whose symbols such as `EUR=X` give the units of the currency per U.S. dollar.
+func (market *Market) storeRates(results []Stock) {
+	market.mutex.Lock()
+	defer market.mutex.Unlock()
+
+	for _, result := range results {
+		if code := strings.TrimSuffix(result.Ticker, `=X`); len(code) == 3 && code != result.Ticker && result.LastTrade.Valid {
+			market.rates[code] = result.LastTrade.Value
+		}
 	}
-	return out
 }
 
 // -----------------------------------------------------------------------------
-func (market *Market) extract(body []byte) *Market {
-	d := map[string]map[string][]map[string]interface{}{}
-	err := json.Unmarshal(body, &d)
-	if err != nil {
-		panic(err)
-	}
-	results := d["quoteResponse"]["result"]
-	market.Dow = assign(results, 0, false)
-	market.Nasdaq = assign(results, 1, false)
-	market.Sp500 = assign(results, 2, false)
-	market.Tokyo = assign(results, 3, false)
-	market.HongKong = assign(results, 4, false)
-	market.London = assign(results, 5, false)
-	market.Frankfurt = assign(results, 6, false)
-	market.Yield[`name`] = `10-year Yield`
-	market.Yield = assign(results, 7, false)
-
-	market.Oil = assign(results, 8, true)
-	market.Yen = assign(results, 9, true)
-	market.Euro = assign(results, 10, true)
-	market.Gold = assign(results, 11, true)
+func (market *Market) extract(results []Stock) *Market {
+	bySymbol := make(map[string]Stock, len(results))
+	for _, result := range results {
+		bySymbol[result.Ticker] = result
+	}
+
+	items := market.profile.MarketItems()
+	quotes := make([]MarketQuote, 0, len(items))
+	for _, item := range items {
+		result := bySymbol[item.Symbol]
+		quotes = append(quotes, MarketQuote{
+			Symbol:    item.Symbol,
+			Label:     item.Label,
+			Row:       item.Row,
+			Latest:    result.LastTrade,
+			Change:    result.Change,
+			ChangePct: result.ChangePct,
+			item:      item,
+		})
+	}
+	market.Quotes = quotes
+	market.storeRates(results)
 
 	return market
 }
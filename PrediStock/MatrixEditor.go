@@ -48,17 +48,27 @@ func (editor *ColumnEditor) selectCurrentColumn() *ColumnEditor {
 }
 //-----------------------------------------------------------------------------
 func (editor *ColumnEditor) selectLeftColumn() *ColumnEditor {
-	editor.profile.selectedColumn--
-	if editor.profile.selectedColumn < 0 {
-		editor.profile.selectedColumn = editor.layout.TotalColumns() - 1
+	for i := 0; i < editor.layout.TotalColumns(); i++ {
+		editor.profile.selectedColumn--
+		if editor.profile.selectedColumn < 0 {
+			editor.profile.selectedColumn = editor.layout.TotalColumns() - 1
+		}
+		if editor.layout.ColumnVisible(editor.profile.selectedColumn, editor.profile) {
+			break
+		}
 	}
 	return editor
 }
 //-----------------------------------------------------------------------------
 func (editor *ColumnEditor) selectRightColumn() *ColumnEditor {
-	editor.profile.selectedColumn++
-	if editor.profile.selectedColumn > editor.layout.TotalColumns()-1 {
-		editor.profile.selectedColumn = 0
+	for i := 0; i < editor.layout.TotalColumns(); i++ {
+		editor.profile.selectedColumn++
+		if editor.profile.selectedColumn > editor.layout.TotalColumns()-1 {
+			editor.profile.selectedColumn = 0
+		}
+		if editor.layout.ColumnVisible(editor.profile.selectedColumn, editor.profile) {
+			break
+		}
 	}
 	return editor
 }
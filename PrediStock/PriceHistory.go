@@ -0,0 +1,170 @@
+package mop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Historical price data is exchanged as OHLCV CSV files, one file per ticker, in the same layout Yahoo Finance
+uses for its "Download" button:
+
+	Date,Open,High,Low,Close,Adj Close,Volume
+	2024-01-02,187.15,188.44,183.89,185.64,185.40,82488700
+
+Columns are matched by name (case insensitive) so their order does not matter and `Adj Close` is optional.
+Rows with missing values (Yahoo writes `null`) are skipped.
+*/
+
+// Bar is a single OHLCV candle.
+type Bar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+}
+
+var barTimeLayouts = []string{`2006-01-02`, `2006-01-02 15:04:05`, `2006-01-02T15:04:05Z07:00`, `01/02/2006`}
+
+// This function reads OHLCV bars from a CSV stream with a header row. The bars are returned sorted by time, oldest first. An error is returned when a required column is missing or a date cannot be parsed.
+func ReadBars(reader io.Reader) ([]Bar, error) {
+	rows := csv.NewReader(reader)
+	rows.FieldsPerRecord = -1
+	rows.TrimLeadingSpace = true
+
+	header, err := rows.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{`date`, `open`, `high`, `low`, `close`} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+
+	var bars []Bar
+	for line := 2; ; line++ {
+		record, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bar, ok, err := parseBar(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if ok {
+			bars = append(bars, bar)
+		}
+	}
+
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+
+	return bars, nil
+}
+
+// This function loads the bars stored in a CSV file. The ticker is taken from the file name, so `data/aapl.csv` yields `AAPL`.
+func LoadBars(filename string) (ticker string, bars []Bar, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ``, nil, err
+	}
+	defer file.Close()
+
+	if bars, err = ReadBars(file); err != nil {
+		return ``, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	base := filepath.Base(filename)
+
+	return strings.ToUpper(strings.TrimSuffix(base, filepath.Ext(base))), bars, nil
+}
+
+// This function writes bars in the same CSV layout `ReadBars` accepts. Bars with a time of day are written with a timestamp, daily bars with a plain date.
+func WriteBars(writer io.Writer, bars []Bar) error {
+	out := csv.NewWriter(writer)
+	out.Write([]string{`Date`, `Open`, `High`, `Low`, `Close`, `Volume`})
+
+	for _, bar := range bars {
+		layout := barTimeLayouts[0]
+		if bar.Time.Hour() != 0 || bar.Time.Minute() != 0 || bar.Time.Second() != 0 {
+			layout = barTimeLayouts[1]
+		}
+		out.Write([]string{
+			bar.Time.Format(layout),
+			formatPrice(bar.Open),
+			formatPrice(bar.High),
+			formatPrice(bar.Low),
+			formatPrice(bar.Close),
+			strconv.FormatInt(bar.Volume, 10),
+		})
+	}
+	out.Flush()
+
+	return out.Error()
+}
+
+// This function returns the closing prices of the given bars.
+func Closes(bars []Bar) []float64 {
+	closes := make([]float64, len(bars))
+	for i, bar := range bars {
+		closes[i] = bar.Close
+	}
+	return closes
+}
+
+// -----------------------------------------------------------------------------
+func parseBar(record []string, columns map[string]int) (bar Bar, ok bool, err error) {
+	field := func(name string) string {
+		if i, found := columns[name]; found && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ``
+	}
+
+	date := field(`date`)
+	for _, layout := range barTimeLayouts {
+		if bar.Time, err = time.Parse(layout, date); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return bar, false, fmt.Errorf("invalid date %q", date)
+	}
+
+	prices := []*float64{&bar.Open, &bar.High, &bar.Low, &bar.Close}
+	for i, name := range []string{`open`, `high`, `low`, `close`} {
+		value, parseErr := strconv.ParseFloat(field(name), 64)
+		if parseErr != nil {
+			return bar, false, nil
+		}
+		*prices[i] = value
+	}
+	if volume := field(`volume`); volume != `` {
+		if value, parseErr := strconv.ParseFloat(volume, 64); parseErr == nil {
+			bar.Volume = int64(value)
+		}
+	}
+
+	return bar, true, nil
+}
+
+// -----------------------------------------------------------------------------
+func formatPrice(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -1,234 +1,589 @@
 package mop
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"reflect"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-const quotesURL = `https://query1.finance.yahoo.com/v7/finance/quote?crumb=%s&symbols=%s`
-
-const quotesURLQueryParts = `&range=1d&interval=5m&indicators=close&includeTimestamps=false&includePrePost=false&corsDomain=finance.yahoo.com&.tsrc=finance`
+// Number is a decimal quote value. Valid is false when the provider did not report the value.
+type Number struct {
+	Value float64
+	Valid bool
+}
 
-const noDataIndicator = `N/A`
+// Integer is a whole quote value such as a volume. Valid is false when the provider did not report the value.
+type Integer struct {
+	Value int64
+	Valid bool
+}
 
 type Stock struct {
-	Ticker     string `json:"symbol"`                      // Stock ticker.
-	LastTrade  string `json:"regularMarketPrice"`          // l1: last trade.
-	Change     string `json:"regularMarketChange"`         // c6: change real time.
-	ChangePct  string `json:"regularMarketChangePercent"`  // k2: percent change real time.
-	Open       string `json:"regularMarketOpen"`           // o: market open price.
-	Low        string `json:"regularMarketDayLow"`         // g: day's low.
-	High       string `json:"regularMarketDayHigh"`        // h: day's high.
-	Low52      string `json:"fiftyTwoWeekLow"`             // j: 52-weeks low.
-	High52     string `json:"fiftyTwoWeekHigh"`            // k: 52-weeks high.
-	Volume     string `json:"regularMarketVolume"`         // v: volume.
-	AvgVolume  string `json:"averageDailyVolume10Day"`     // a2: average volume.
-	PeRatio    string `json:"trailingPE"`                  // r2: P/E ration real time.
-	PeRatioX   string `json:"trailingPE"`                  // r: P/E ration (fallback when real time is N/A).
-	Dividend   string `json:"trailingAnnualDividendRate"`  // d: dividend.
-	Yield      string `json:"trailingAnnualDividendYield"` // y: dividend yield.
-	MarketCap  string `json:"marketCap"`                   // j3: market cap real time.
-	MarketCapX string `json:"marketCap"`                   // j1: market cap (fallback when real time is N/A).
-	Currency   string `json:"currency"`                    // String code for currency of stock.
-	Direction  int    // -1 when change is < $0, 0 when change is = $0, 1 when change is > $0.
-	PreOpen    string `json:"preMarketChangePercent,omitempty"`
-	AfterHours string `json:"postMarketChangePercent,omitempty"`
+	Ticker        string    `json:"ticker"`             // Stock ticker.
+	LastTrade     Number    `json:"last"`               // Last trade.
+	Change        Number    `json:"change"`             // Change since the previous close.
+	ChangePct     Number    `json:"changePercent"`      // Percent change since the previous close.
+	Open          Number    `json:"open"`               // Market open price.
+	Low           Number    `json:"low"`                // Day's low.
+	High          Number    `json:"high"`               // Day's high.
+	Low52         Number    `json:"low52"`              // 52-weeks low.
+	High52        Number    `json:"high52"`             // 52-weeks high.
+	Volume        Integer   `json:"volume"`             // Volume.
+	AvgVolume     Integer   `json:"avgVolume"`          // Average daily volume.
+	PeRatio       Number    `json:"pe"`                 // P/E ratio real time.
+	PeRatioX      Number    `json:"peX"`                // P/E ratio (fallback when real time is missing).
+	Dividend      Number    `json:"dividend"`           // Annual dividend.
+	Yield         Number    `json:"yield"`              // Dividend yield as a fraction, e.g. 0.03 for 3%.
+	MarketCap     Number    `json:"mktCap"`             // Market cap real time.
+	MarketCapX    Number    `json:"mktCapX"`            // Market cap (fallback when real time is missing).
+	Currency      string    `json:"currency"`           // String code for currency of stock.
+	Direction     int       `json:"direction"`          // -1 when change is < $0, 0 when change is = $0, 1 when change is > $0.
+	PreOpen       Number    `json:"preOpen"`            // Pre-market percent change.
+	AfterHours    Number    `json:"afterHours"`         // After hours percent change.
+	Intraday      []float64 `json:"intraday,omitempty"` // Intraday closes ending with the last trade, for the sparkline.
+	Quantity      Number    `json:"quantity"`           // Shares held according to the holdings in the profile.
+	Value         Number    `json:"value"`              // Position value in the base currency.
+	DayPnL        Number    `json:"dayPnl"`             // Today's profit or loss of the position in the base currency.
+	UnrealizedPnL Number    `json:"unrealizedPnl"`      // Position value minus its cost in the base currency.
+	Weight        Number    `json:"weight"`             // Percent of the value of all positions.
+}
+
+// lastQuote is the last quote received for a ticker, with the time it was received on the clock of the provider.
+type lastQuote struct {
+	stock Stock
+	at    time.Time
 }
 
+// Intraday charts change slowly, so they are fetched less often than the quotes.
+const chartsRefresh = 60 * time.Second
+
+// Opening the stream gives up after streamTimeout, and is not tried again for streamRetry.
+const (
+	streamTimeout = 5 * time.Second
+	streamRetry   = 30 * time.Second
+)
+
 type Quotes struct {
-	market  *Market  // Pointer to Market.
-	profile *Profile // Pointer to Profile.
-	stocks  []Stock  // Array of stock quote data.
-	errors  string   // Error string if any.
+	market    *Market              // Pointer to Market.
+	profile   *Profile             // Pointer to Profile.
+	store     *TickStore           // Local store recording every snapshot, nil when disabled.
+	alerts    *AlertWatcher        // Alert rules evaluated after every refresh.
+	ledger    *Ledger              // Transaction ledger whose open lots are added to the holdings.
+	stocks    []Stock              // Array of stock quote data.
+	fetchedAt time.Time            // Time of the last successful fetch, on the clock of the provider.
+	err       error                // Why the last fetch got no quote at all, nil when it got some.
+	failures  map[string]error     // Tickers of the batches the last fetch could not get, see BatchFetcher.go.
+	unknown   map[string]bool      // Tickers the provider returned no quote for although their batch succeeded.
+	last      map[string]lastQuote // Last quote received per ticker, shown while fetching it fails.
+	symbols   *SymbolDirectory     // Symbols known to exist, checked by the `+` prompt.
+	charts    map[string][]float64 // Intraday closes per ticker for the sparkline column.
+	chartsAt  time.Time            // Time the intraday charts were last fetched.
+	mutex     sync.Mutex           // Guards the charts, which may be fetched by overlapping refreshes.
+	stream    QuoteStream          // Stream of quote updates, nil while polling.
+	streamed  string               // Tickers the stream is subscribed to, comma separated.
+	dialing   context.CancelFunc   // Cancels the opening or subscription of the stream under way, nil when none is.
+	dials     int                  // Incremented with every opening of the stream, so that one cancelled can tell.
+	dialAfter time.Time            // The stream is not opened again before then, after opening it failed.
+	feed      sync.Mutex           // Guards the stream and its opening, which runs in the background.
+	worker    *fetchWorker         // Runs the fetches, see FetchWorker.go.
 }
 
 func NewQuotes(market *Market, profile *Profile) *Quotes {
-	return &Quotes{
+	quotes := &Quotes{
 		market:  market,
 		profile: profile,
-		errors:  ``,
+		charts:  make(map[string][]float64),
+		last:    make(map[string]lastQuote),
+		symbols: NewSymbolDirectory(profile.SymbolsPath()),
+		alerts:  NewAlertWatcher(profile),
+		ledger:  NewLedger(profile.LedgerPath()),
+		worker:  newFetchWorker(),
 	}
+	if !profile.NoStore {
+		quotes.store = NewTickStore(profile.StorePath())
+	}
+
+	return quotes
 }
 
+// This function fetches the quotes and applies them before returning. It is meant for callers without an event loop, such as the quote command and the server; the screen uses Refresh and Fetched instead.
 func (quotes *Quotes) Fetch() (self *Quotes) {
-	self = quotes
-	if quotes.isReady() {
-		defer func() {
-			if err := recover(); err != nil {
-				quotes.errors = fmt.Sprintf("\n\n\n\nError fetching stock quotes...\n%s", err)
-			} else {
-				quotes.errors = ""
+	if quotes.Refresh() {
+		(<-quotes.Fetched()).Apply()
+	}
+	return quotes
+}
+
+// This function asks the worker for fresh quotes of the tickers of the profile, whose result arrives on the Fetched channel. A request made while another one is waiting replaces it. It returns false when nothing was requested: no tickers, or all of them trade on closed exchanges and were fetched recently.
+func (quotes *Quotes) Refresh() bool {
+	held, _ := quotes.ledger.Holdings()
+	if !quotes.isReady() {
+		return false
+	}
+
+	// The worker gets copies: the profile may change while it fetches.
+	tickers := append([]string(nil), quotes.profile.Tickers...)
+	holdings := mergeHoldings(nil, mergeHoldings(quotes.profile.Holdings, held))
+	base, sparkline := quotes.profile.BaseCurrency, quotes.profile.Sparkline
+	size, workers := quotes.profile.QuotesBatchSize(), quotes.profile.FetchWorkerCount()
+	quotes.worker.submit(func(ctx context.Context) func() {
+		stocks, failures := fetchBatches(ctx, quotes.market.provider, tickers, size, workers)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if len(stocks) == 0 && len(failures) > 0 {
+			// Batches that succeeded without a stock leave their tickers out of the failures.
+			var err error
+			for _, ticker := range tickers {
+				if err = failures[ticker]; err != nil {
+					break
+				}
+			}
+			return func() {
+				quotes.keep(tickers, nil, failures, time.Time{})
+				quotes.err = err
 			}
-		}()
+		}
 
-		url := fmt.Sprintf(quotesURL, quotes.market.crumb, strings.Join(quotes.profile.Tickers, `,`))
+		if sparkline {
+			quotes.attachCharts(ctx, stocks, workers)
+		}
+		currencies := []string{base}
+		for _, stock := range stocks {
+			if len(holdings[stock.Ticker]) > 0 {
+				currencies = append(currencies, stock.Currency)
+			}
+		}
+		quotes.market.fetchRates(ctx, currencies)
+		attachPositions(stocks, holdings, base, quotes.market)
+		fetchedAt := quotes.market.Now()
+		if quotes.store != nil {
+			quotes.store.Append(fetchedAt, stocks)
+		}
 
-		client := http.Client{}
-		request, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			panic(err)
-		}
-
-		request.Header = http.Header{
-			"Accept":          {"*/*"},
-			"Accept-Language": {"en-US,en;q=0.5"},
-			"Connection":      {"keep-alive"},
-			"Content-Type":    {"application/json"},
-			"Cookie":          {quotes.market.cookies},
-			"Host":            {"query1.finance.yahoo.com"},
-			"Origin":          {"https://finance.yahoo.com"},
-			"Referer":         {"https://finance.yahoo.com"},
-			"Sec-Fetch-Dest":  {"empty"},
-			"Sec-Fetch-Mode":  {"cors"},
-			"Sec-Fetch-Site":  {"same-site"},
-			"TE":              {"trailers"},
-			"User-Agent":      {userAgent},
-		}
-
-		response, err := client.Do(request)
-		if err != nil {
-			panic(err)
+		return func() {
+			quotes.keep(tickers, stocks, failures, fetchedAt)
+			quotes.fetchedAt, quotes.err = fetchedAt, nil
+			quotes.alerts.Check(quotes.stocks, fetchedAt)
+			quotes.subscribe(tickers)
 		}
+	})
 
-		defer response.Body.Close()
-		body, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			panic(err)
+	return true
+}
+
+// This function returns the channel delivering the result of every fetch requested by Refresh. The goroutine owning the quotes applies them with FetchResult.Apply, which replaces the stocks in one go.
+func (quotes *Quotes) Fetched() <-chan FetchResult {
+	return quotes.worker.results
+}
+
+// This function merges the updates pushed by the stream into the stocks and returns the tickers whose row changed, including rows whose portfolio weight moved. Updates for tickers without a quote yet are ignored.
+func (quotes *Quotes) Merge(deltas []QuoteDelta) []string {
+	stocks := quotes.Stocks()
+	index := make(map[string]int, len(stocks))
+	for i, stock := range stocks {
+		index[stock.Ticker] = i
+	}
+	var pushed []string
+	for _, delta := range deltas {
+		i, ok := index[delta.Ticker]
+		if !ok {
+			continue
+		}
+		if stock, err := mergeDelta(stocks[i], delta); err == nil {
+			stocks[i], pushed = stock, append(pushed, delta.Ticker)
+		}
+	}
+	if len(pushed) == 0 {
+		return nil
+	}
+
+	held, _ := quotes.ledger.Holdings()
+	attachPositions(stocks, mergeHoldings(quotes.profile.Holdings, held), quotes.profile.BaseCurrency, quotes.market)
+	var changed []string
+	for i := range stocks {
+		if !reflect.DeepEqual(stocks[i], quotes.stocks[i]) {
+			changed = append(changed, stocks[i].Ticker)
 		}
+	}
+	quotes.stocks = stocks
+	now := quotes.market.Now()
+	for _, ticker := range pushed {
+		quotes.last[ticker] = lastQuote{stocks[index[ticker]], now}
+		delete(quotes.failures, ticker)
+	}
+	if quotes.store != nil {
+		quotes.store.Append(now, stocks)
+	}
+	quotes.alerts.Check(stocks, now)
+
+	return changed
+}
 
-		quotes.parse2(body)
+// This function returns the channel of the updates pushed by the stream, or nil while the quotes are polled.
+func (quotes *Quotes) Updates() <-chan QuoteDelta {
+	quotes.feed.Lock()
+	defer quotes.feed.Unlock()
+
+	if quotes.stream == nil {
+		return nil
 	}
+	return quotes.stream.Updates()
+}
 
-	return quotes
+// This function reports whether the quotes must still be polled. They need not be while a stream pushes updates for every row, except for the sparkline charts, which are fetched again every minute. Tickers that failed or have no quote yet get no row from the stream, see Merge, so they keep being polled.
+func (quotes *Quotes) Polling() bool {
+	quotes.feed.Lock()
+	streaming := quotes.stream != nil
+	quotes.feed.Unlock()
+
+	if !streaming || len(quotes.failures) > 0 || len(quotes.stocks) < len(quotes.profile.Tickers) {
+		return true
+	}
+	return quotes.profile.Sparkline && quotes.fetchedAt.Before(quotes.market.Now().Add(-chartsRefresh))
+}
+
+// This function closes the stream, e.g. after its updates channel was closed, and cancels its opening if it is under way. Polling resumes and the next fetch opens a new stream.
+func (quotes *Quotes) CloseStream() {
+	quotes.feed.Lock()
+	defer quotes.feed.Unlock()
+
+	quotes.cancelDial()
+	if quotes.stream != nil {
+		quotes.stream.Close()
+		quotes.stream, quotes.streamed = nil, ``
+	}
+}
+
+// This function returns a copy of the stocks fetched last, in the order of the tickers of the profile. Tickers whose last fetch failed have their last quote, see Stale.
+func (quotes *Quotes) Stocks() []Stock {
+	return append([]Stock(nil), quotes.stocks...)
+}
+
+// This function returns the banner of the alerts fired in the last minute, or an empty string.
+func (quotes *Quotes) AlertBanner() string {
+	return quotes.alerts.Banner(quotes.market.Now())
+}
+
+// This function reports whether the terminal bell should ring for the alerts fired since it was last called, see AlertWatcher.Bell.
+func (quotes *Quotes) AlertBell() bool {
+	return quotes.alerts.Bell()
+}
+
+// This function describes why alert conditions cannot be evaluated or the alert command fails, see AlertWatcher.Errors.
+func (quotes *Quotes) AlertErrors() []string {
+	return quotes.alerts.Errors()
+}
+
+// This function returns the alert errors on one line, or an empty string when all is well.
+func (quotes *Quotes) AlertStatus() string {
+	return strings.Join(quotes.AlertErrors(), ` | `)
+}
+
+// This function describes the sign-in of the provider while it is failing, see AuthenticatedProvider, and returns an empty string otherwise.
+func (quotes *Quotes) AuthStatus() string {
+	if provider, ok := quotes.market.provider.(AuthenticatedProvider); ok {
+		return provider.AuthStatus()
+	}
+	return ``
+}
+
+// This function describes in a few words why the last fetch of the quotes or of the market failed, and why the local store cannot record the quotes, and returns an empty string when all is well.
+func (quotes *Quotes) FetchStatus() string {
+	var status, failed, unknown []string
+	var err error
+	for _, ticker := range quotes.profile.Tickers {
+		if failure, ok := quotes.failures[ticker]; ok {
+			failed, err = append(failed, ticker), failure
+		} else if quotes.unknown[ticker] {
+			unknown = append(unknown, ticker)
+		}
+	}
+	if quotes.err != nil {
+		status = append(status, describeFetchError(`quotes`, quotes.err))
+	} else if len(failed) == 1 {
+		status = append(status, describeFetchError(`quote of `+failed[0], err))
+	} else if len(failed) > 1 {
+		status = append(status, describeFetchError(fmt.Sprintf("quotes of %d tickers", len(failed)), err))
+	}
+	if len(unknown) > 0 {
+		status = append(status, `unknown `+strings.Join(unknown, `, `))
+	}
+	if quotes.market.err != nil {
+		status = append(status, describeFetchError(`market`, quotes.market.err))
+	}
+	if quotes.store != nil {
+		if err := quotes.store.Err(); err != nil {
+			status = append(status, describeFetchError(`local store`, err))
+		}
+	}
+	return strings.Join(status, ` | `)
+}
+
+// This function returns why the last fetch got no quote for the ticker, nil when its batch succeeded.
+func (quotes *Quotes) Failure(ticker string) error {
+	return quotes.failures[ticker]
+}
+
+// This function returns when the quote of the ticker was received, on the clock of the provider, and whether it is stale because the fetches since then failed. The time is zero when no quote was ever received.
+func (quotes *Quotes) Stale(ticker string) (time.Time, bool) {
+	_, failed := quotes.failures[ticker]
+	return quotes.last[ticker].at, failed
+}
+
+// This function reports whether the provider has no quote for the ticker, e.g. because of a typo.
+func (quotes *Quotes) Unknown(ticker string) bool {
+	return quotes.unknown[ticker]
+}
+
+// This function returns the last alerts fired, oldest first.
+func (quotes *Quotes) AlertHistory() []AlertEvent {
+	return quotes.alerts.History()
+}
+
+// This function returns the stock under the row cursor. It returns false when no row is selected or the selected ticker has no quote yet.
+func (quotes *Quotes) Selected() (Stock, bool) {
+	for _, stock := range quotes.stocks {
+		if stock.Ticker == quotes.profile.selectedTicker && stock.Ticker != `` {
+			return stock, true
+		}
+	}
+	return Stock{}, false
 }
 func (quotes *Quotes) Ok() (bool, string) {
-	return quotes.errors == ``, quotes.errors
+	if quotes.err != nil {
+		return false, fmt.Sprintf("Error fetching stock quotes...\n%s", quotes.err)
+	}
+	return true, ``
 }
 func (quotes *Quotes) AddTickers(tickers []string) (added int, err error) {
 	if added, err = quotes.profile.AddTickers(tickers); err == nil && added > 0 {
-		quotes.stocks = nil
+		quotes.invalidate()
 	}
 	return
 }
 func (quotes *Quotes) RemoveTickers(tickers []string) (removed int, err error) {
 	if removed, err = quotes.profile.RemoveTickers(tickers); err == nil && removed > 0 {
-		quotes.stocks = nil
+		quotes.invalidate()
+	}
+	return
+}
+func (quotes *Quotes) NextWatchlist() (err error) {
+	if err = quotes.profile.NextWatchlist(); err == nil {
+		quotes.invalidate()
 	}
 	return
 }
+func (quotes *Quotes) SelectWatchlist(name string) (err error) {
+	if err = quotes.profile.SelectWatchlist(name); err == nil {
+		quotes.invalidate()
+	}
+	return
+}
+func (quotes *Quotes) CreateWatchlist(name string) (err error) {
+	if err = quotes.profile.CreateWatchlist(name); err == nil {
+		quotes.invalidate()
+	}
+	return
+}
+func (quotes *Quotes) DeleteWatchlist() (err error) {
+	if err = quotes.profile.DeleteWatchlist(); err == nil {
+		quotes.invalidate()
+	}
+	return
+}
+
+// This function drops the stocks and the fetches under way after the tickers changed. The stream is subscribed to the new tickers after the next fetch.
+func (quotes *Quotes) invalidate() {
+	quotes.worker.cancelAll()
+	quotes.feed.Lock()
+	quotes.cancelDial()
+	quotes.feed.Unlock()
+	quotes.stocks = nil
+}
 func (quotes *Quotes) isReady() bool {
-	return (quotes.stocks == nil || !quotes.market.IsClosed) && len(quotes.profile.Tickers) > 0
-}
-func (quotes *Quotes) parse2(body []byte) (*Quotes, error) {
-	d := map[string]map[string][]map[string]interface{}{}
-	err := json.Unmarshal(body, &d)
-	if err != nil {
-		return nil, err
-	}
-	results := d["quoteResponse"]["result"]
-
-	quotes.stocks = make([]Stock, len(results))
-	for i, raw := range results {
-		result := map[string]string{}
-		for k, v := range raw {
-			switch v.(type) {
-			case string:
-				result[k] = v.(string)
-			case float64:
-				result[k] = float2Str(v.(float64))
-			default:
-				result[k] = fmt.Sprintf("%v", v)
-			}
+	if len(quotes.profile.Tickers) == 0 {
+		return false
+	}
+	// While every exchange of the tickers is closed, the quotes only change with corrections: refresh them rarely.
+	now := quotes.market.Now()
+	return quotes.stocks == nil || len(quotes.failures) > 0 || quotes.fetchedAt.Before(now.Add(-closedRefresh)) || !allClosed(quotes.profile.Tickers, now)
+}
+
+// This function replaces the stocks by those just fetched, in the order of the tickers. Tickers whose batch failed keep their last quote; tickers missing from a batch that succeeded are unknown to the provider.
+func (quotes *Quotes) keep(tickers []string, fetched []Stock, failures map[string]error, at time.Time) {
+	received := make(map[string]bool, len(fetched))
+	known := make([]SymbolMatch, 0, len(fetched))
+	for _, stock := range fetched {
+		received[stock.Ticker] = true
+		quotes.last[stock.Ticker] = lastQuote{stock, at}
+		known = append(known, SymbolMatch{Symbol: stock.Ticker})
+	}
+	quotes.symbols.Add(known...)
+
+	stocks := append([]Stock(nil), fetched...)
+	quotes.unknown = make(map[string]bool)
+	for _, ticker := range tickers {
+		if received[ticker] {
+			continue
 		}
-		quotes.stocks[i].Ticker = result["symbol"]
-		quotes.stocks[i].LastTrade = result["regularMarketPrice"]
-		quotes.stocks[i].Change = result["regularMarketChange"]
-		quotes.stocks[i].ChangePct = result["regularMarketChangePercent"]
-		quotes.stocks[i].Open = result["regularMarketOpen"]
-		quotes.stocks[i].Low = result["regularMarketDayLow"]
-		quotes.stocks[i].High = result["regularMarketDayHigh"]
-		quotes.stocks[i].Low52 = result["fiftyTwoWeekLow"]
-		quotes.stocks[i].High52 = result["fiftyTwoWeekHigh"]
-		quotes.stocks[i].Volume = result["regularMarketVolume"]
-		quotes.stocks[i].AvgVolume = result["averageDailyVolume10Day"]
-		quotes.stocks[i].PeRatio = result["trailingPE"]
-		quotes.stocks[i].PeRatioX = result["trailingPE"]
-		quotes.stocks[i].Dividend = result["trailingAnnualDividendRate"]
-		quotes.stocks[i].Yield = result["trailingAnnualDividendYield"]
-		quotes.stocks[i].MarketCap = result["marketCap"]
-		quotes.stocks[i].MarketCapX = result["marketCap"]
-		quotes.stocks[i].Currency = result["currency"]
-		quotes.stocks[i].PreOpen = result["preMarketChangePercent"]
-		quotes.stocks[i].AfterHours = result["postMarketChangePercent"]
-		adv, err := strconv.ParseFloat(quotes.stocks[i].Change, 64)
-		quotes.stocks[i].Direction = 0
-		if err == nil {
-			if adv < 0.0 {
-				quotes.stocks[i].Direction = -1
-			} else if adv > 0.0 {
-				quotes.stocks[i].Direction = 1
-			}
+		if _, failed := failures[ticker]; !failed {
+			quotes.unknown[ticker] = true
+		} else if last, ok := quotes.last[ticker]; ok {
+			stocks = append(stocks, last.stock)
 		}
 	}
-	return quotes, nil
+	sortByTickers(stocks, tickers)
+
+	quotes.stocks, quotes.failures = stocks, failures
 }
-func (quotes *Quotes) parse(body []byte) *Quotes {
-	lines := bytes.Split(body, []byte{'\n'})
-	quotes.stocks = make([]Stock, len(lines))
-	fieldsCount := reflect.ValueOf(quotes.stocks[0]).NumField() - 1
-	for i, line := range lines {
-		columns := bytes.Split(bytes.TrimSpace(line), []byte{','})
-		for j := 0; j < fieldsCount; j++ {
-			reflect.ValueOf(&quotes.stocks[i]).Elem().Field(j).SetString(string(columns[j]))
+
+// This function opens the stream of a streaming provider when there is none yet and subscribes it to the tickers, unless it already is. It is called once the quotes were applied; opening the stream waits for the WebSocket handshake, so it runs in a goroutine of its own, which installs the stream when it is ready. Opening is bounded by streamTimeout and cancelled by invalidate and CloseStream.
+func (quotes *Quotes) subscribe(tickers []string) {
+	provider, ok := quotes.market.provider.(StreamingProvider)
+	if !ok {
+		return
+	}
+	quotes.feed.Lock()
+	defer quotes.feed.Unlock()
+
+	joined := strings.Join(tickers, `,`)
+	if quotes.dialing != nil || (quotes.stream != nil && quotes.streamed == joined) || (quotes.stream == nil && time.Now().Before(quotes.dialAfter)) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	quotes.dials++
+	dial, current := quotes.dials, quotes.stream
+	quotes.dialing = cancel
+
+	go func() {
+		defer cancel()
+		stream, err := current, error(nil)
+		if stream == nil {
+			stream, err = provider.OpenStream(ctx)
 		}
-		if quotes.stocks[i].PeRatio == `N/A` && quotes.stocks[i].PeRatioX != `N/A` {
-			quotes.stocks[i].PeRatio = quotes.stocks[i].PeRatioX
+		if err == nil {
+			if err = stream.Subscribe(tickers); err != nil {
+				stream.Close()
+			}
 		}
-		if quotes.stocks[i].MarketCap == `N/A` && quotes.stocks[i].MarketCapX != `N/A` {
-			quotes.stocks[i].MarketCap = quotes.stocks[i].MarketCapX
+
+		quotes.feed.Lock()
+		defer quotes.feed.Unlock()
+		if dial != quotes.dials || quotes.stream != current {
+			// Cancelled, or the stream was closed in the meantime: the next fetch starts over.
+			if err == nil && stream != current {
+				stream.Close()
+			}
+			return
 		}
-		adv, err := strconv.ParseFloat(quotes.stocks[i].Change, 64)
-		quotes.stocks[i].Direction = 0
-		if err == nil {
-			if adv < 0 {
-				quotes.stocks[i].Direction = -1
-			} else if adv > 0 {
-				quotes.stocks[i].Direction = 1
+		quotes.dialing = nil
+		if err != nil {
+			quotes.stream, quotes.streamed = nil, ``
+			quotes.dialAfter = time.Now().Add(streamRetry)
+			return
+		}
+		quotes.stream, quotes.streamed = stream, joined
+	}()
+}
+
+// This function cancels the opening of the stream under way, if any. The caller holds the feed lock.
+func (quotes *Quotes) cancelDial() {
+	if quotes.dialing != nil {
+		quotes.dialing()
+		quotes.dialing = nil
+		quotes.dials++
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (quotes *Quotes) attachCharts(ctx context.Context, stocks []Stock, workers int) {
+	quotes.mutex.Lock()
+	defer quotes.mutex.Unlock()
+
+	stale := time.Since(quotes.chartsAt) > chartsRefresh
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	slots := make(chan struct{}, workers)
+	// The charts to fetch are listed first: the goroutines below write the map while the loop would read it.
+	var missing []string
+	for _, stock := range stocks {
+		if _, ok := quotes.charts[stock.Ticker]; !ok || stale {
+			missing = append(missing, stock.Ticker)
+		}
+	}
+	for _, ticker := range missing {
+		wait.Add(1)
+		go func(ticker string) {
+			defer wait.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			bars, err := quotes.market.provider.FetchChart(ctx, ticker, ChartPeriods[0])
+			if err == nil {
+				lock.Lock()
+				quotes.charts[ticker] = Closes(bars)
+				lock.Unlock()
 			}
+		}(ticker)
+	}
+	wait.Wait()
+	if stale {
+		quotes.chartsAt = time.Now()
+	}
+
+	for i := range stocks {
+		closes := quotes.charts[stocks[i].Ticker]
+		stocks[i].Intraday = append(append([]float64(nil), closes...), stocks[i].LastTrade.Value)
+		if !stocks[i].LastTrade.Valid {
+			stocks[i].Intraday = stocks[i].Intraday[:len(closes)]
 		}
 	}
+}
 
-	return quotes
+// This function returns the number, or zero when it is missing.
+func (number Number) Float() float64 {
+	if !number.Valid {
+		return 0
+	}
+	return number.Value
 }
 
-// -----------------------------------------------------------------------------
-func sanitize(body []byte) []byte {
-	return bytes.Replace(bytes.TrimSpace(body), []byte{'"'}, []byte{}, -1)
-}
-
-func float2Str(v float64) string {
-	unit := ""
-	switch {
-	case v > 1.0e12:
-		v = v / 1.0e12
-		unit = "T"
-	case v > 1.0e9:
-		v = v / 1.0e9
-		unit = "B"
-	case v > 1.0e6:
-		v = v / 1.0e6
-		unit = "M"
-	case v > 1.0e5:
-		v = v / 1.0e3
-		unit = "K"
-	default:
-		unit = ""
-	}
-	return fmt.Sprintf("%0.3f%s", v, unit)
+// This function encodes the number as a JSON number, or null when it is missing.
+func (number Number) MarshalJSON() ([]byte, error) {
+	if !number.Valid {
+		return []byte(`null`), nil
+	}
+	return json.Marshal(number.Value)
+}
+
+// This function decodes a JSON number, treating null as missing.
+func (number *Number) UnmarshalJSON(data []byte) error {
+	*number = Number{}
+	if string(data) == `null` {
+		return nil
+	}
+	number.Valid = true
+	return json.Unmarshal(data, &number.Value)
+}
+
+// This function encodes the integer as a JSON number, or null when it is missing.
+func (integer Integer) MarshalJSON() ([]byte, error) {
+	if !integer.Valid {
+		return []byte(`null`), nil
+	}
+	return json.Marshal(integer.Value)
+}
+
+// This function decodes a JSON number, treating null as missing.
+func (integer *Integer) UnmarshalJSON(data []byte) error {
+	*integer = Integer{}
+	if string(data) == `null` {
+		return nil
+	}
+	integer.Valid = true
+	return json.Unmarshal(data, &integer.Value)
 }
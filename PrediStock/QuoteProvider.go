@@ -0,0 +1,84 @@
+package mop
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+A `QuoteProvider` is the source of all market data shown by PrediStock. `Quotes` and `Market` only talk to
+the provider selected by `Profile.Provider`, so the vendor specific URLs, headers and authentication live
+entirely inside the provider implementation:
+
+- `FetchQuotes`: returns one `Stock` per requested ticker for the quotes table.
+- `FetchMarket`: returns snapshots of the indexes, yields, currencies and commodities shown in the market header.
+- `FetchChart`: returns the OHLCV bars of one ticker over one of the `ChartPeriods`, intraday bars for the short periods.
+- `FetchFundamentals`: returns the less volatile figures of one ticker shown in the detail pane; fields the vendor does not report are left missing.
+
+Every call gets a context, cancelled when its result is no longer wanted, e.g. because the tickers changed;
+providers send their requests with it through a `WebClient`. Additional vendors (or fakes backed by an
+`httptest` server) are plugged in with `RegisterProvider`. When
+`Profile.StreamURL` is set, the provider is also given the WebSocket stream at that URL, see `QuoteStream`.
+*/
+type QuoteProvider interface {
+	FetchQuotes(ctx context.Context, tickers []string) ([]Stock, error)
+	FetchMarket(ctx context.Context, symbols []string) ([]Stock, error)
+	FetchChart(ctx context.Context, ticker string, period string) ([]Bar, error)
+	FetchFundamentals(ctx context.Context, ticker string) (Fundamentals, error)
+}
+
+// AuthenticatedProvider is a QuoteProvider signing in to its vendor. AuthStatus describes the sign-in while it is failing, for the status line, and is empty otherwise.
+type AuthenticatedProvider interface {
+	QuoteProvider
+	AuthStatus() string
+}
+
+// ChartPeriods lists the periods every provider accepts in FetchChart, shortest first.
+var ChartPeriods = []string{`1d`, `5d`, `1m`, `6m`, `1y`, `5y`}
+
+// ProviderFactory builds a QuoteProvider from the user's profile.
+type ProviderFactory func(profile *Profile) (QuoteProvider, error)
+
+const defaultProvider = `yahoo`
+
+var providers = map[string]ProviderFactory{
+	defaultProvider: func(profile *Profile) (QuoteProvider, error) {
+		client, err := NewWebClient(profile.Proxy, time.Duration(profile.RequestTimeout)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return NewYahooProvider(profile.AuthCachePath(), client), nil
+	},
+}
+
+// This function makes a provider available under the given name so it can be selected through `Profile.Provider`. Registering a name twice replaces the earlier factory.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers[strings.ToLower(name)] = factory
+}
+
+// This function creates the provider named by `Profile.Provider`, falling back to Yahoo Finance when the field is empty. An unknown name is reported together with the list of registered providers. When `Profile.StreamURL` is set, the provider returned is a `StreamingProvider` reading that stream.
+func NewQuoteProvider(profile *Profile) (QuoteProvider, error) {
+	name := strings.ToLower(profile.Provider)
+	if name == `` {
+		name = defaultProvider
+	}
+
+	factory, ok := providers[name]
+	if !ok {
+		names := make([]string, 0, len(providers))
+		for registered := range providers {
+			names = append(names, registered)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown quote provider %q (available: %s)", profile.Provider, strings.Join(names, `, `))
+	}
+
+	provider, err := factory(profile)
+	if err != nil || profile.StreamURL == `` {
+		return provider, err
+	}
+	return &streamingProvider{QuoteProvider: provider, url: profile.StreamURL}, nil
+}
@@ -0,0 +1,193 @@
+package mop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Streaming lets a provider push quote updates instead of having every ticker downloaded again at each refresh.
+A provider that implements `StreamingProvider` opens a `QuoteStream`; `Quotes` subscribes it to the tickers
+of the profile after every full fetch and merges the `QuoteDelta`s it delivers into the stocks, so that the
+screen only redraws the rows that changed. Full fetches still provide the first snapshot, and polling resumes
+whenever the stream closes.
+
+The WebSocket protocol is deliberately small. The client sends `{"subscribe": ["AAPL", "MSFT"]}`, replacing
+the previous subscription, and the server answers with one JSON object per update, holding the ticker and
+the fields of `Stock` that changed under their JSON names, e.g. `{"ticker": "AAPL", "last": 187.2, "volume": 51234567}`.
+*/
+
+// QuoteDelta is a partial update of one stock: the JSON of a Stock with only the fields that changed.
+type QuoteDelta struct {
+	Ticker string
+	Data   json.RawMessage
+}
+
+// QuoteStream delivers quote updates for the subscribed tickers until it is closed or fails, after which the
+// channel returned by Updates is closed.
+type QuoteStream interface {
+	Subscribe(tickers []string) error
+	Updates() <-chan QuoteDelta
+	Close() error
+}
+
+// StreamingProvider is a QuoteProvider that can also push quote updates.
+type StreamingProvider interface {
+	QuoteProvider
+	OpenStream(ctx context.Context) (QuoteStream, error)
+}
+
+// Number of updates buffered by a stream before the connection is read no further.
+const streamBuffer = 256
+
+// WebSocketStream is a QuoteStream reading updates from a WebSocket server.
+type WebSocketStream struct {
+	connection *websocket.Conn
+	updates    chan QuoteDelta
+	done       chan struct{}    // Closed by Close, so that reading stops even when nobody drains the updates.
+	recorder   *SessionRecorder // Receives every update when the session is recorded, nil otherwise.
+	closing    sync.Once
+	mutex      sync.Mutex // Serializes writes to the connection.
+}
+
+// This function connects to the WebSocket server at `url`, e.g. `ws://127.0.0.1:8765/stream`, giving up when the context is done. Nothing is received until Subscribe is called.
+func DialQuoteStream(ctx context.Context, url string) (*WebSocketStream, error) {
+	return dialQuoteStream(ctx, url, nil)
+}
+
+// -----------------------------------------------------------------------------
+func dialQuoteStream(ctx context.Context, url string, recorder *SessionRecorder) (*WebSocketStream, error) {
+	connection, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to quote stream: %v", err)
+	}
+	stream := &WebSocketStream{
+		connection: connection,
+		updates:    make(chan QuoteDelta, streamBuffer),
+		done:       make(chan struct{}),
+		recorder:   recorder,
+	}
+	go stream.read()
+
+	return stream, nil
+}
+
+// This function replaces the subscription of the stream with the given tickers.
+func (stream *WebSocketStream) Subscribe(tickers []string) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	return stream.connection.WriteJSON(map[string][]string{`subscribe`: tickers})
+}
+
+// This function returns the channel of the updates, closed when the connection ends.
+func (stream *WebSocketStream) Updates() <-chan QuoteDelta {
+	return stream.updates
+}
+
+// This function closes the connection, which also closes the channel of the updates.
+func (stream *WebSocketStream) Close() error {
+	stream.closing.Do(func() { close(stream.done) })
+	return stream.connection.Close()
+}
+
+// -----------------------------------------------------------------------------
+func (stream *WebSocketStream) read() {
+	defer close(stream.updates)
+	for {
+		_, data, err := stream.connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		var header struct {
+			Ticker string `json:"ticker"`
+		}
+		if json.Unmarshal(data, &header) != nil || header.Ticker == `` {
+			continue
+		}
+		if stream.recorder != nil {
+			stream.recorder.Record(sessionStream, sessionStream, header.Ticker, data)
+		}
+		select {
+		case stream.updates <- QuoteDelta{Ticker: header.Ticker, Data: data}:
+		case <-stream.done:
+			return
+		}
+	}
+}
+
+// streamingProvider adds the WebSocket stream configured in the profile to another provider.
+type streamingProvider struct {
+	QuoteProvider
+	url      string
+	recorder *SessionRecorder // Receives the updates of the streams opened, see Record.
+}
+
+// -----------------------------------------------------------------------------
+func (provider *streamingProvider) OpenStream(ctx context.Context) (QuoteStream, error) {
+	return dialQuoteStream(ctx, provider.url, provider.recorder)
+}
+
+// This function records the updates of the streams opened from now on, and the responses of the other provider when it can record them, see RecordingProvider.
+func (provider *streamingProvider) Record(recorder *SessionRecorder) {
+	if recording, ok := provider.QuoteProvider.(RecordingProvider); ok {
+		recording.Record(recorder)
+	}
+	provider.recorder = recorder
+}
+
+// -----------------------------------------------------------------------------
+func (provider *streamingProvider) AuthStatus() string {
+	if authenticated, ok := provider.QuoteProvider.(AuthenticatedProvider); ok {
+		return authenticated.AuthStatus()
+	}
+	return ``
+}
+
+// -----------------------------------------------------------------------------
+func (provider *streamingProvider) SearchSymbols(ctx context.Context, query string) ([]SymbolMatch, error) {
+	if searching, ok := provider.QuoteProvider.(SearchingProvider); ok {
+		return searching.SearchSymbols(ctx, query)
+	}
+	return nil, errCannotSearch
+}
+
+// This function applies the delta to the stock. When the delta moves the last trade without reporting the change, the change, its percentage and the direction are recomputed from the previous close.
+func mergeDelta(stock Stock, delta QuoteDelta) (Stock, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(delta.Data, &fields); err != nil {
+		return stock, err
+	}
+	previous := stock
+	if err := json.Unmarshal(delta.Data, &stock); err != nil {
+		return previous, err
+	}
+
+	_, hasLast := fields[`last`]
+	_, hasChange := fields[`change`]
+	if hasLast && !hasChange && previous.LastTrade.Valid && previous.Change.Valid && stock.LastTrade.Valid {
+		previousClose := previous.LastTrade.Value - previous.Change.Value
+		stock.Change = Number{Value: stock.LastTrade.Value - previousClose, Valid: true}
+		if previousClose != 0 {
+			stock.ChangePct = Number{Value: 100 * stock.Change.Value / previousClose, Valid: true}
+		}
+		switch {
+		case stock.Change.Value > 0:
+			stock.Direction = 1
+		case stock.Change.Value < 0:
+			stock.Direction = -1
+		default:
+			stock.Direction = 0
+		}
+	}
+	if hasLast && len(stock.Intraday) > 0 && stock.LastTrade.Valid {
+		stock.Intraday = append([]float64(nil), stock.Intraday...)
+		stock.Intraday[len(stock.Intraday)-1] = stock.LastTrade.Value
+	}
+
+	return stock, nil
+}
@@ -0,0 +1,120 @@
+package mop
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This function plays the frames on a local ReplayServer and connects a WebSocketStream to it.
+func dialReplay(t *testing.T, frames []ReplayFrame) *WebSocketStream {
+	t.Helper()
+	web := httptest.NewServer(NewReplayServer(frames, 1))
+	t.Cleanup(web.Close)
+
+	stream, err := DialQuoteStream(context.Background(), `ws`+strings.TrimPrefix(web.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	return stream
+}
+
+func replayFrame(at time.Duration, ticker string, last float64) ReplayFrame {
+	data, _ := json.Marshal(map[string]interface{}{`ticker`: ticker, `last`: last})
+	return ReplayFrame{At: at, Delta: QuoteDelta{Ticker: ticker, Data: data}}
+}
+
+func TestReplayStream(t *testing.T) {
+	stream := dialReplay(t, []ReplayFrame{
+		replayFrame(0, `MSFT`, 300),
+		replayFrame(0, `AAPL`, 101),
+		replayFrame(10*time.Millisecond, `AAPL`, 102),
+	})
+	if err := stream.Subscribe([]string{`AAPL`}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The frames sent before the subscription arrived are skipped, but the server starts over after the last one.
+	stock := Stock{Ticker: `AAPL`, LastTrade: Number{100, true}, Change: Number{2, true}, Intraday: []float64{99, 100}}
+	seen := map[float64]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 2 {
+		select {
+		case delta, ok := <-stream.Updates():
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if delta.Ticker != `AAPL` {
+				t.Fatalf("got an update of %s, which was not subscribed", delta.Ticker)
+			}
+			merged, err := mergeDelta(stock, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[merged.LastTrade.Value] = true
+			if merged.LastTrade.Value != 101 {
+				continue
+			}
+			// The previous close is 98: the change follows the last trade, which also ends the sparkline.
+			if merged.Change.Value != 3 || math.Abs(merged.ChangePct.Value-300.0/98) > 1e-9 || merged.Direction != 1 {
+				t.Errorf("got change %v (%v%%) and direction %d", merged.Change.Value, merged.ChangePct.Value, merged.Direction)
+			}
+			if len(merged.Intraday) != 2 || merged.Intraday[1] != 101 || stock.Intraday[1] != 100 {
+				t.Errorf("got intraday %v, and %v left in the stock", merged.Intraday, stock.Intraday)
+			}
+		case <-timeout:
+			t.Fatalf("got updates %v only", seen)
+		}
+	}
+}
+
+func TestMergeDelta(t *testing.T) {
+	stock := Stock{Ticker: `AAPL`, LastTrade: Number{100, true}, Change: Number{2, true}, Volume: Integer{10, true}}
+
+	merged, err := mergeDelta(stock, QuoteDelta{`AAPL`, json.RawMessage(`{"ticker":"AAPL","last":97,"change":-1.5,"volume":null}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.LastTrade.Value != 97 || merged.Change.Value != -1.5 || merged.Volume.Valid {
+		t.Errorf("got last %v, change %v and volume %v", merged.LastTrade, merged.Change, merged.Volume)
+	}
+
+	merged, err = mergeDelta(stock, QuoteDelta{`AAPL`, json.RawMessage(`{"ticker":"AAPL","last":"97"}`)})
+	if err == nil || merged.LastTrade.Value != 100 {
+		t.Errorf("got %v and last %v, want an error and the stock unchanged", err, merged.LastTrade)
+	}
+}
+
+func TestCloseUndrainedStream(t *testing.T) {
+	stream := dialReplay(t, []ReplayFrame{replayFrame(0, `AAPL`, 101)})
+	if err := stream.Subscribe([]string{`AAPL`}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stream.Updates()) < streamBuffer {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d updates only", len(stream.Updates()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Reading is blocked on the full buffer: closing must end it, dropping the update it holds, and close the updates.
+	stream.Close()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < streamBuffer; i++ {
+		<-stream.Updates()
+	}
+	select {
+	case _, ok := <-stream.Updates():
+		if ok {
+			t.Error("got an update read after the stream was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates not closed")
+	}
+}
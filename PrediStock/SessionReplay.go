@@ -0,0 +1,342 @@
+package mop
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A session is a recording of the raw responses a provider received, one JSON object per line:
+
+	{"time": "2024-01-02T15:04:05.123-05:00", "provider": "yahoo", "kind": "quotes", "key": "", "body": "{\"quoteResponse\": ...}"}
+
+The `ReplayProvider` plays a session back on a clock that starts at the first response and runs at real time or
+faster. Every call returns the response of its kind recorded last before the current session time, parsed by
+the same code that parsed it live, so `Quotes`, `Market`, the filter, the sorter and the alerts see exactly what
+they saw during the recording. Market snapshots are matched by kind only; quotes also by the tickers of their
+batch (`key`), and charts and fundamentals by ticker. Quotes requested in batches that were not recorded, e.g.
+with another `QuotesBatch` or watchlist, are picked ticker by ticker from the responses recorded so far.
+
+The updates pushed by a quote stream are recorded as well, with the kind `stream`, the ticker as key and the
+`QuoteDelta` as body. When a session holds some, the `ReplayProvider` is also a `StreamingProvider` pushing
+them again at the time they were received, so that the replay updates the rows the way the recording did.
+*/
+
+// Kinds of recorded responses.
+const (
+	sessionQuotes       = `quotes`
+	sessionMarket       = `market`
+	sessionChart        = `chart`
+	sessionFundamentals = `fundamentals`
+	sessionSummary      = `summary`
+	sessionSearch       = `search`
+	sessionStream       = `stream` // Also the provider of the updates, which are parsed by no provider.
+)
+
+// RecordingProvider is a QuoteProvider that can pass the raw responses it receives to a SessionRecorder.
+type RecordingProvider interface {
+	QuoteProvider
+	Record(recorder *SessionRecorder)
+}
+
+// SessionResponse is one recorded response.
+type SessionResponse struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Body     string    `json:"body"`
+}
+
+// SessionRecorder appends the responses of a provider to a session file.
+type SessionRecorder struct {
+	file  *os.File
+	mutex sync.Mutex
+	err   error
+}
+
+// sessionParsers turn the recorded responses of each provider back into what the provider returned for them.
+var sessionParsers = map[string]struct {
+	quotes       func([]byte) ([]Stock, error)
+	chart        func([]byte) ([]Bar, error)
+	fundamentals func([]byte) (Fundamentals, error)
+	beta         func([]byte) Number
+}{
+	`yahoo`: {parseYahooQuotes, parseYahooChart, parseYahooFundamentals, parseYahooBeta},
+}
+
+// This function creates a recorder appending to the session file, which is created when missing.
+func NewSessionRecorder(filename string) (*SessionRecorder, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionRecorder{file: file}, nil
+}
+
+// This function appends a response received now. Errors are kept for Err rather than returned, so that recording never disturbs fetching.
+func (recorder *SessionRecorder) Record(provider, kind, key string, body []byte) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	data, err := json.Marshal(SessionResponse{time.Now(), provider, kind, key, string(body)})
+	if err == nil {
+		_, err = recorder.file.Write(append(data, '\n'))
+	}
+	if err != nil && recorder.err == nil {
+		recorder.err = err
+	}
+}
+
+// This function returns the first error encountered while recording, if any.
+func (recorder *SessionRecorder) Err() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return recorder.err
+}
+
+// This function closes the session file.
+func (recorder *SessionRecorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return recorder.file.Close()
+}
+
+// This function reads a session file. The responses are returned sorted by time; lines that cannot be parsed are reported with their number.
+func LoadSession(filename string) ([]SessionResponse, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var responses []SessionResponse
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var response SessionResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		if _, ok := sessionParsers[response.Provider]; !ok && response.Kind != sessionStream {
+			return nil, fmt.Errorf("%s:%d: responses of provider %q cannot be replayed", filename, line, response.Provider)
+		}
+		responses = append(responses, response)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("%s: no responses recorded", filename)
+	}
+	sort.SliceStable(responses, func(i, j int) bool { return responses[i].Time.Before(responses[j].Time) })
+
+	return responses, nil
+}
+
+// ReplayProvider is a QuoteProvider answering from a recorded session.
+type ReplayProvider struct {
+	responses []SessionResponse
+	speed     float64
+	started   time.Time
+}
+
+// This function creates a provider replaying the responses `speed` times faster than they were recorded. The session clock starts now, at the time of the first response.
+func NewReplayProvider(responses []SessionResponse, speed float64) *ReplayProvider {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &ReplayProvider{responses: responses, speed: speed, started: time.Now()}
+}
+
+// This function returns the time of the session being replayed.
+func (provider *ReplayProvider) Now() time.Time {
+	elapsed := time.Duration(float64(time.Since(provider.started)) * provider.speed)
+	return provider.responses[0].Time.Add(elapsed)
+}
+
+// This function returns the quotes recorded last for the batch of tickers. When the batch was never requested, it returns the last quote recorded up to now of each of the tickers that has one.
+func (provider *ReplayProvider) FetchQuotes(ctx context.Context, tickers []string) ([]Stock, error) {
+	if response, err := provider.find(sessionQuotes, strings.Join(tickers, `,`)); err == nil {
+		return sessionParsers[response.Provider].quotes([]byte(response.Body))
+	}
+
+	latest := make(map[string]Stock, len(tickers))
+	for _, ticker := range tickers {
+		latest[ticker] = Stock{}
+	}
+	now, recorded, missing := provider.Now(), false, len(tickers)
+	for i := len(provider.responses) - 1; i >= 0 && missing > 0; i-- {
+		response := provider.responses[i]
+		if response.Kind != sessionQuotes || response.Time.After(now) {
+			continue
+		}
+		recorded = true
+		stocks, err := sessionParsers[response.Provider].quotes([]byte(response.Body))
+		if err != nil {
+			continue
+		}
+		for _, stock := range stocks {
+			if found, wanted := latest[stock.Ticker]; wanted && found.Ticker == `` {
+				latest[stock.Ticker] = stock
+				missing--
+			}
+		}
+	}
+	if !recorded {
+		return nil, fmt.Errorf("no %s response recorded before %s", sessionQuotes, now.Format(time.RFC3339))
+	}
+
+	stocks := make([]Stock, 0, len(tickers))
+	for _, ticker := range tickers {
+		if stock := latest[ticker]; stock.Ticker != `` {
+			stocks = append(stocks, stock)
+		}
+	}
+	return stocks, nil
+}
+
+// This function returns the market snapshot recorded last.
+func (provider *ReplayProvider) FetchMarket(ctx context.Context, symbols []string) ([]Stock, error) {
+	response, err := provider.find(sessionMarket, ``)
+	if err != nil {
+		return nil, err
+	}
+	return sessionParsers[response.Provider].quotes([]byte(response.Body))
+}
+
+// This function returns the chart of the ticker over the period recorded last.
+func (provider *ReplayProvider) FetchChart(ctx context.Context, ticker string, period string) ([]Bar, error) {
+	response, err := provider.find(sessionChart, ticker+` `+period)
+	if err != nil {
+		return nil, err
+	}
+	return sessionParsers[response.Provider].chart([]byte(response.Body))
+}
+
+// This function returns the fundamentals of the ticker recorded last.
+func (provider *ReplayProvider) FetchFundamentals(ctx context.Context, ticker string) (Fundamentals, error) {
+	response, err := provider.find(sessionFundamentals, ticker)
+	if err != nil {
+		return Fundamentals{}, err
+	}
+	parsers := sessionParsers[response.Provider]
+	fundamentals, err := parsers.fundamentals([]byte(response.Body))
+	if err != nil {
+		return fundamentals, err
+	}
+	if summary, err := provider.find(sessionSummary, ticker); err == nil {
+		fundamentals.Beta = parsers.beta([]byte(summary.Body))
+	}
+
+	return fundamentals, nil
+}
+
+// This function opens a stream pushing the updates recorded after the current session time, each when the session clock reaches it. It fails when there are none, e.g. because the session was recorded without a stream.
+func (provider *ReplayProvider) OpenStream(ctx context.Context) (QuoteStream, error) {
+	now := provider.Now()
+	var updates []SessionResponse
+	for _, response := range provider.responses {
+		if response.Kind == sessionStream && response.Time.After(now) {
+			updates = append(updates, response)
+		}
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("no stream updates recorded after %s", now.Format(time.RFC3339))
+	}
+
+	stream := &replayStream{
+		updates:    make(chan QuoteDelta, streamBuffer),
+		done:       make(chan struct{}),
+		subscribed: make(map[string]bool),
+	}
+	go stream.play(provider, updates)
+
+	return stream, nil
+}
+
+// -----------------------------------------------------------------------------
+func (provider *ReplayProvider) find(kind, key string) (SessionResponse, error) {
+	now := provider.Now()
+	found := -1
+	for i, response := range provider.responses {
+		if response.Time.After(now) && found >= 0 {
+			break
+		}
+		if response.Kind == kind && (key == `` || response.Key == key) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return SessionResponse{}, fmt.Errorf("no %s response recorded for %q", kind, key)
+	}
+	return provider.responses[found], nil
+}
+
+// replayStream is the QuoteStream of a ReplayProvider.
+type replayStream struct {
+	updates    chan QuoteDelta
+	done       chan struct{} // Closed by Close.
+	closing    sync.Once
+	subscribed map[string]bool
+	mutex      sync.Mutex // Guards the subscribed tickers.
+}
+
+// This function replaces the tickers whose updates are pushed.
+func (stream *replayStream) Subscribe(tickers []string) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.subscribed = make(map[string]bool, len(tickers))
+	for _, ticker := range tickers {
+		stream.subscribed[ticker] = true
+	}
+	return nil
+}
+
+// This function returns the channel of the updates, closed after the last one recorded.
+func (stream *replayStream) Updates() <-chan QuoteDelta {
+	return stream.updates
+}
+
+// This function stops pushing updates, which also closes the channel of the updates.
+func (stream *replayStream) Close() error {
+	stream.closing.Do(func() { close(stream.done) })
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+func (stream *replayStream) play(provider *ReplayProvider, updates []SessionResponse) {
+	defer close(stream.updates)
+	for _, update := range updates {
+		wait := time.Duration(float64(update.Time.Sub(provider.Now())) / provider.speed)
+		select {
+		case <-stream.done:
+			return
+		case <-time.After(wait):
+		}
+		stream.mutex.Lock()
+		wanted := stream.subscribed[update.Key]
+		stream.mutex.Unlock()
+		if !wanted {
+			continue
+		}
+		select {
+		case stream.updates <- QuoteDelta{Ticker: update.Key, Data: json.RawMessage(update.Body)}:
+		case <-stream.done:
+			return
+		}
+	}
+}
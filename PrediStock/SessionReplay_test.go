@@ -0,0 +1,65 @@
+package mop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This function returns a recorded Yahoo quotes response of the batch, at the given prices.
+func recordedQuotes(at time.Time, prices ...interface{}) SessionResponse {
+	var tickers, results []string
+	for i := 0; i < len(prices); i += 2 {
+		tickers = append(tickers, prices[i].(string))
+		results = append(results, fmt.Sprintf(`{"symbol":%q,"regularMarketPrice":%v}`, prices[i], prices[i+1]))
+	}
+	body := `{"quoteResponse":{"result":[` + strings.Join(results, `,`) + `]}}`
+	return SessionResponse{Time: at, Provider: `yahoo`, Kind: sessionQuotes, Key: strings.Join(tickers, `,`), Body: body}
+}
+
+func TestReplayQuotes(t *testing.T) {
+	start := time.Date(2024, time.January, 2, 15, 0, 0, 0, time.UTC)
+	provider := NewReplayProvider([]SessionResponse{
+		recordedQuotes(start, `AAPL`, 190, `MSFT`, 420),
+		recordedQuotes(start.Add(time.Minute), `GOOG`, 150),
+		recordedQuotes(start.Add(2*time.Minute), `AAPL`, 191, `MSFT`, 421),
+		recordedQuotes(start.Add(2*time.Hour), `AAPL`, 999, `GOOG`, 999),
+	}, 1)
+	provider.started = time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		tickers []string
+		want    string
+	}{
+		{[]string{`AAPL`, `MSFT`}, `AAPL 191, MSFT 421`},
+		// Batches that were not recorded get the last quote of each of their tickers, in their order, and nothing for the others.
+		{[]string{`MSFT`, `TSLA`, `GOOG`}, `MSFT 421, GOOG 150`},
+		{[]string{`AAPL`}, `AAPL 191`},
+		{[]string{`TSLA`}, ``},
+	}
+	for _, test := range tests {
+		stocks, err := provider.FetchQuotes(context.Background(), test.tickers)
+		if err != nil {
+			t.Errorf("%v: %v", test.tickers, err)
+			continue
+		}
+		var got []string
+		for _, stock := range stocks {
+			got = append(got, fmt.Sprintf(`%s %v`, stock.Ticker, stock.LastTrade.Value))
+		}
+		if strings.Join(got, `, `) != test.want {
+			t.Errorf("%v: got %q, want %q", test.tickers, strings.Join(got, `, `), test.want)
+		}
+	}
+
+	// Before the first quotes of the session, fetching fails rather than marking the tickers unknown.
+	early := NewReplayProvider([]SessionResponse{
+		{Time: start, Provider: `yahoo`, Kind: sessionMarket, Body: `{}`},
+		recordedQuotes(start.Add(time.Hour), `AAPL`, 190),
+	}, 1)
+	if _, err := early.FetchQuotes(context.Background(), []string{`MSFT`}); err == nil {
+		t.Error("got quotes before any was recorded")
+	}
+}
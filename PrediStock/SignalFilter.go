@@ -1,8 +1,10 @@
 package mop
 
 import (
-	"strconv"
+	"fmt"
 	"strings"
+
+	"github.com/Knetic/govaluate"
 )
 
 type Filter struct {
@@ -14,40 +16,11 @@ func NewFilter(profile *Profile) *Filter {
 		profile: profile,
 	}
 }
-func stringToNumber(numberString string) float64 {
-	newString := strings.TrimSpace(numberString)
-	newString = strings.Replace(newString, "$", "", 1)
-	newString = strings.Replace(newString, "%", "", 1)
-	newString = strings.Replace(newString, "K", "E+3", 1)
-	newString = strings.Replace(newString, "M", "E+6", 1)
-	newString = strings.Replace(newString, "B", "E+9", 1)
-	newString = strings.Replace(newString, "T", "E+12", 1)
-	finalValue, _ := strconv.ParseFloat(newString, 64)
-	return finalValue
-}
 func (filter *Filter) Apply(stocks []Stock) []Stock {
 	var filteredStocks []Stock
 
 	for _, stock := range stocks {
-		var values = make(map[string]interface{})
-		values["ticker"] = strings.TrimSpace(stock.Ticker)
-		values["last"] = stringToNumber(stock.LastTrade)
-		values["change"] = stringToNumber(stock.Change)
-		values["changePercent"] = stringToNumber(stock.ChangePct)
-		values["open"] = stringToNumber(stock.Open)
-		values["low"] = stringToNumber(stock.Low)
-		values["high"] = stringToNumber(stock.High)
-		values["low52"] = stringToNumber(stock.Low52)
-		values["high52"] = stringToNumber(stock.High52)
-		values["dividend"] = stringToNumber(stock.Dividend)
-		values["yield"] = stringToNumber(stock.Yield)
-		values["mktCap"] = stringToNumber(stock.MarketCap)
-		values["mktCapX"] = stringToNumber(stock.MarketCapX)
-		values["volume"] = stringToNumber(stock.Volume)
-		values["avgVolume"] = stringToNumber(stock.AvgVolume)
-		values["pe"] = stringToNumber(stock.PeRatio)
-		values["peX"] = stringToNumber(stock.PeRatioX)
-		values["direction"] = stock.Direction
+		values := stockValues(stock)
 
 		result, err := filter.profile.filterExpression.Evaluate(values)
 
@@ -70,3 +43,53 @@ func (filter *Filter) Apply(stocks []Stock) []Stock {
 
 	return filteredStocks
 }
+
+// This function returns the stocks for which the filter expression is true. Unlike Apply it leaves the filter of the profile alone and reports invalid expressions.
+func MatchFilter(expression string, stocks []Stock) ([]Stock, error) {
+	evaluable, err := govaluate.NewEvaluableExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []Stock{}
+	for _, stock := range stocks {
+		result, err := evaluable.Evaluate(stockValues(stock))
+		if err != nil {
+			return nil, err
+		}
+		truthy, ok := result.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expression %q is not a condition", expression)
+		}
+		if truthy {
+			matched = append(matched, stock)
+		}
+	}
+
+	return matched, nil
+}
+
+// This function returns the variables available to filter and alert expressions for the stock.
+func stockValues(stock Stock) map[string]interface{} {
+	var values = make(map[string]interface{})
+	values["ticker"] = strings.TrimSpace(stock.Ticker)
+	values["last"] = stock.LastTrade.Float()
+	values["change"] = stock.Change.Float()
+	values["changePercent"] = stock.ChangePct.Float()
+	values["open"] = stock.Open.Float()
+	values["low"] = stock.Low.Float()
+	values["high"] = stock.High.Float()
+	values["low52"] = stock.Low52.Float()
+	values["high52"] = stock.High52.Float()
+	values["dividend"] = stock.Dividend.Float()
+	values["yield"] = stock.Yield.Float()
+	values["mktCap"] = stock.MarketCap.Float()
+	values["mktCapX"] = stock.MarketCapX.Float()
+	values["volume"] = float64(stock.Volume.Value)
+	values["avgVolume"] = float64(stock.AvgVolume.Value)
+	values["pe"] = stock.PeRatio.Float()
+	values["peX"] = stock.PeRatioX.Float()
+	values["direction"] = stock.Direction
+
+	return values
+}
@@ -0,0 +1,184 @@
+package mop
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+The `ReplayServer` is a local WebSocket server speaking the protocol of `WebSocketStream`. It plays a list of
+`ReplayFrame`s to every client, at real time or faster, sending each client only the tickers it subscribed to,
+and starts over once the last frame was sent. The frames usually come from a day recorded in the tick store,
+which makes it a stand-in for a vendor stream in tests and demos.
+*/
+
+// ReplayFrame is one update played back by the ReplayServer, At after the start of the session.
+type ReplayFrame struct {
+	At    time.Duration
+	Delta QuoteDelta
+}
+
+type ReplayServer struct {
+	frames   []ReplayFrame
+	speed    float64
+	upgrader websocket.Upgrader
+}
+
+// This function creates a server playing the frames `speed` times faster than they were recorded. A speed of 0 or less plays them at real time.
+func NewReplayServer(frames []ReplayFrame, speed float64) *ReplayServer {
+	if speed <= 0 {
+		speed = 1
+	}
+	frames = append([]ReplayFrame(nil), frames...)
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].At < frames[j].At })
+
+	return &ReplayServer{frames: frames, speed: speed}
+}
+
+// This function implements `http.Handler`: it upgrades the request to a WebSocket and plays the frames until the client disconnects.
+func (server *ReplayServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	connection, err := server.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+
+	var mutex sync.Mutex
+	subscribed := make(map[string]bool)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var message struct {
+				Subscribe []string `json:"subscribe"`
+			}
+			if err := connection.ReadJSON(&message); err != nil {
+				return
+			}
+			mutex.Lock()
+			subscribed = make(map[string]bool, len(message.Subscribe))
+			for _, ticker := range message.Subscribe {
+				subscribed[ticker] = true
+			}
+			mutex.Unlock()
+		}
+	}()
+
+	if len(server.frames) == 0 {
+		<-done
+		return
+	}
+	for {
+		start := time.Now()
+		for _, frame := range server.frames {
+			wait := time.Until(start.Add(time.Duration(float64(frame.At) / server.speed)))
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+			mutex.Lock()
+			wanted := subscribed[frame.Delta.Ticker]
+			mutex.Unlock()
+			if wanted && connection.WriteMessage(websocket.TextMessage, frame.Delta.Data) != nil {
+				return
+			}
+		}
+	}
+}
+
+// This function returns the quotes recorded on the given day, 2006-01-02, as frames for the ReplayServer: the ticks of the day, or the 1-minute bars of the tickers whose ticks were already compacted.
+func (store *TickStore) ReplayFrames(day string) ([]ReplayFrame, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	type update struct {
+		ticker string
+		time   time.Time
+		price  float64
+		volume int64
+	}
+	var updates []update
+
+	ticked := make(map[string]bool)
+	files, _ := filepath.Glob(filepath.Join(store.dir, `ticks`, `*`, day+`.csv`))
+	for _, filename := range files {
+		ticker := filepath.Base(filepath.Dir(filename))
+		ticks, err := readTicks(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, current := range ticks {
+			updates = append(updates, update{ticker, current.time, current.price, current.volume})
+		}
+		ticked[ticker] = true
+	}
+
+	files, _ = filepath.Glob(filepath.Join(store.dir, `1m`, `*`, day+`.csv`))
+	for _, filename := range files {
+		ticker := filepath.Base(filepath.Dir(filename))
+		if ticked[ticker] {
+			continue
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		bars, err := ReadBars(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		volume := int64(0)
+		for _, bar := range bars {
+			// Bar times are the local wall clock expressed in UTC, see rollTicks.
+			at := time.Date(bar.Time.Year(), bar.Time.Month(), bar.Time.Day(), bar.Time.Hour(), bar.Time.Minute(), 0, 0, time.Local)
+			volume += bar.Volume
+			updates = append(updates, update{ticker, at, bar.Close, volume})
+		}
+	}
+	if len(updates) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	sort.SliceStable(updates, func(i, j int) bool { return updates[i].time.Before(updates[j].time) })
+	frames := make([]ReplayFrame, 0, len(updates))
+	for _, current := range updates {
+		data, err := json.Marshal(map[string]interface{}{`ticker`: current.ticker, `last`: current.price, `volume`: current.volume})
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, ReplayFrame{
+			At:    current.time.Sub(updates[0].time),
+			Delta: QuoteDelta{Ticker: current.ticker, Data: data},
+		})
+	}
+
+	return frames, nil
+}
+
+// This function returns the days recorded in the tick store, as ticks or 1-minute bars, oldest first.
+func (store *TickStore) Days() []string {
+	seen := make(map[string]bool)
+	for _, kind := range []string{`ticks`, `1m`} {
+		files, _ := filepath.Glob(filepath.Join(store.dir, kind, `*`, `*.csv`))
+		for _, filename := range files {
+			seen[strings.TrimSuffix(filepath.Base(filename), `.csv`)] = true
+		}
+	}
+	days := make([]string, 0, len(seen))
+	for day := range seen {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	return days
+}
@@ -1,6 +1,7 @@
 package mop
 
 import (
+	"bytes"
 	"regexp"
 	"strings"
 
@@ -48,6 +49,7 @@ func NewMarkup(profile *Profile) *Markup {
 	markup.tags[`header`] = markup.tags[profile.Colors.Header]
 	markup.tags[`time`] = markup.tags[profile.Colors.Time]
 	markup.tags[`default`] = markup.tags[profile.Colors.Default]
+	markup.tags[`stale`] = markup.tags[profile.Colors.Stale]
 
 	markup.Foreground = markup.tags[profile.Colors.Default]
 
@@ -80,6 +82,32 @@ func (markup *Markup) Tokenize(str string) []string {
 
 	return strings
 }
+// This function renders markup for output outside of termbox, e.g. when printing to stdout. Every line starts in the default color; tags are turned into ANSI escape sequences when `colors` is true and dropped otherwise.
+func (markup *Markup) Render(str string, colors bool) string {
+	buffer := new(bytes.Buffer)
+
+	for i, line := range strings.Split(str, "\n") {
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		markup.Foreground = markup.tags[`default`]
+		for _, token := range markup.Tokenize(line) {
+			if markup.IsTag(token) {
+				continue
+			}
+			if colors {
+				buffer.WriteString(ansiSequence(markup.Foreground))
+			}
+			buffer.WriteString(token)
+		}
+		if colors {
+			buffer.WriteString("\x1b[0m")
+		}
+	}
+	markup.RightAligned = false
+
+	return buffer.String()
+}
 func (markup *Markup) IsTag(str string) bool {
 	tag, open := probeForTag(str)
 
@@ -125,6 +153,44 @@ func (markup *Markup) supportedTags() *regexp.Regexp {
 	return regexp.MustCompile(strings.Join(arr, `|`))
 }
 
+// -----------------------------------------------------------------------------
+var ansiColors = map[termbox.Attribute]string{
+	termbox.ColorBlack:        `30`,
+	termbox.ColorRed:          `31`,
+	termbox.ColorGreen:        `32`,
+	termbox.ColorYellow:       `33`,
+	termbox.ColorBlue:         `34`,
+	termbox.ColorMagenta:      `35`,
+	termbox.ColorCyan:         `36`,
+	termbox.ColorWhite:        `97`,
+	termbox.ColorDarkGray:     `90`,
+	termbox.ColorLightRed:     `91`,
+	termbox.ColorLightGreen:   `92`,
+	termbox.ColorLightYellow:  `93`,
+	termbox.ColorLightBlue:    `94`,
+	termbox.ColorLightMagenta: `95`,
+	termbox.ColorLightCyan:    `96`,
+	termbox.ColorLightGray:    `37`,
+}
+
+func ansiSequence(attribute termbox.Attribute) string {
+	codes := []string{`0`}
+	if attribute&termbox.AttrBold != 0 {
+		codes = append(codes, `1`)
+	}
+	if attribute&termbox.AttrUnderline != 0 {
+		codes = append(codes, `4`)
+	}
+	if attribute&termbox.AttrReverse != 0 {
+		codes = append(codes, `7`)
+	}
+	if color, ok := ansiColors[attribute&(termbox.AttrBold-1)]; ok {
+		codes = append(codes, color)
+	}
+
+	return "\x1b[" + strings.Join(codes, `;`) + `m`
+}
+
 // -----------------------------------------------------------------------------
 func probeForTag(str string) (string, bool) {
 	if len(str) > 2 && str[0:1] == `<` && str[len(str)-1:] == `>` {
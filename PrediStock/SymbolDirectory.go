@@ -0,0 +1,162 @@
+package mop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Tickers typed at the `+` prompt are looked up before they are added to the profile, so that typos do not stay
+there for good as empty rows:
+
+- A provider implementing `SearchingProvider` is asked for the symbols matching what is being typed.
+- Every symbol it returns, and every ticker a quote was received for, is kept in the `SymbolDirectory`, a
+  cache file next to the profile, which answers on its own while the provider cannot be reached.
+*/
+
+// Number of matches returned by a search.
+const symbolMatches = 8
+
+// errCannotSearch is returned by providers wrapping one that does not implement SearchingProvider.
+var errCannotSearch = errors.New("provider cannot search symbols")
+
+// SymbolMatch is a symbol found by a search, with the name and exchange shown next to it.
+type SymbolMatch struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name,omitempty"`
+	Exchange string `json:"exchange,omitempty"`
+	Type     string `json:"type,omitempty"` // Kind of security, e.g. Equity, ETF or Index.
+}
+
+// SearchingProvider is a QuoteProvider that can look symbols up by ticker or name.
+type SearchingProvider interface {
+	QuoteProvider
+	SearchSymbols(ctx context.Context, query string) ([]SymbolMatch, error)
+}
+
+// SymbolDirectory caches the symbols known to exist, see SymbolDirectory.go.
+type SymbolDirectory struct {
+	filename string                 // Cache file, none when empty.
+	symbols  map[string]SymbolMatch // Known symbols by upper case ticker.
+	mutex    sync.Mutex             // Guards the symbols, which searches running in the background add to.
+}
+
+// This function creates a directory cached in the given file, reading the symbols it holds. An empty file name disables the cache.
+func NewSymbolDirectory(filename string) *SymbolDirectory {
+	directory := &SymbolDirectory{filename: filename, symbols: make(map[string]SymbolMatch)}
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		var symbols []SymbolMatch
+		if json.Unmarshal(data, &symbols) == nil {
+			for _, symbol := range symbols {
+				directory.symbols[strings.ToUpper(symbol.Symbol)] = symbol
+			}
+		}
+	}
+	return directory
+}
+
+// This function returns the symbol when it is known to exist.
+func (directory *SymbolDirectory) Lookup(ticker string) (SymbolMatch, bool) {
+	directory.mutex.Lock()
+	defer directory.mutex.Unlock()
+
+	symbol, ok := directory.symbols[strings.ToUpper(ticker)]
+	return symbol, ok
+}
+
+// This function returns the known symbols matching the query: those starting with it first, then those whose name contains it.
+func (directory *SymbolDirectory) Match(query string) []SymbolMatch {
+	directory.mutex.Lock()
+	defer directory.mutex.Unlock()
+
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if query == `` {
+		return nil
+	}
+	var prefixed, named []SymbolMatch
+	for ticker, symbol := range directory.symbols {
+		if strings.HasPrefix(ticker, query) {
+			prefixed = append(prefixed, symbol)
+		} else if strings.Contains(strings.ToUpper(symbol.Name), query) {
+			named = append(named, symbol)
+		}
+	}
+	for _, matches := range [][]SymbolMatch{prefixed, named} {
+		sort.Slice(matches, func(i, j int) bool {
+			if len(matches[i].Symbol) != len(matches[j].Symbol) {
+				return len(matches[i].Symbol) < len(matches[j].Symbol)
+			}
+			return matches[i].Symbol < matches[j].Symbol
+		})
+	}
+
+	matches := append(prefixed, named...)
+	if len(matches) > symbolMatches {
+		matches = matches[:symbolMatches]
+	}
+	return matches
+}
+
+// This function searches the provider for the symbols matching the query and adds them to the directory. When the provider cannot search or fails, the known symbols are returned along with the error.
+func (directory *SymbolDirectory) Search(ctx context.Context, provider QuoteProvider, query string) ([]SymbolMatch, error) {
+	searching, ok := provider.(SearchingProvider)
+	if !ok {
+		return directory.Match(query), nil
+	}
+	matches, err := searching.SearchSymbols(ctx, query)
+	if err == errCannotSearch {
+		return directory.Match(query), nil
+	}
+	if err != nil {
+		return directory.Match(query), err
+	}
+	directory.Add(matches...)
+
+	return matches, nil
+}
+
+// This function adds symbols to the directory, keeping the names already known of those returned without one, and saves it when it changed.
+func (directory *SymbolDirectory) Add(symbols ...SymbolMatch) {
+	directory.mutex.Lock()
+	defer directory.mutex.Unlock()
+
+	changed := false
+	for _, symbol := range symbols {
+		ticker := strings.ToUpper(symbol.Symbol)
+		known, ok := directory.symbols[ticker]
+		if ticker == `` || (ok && (symbol.Name == `` || symbol == known)) {
+			continue
+		}
+		directory.symbols[ticker], changed = symbol, true
+	}
+	if changed {
+		directory.save()
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (directory *SymbolDirectory) save() {
+	if directory.filename == `` {
+		return
+	}
+	symbols := make([]SymbolMatch, 0, len(directory.symbols))
+	for _, symbol := range directory.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Symbol < symbols[j].Symbol })
+
+	data, err := json.MarshalIndent(symbols, ``, `  `)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(directory.filename), 0755) == nil {
+		ioutil.WriteFile(directory.filename, data, 0644)
+	}
+}
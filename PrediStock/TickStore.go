@@ -0,0 +1,317 @@
+package mop
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The tick store keeps every quote snapshot PrediStock fetches so that charts, predictions and backtests can be
+built from what the tool has already seen. Its directory has the following layout:
+
+	ticks/AAPL/2024-01-02.csv   append-only intraday ticks, one file per ticker and day: unix time,price,volume
+	1m/AAPL/2024-01-02.csv      1-minute OHLCV bars built from the ticks of that day
+	daily/AAPL.csv              daily OHLCV bars, directly usable by the predict and backtest commands
+
+Ticks are rolled into bars by `Compact`, which runs automatically for the previous days when the first snapshot
+of a new day is recorded. Volume in the tick files is the cumulative day volume reported by the provider.
+*/
+type TickStore struct {
+	dir   string
+	mutex sync.Mutex
+	last  map[string]tick
+	day   string
+	err   error
+}
+
+type tick struct {
+	time   time.Time
+	price  float64
+	volume int64
+}
+
+const storeDayLayout = `2006-01-02`
+
+// This function creates a store rooted at `dir`. Directories are created lazily when the first tick is appended.
+func NewTickStore(dir string) *TickStore {
+	return &TickStore{
+		dir:  dir,
+		last: make(map[string]tick),
+	}
+}
+
+// This function appends the snapshot of every stock to its tick file for the day of `at`. Stocks without a price and snapshots identical to the previous one for the same ticker are skipped. When the day changes, the tick files of the previous days are compacted first, and again with every snapshot until compacting them succeeds.
+func (store *TickStore) Append(at time.Time, stocks []Stock) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var compactErr error
+	day := at.Format(storeDayLayout)
+	if day != store.day {
+		if _, compactErr = store.compact(at); compactErr == nil {
+			store.day = day
+		}
+	}
+
+	for _, stock := range stocks {
+		if !stock.LastTrade.Valid {
+			continue
+		}
+		current := tick{time: at, price: stock.LastTrade.Value, volume: stock.Volume.Value}
+		if last, ok := store.last[stock.Ticker]; ok && last.price == current.price && last.volume == current.volume {
+			continue
+		}
+		if err := store.appendTick(stock.Ticker, day, current); err != nil {
+			store.err = err
+			return err
+		}
+		store.last[stock.Ticker] = current
+	}
+	store.err = compactErr
+
+	return nil
+}
+
+// This function rolls the tick files of every day before `before` into 1-minute and daily bars and removes them. It returns the number of tick files compacted.
+func (store *TickStore) Compact(before time.Time) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.compact(before)
+}
+
+// This function returns the error of the last snapshot recorded, nil when it was recorded and the previous days compacted.
+func (store *TickStore) Err() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.err
+}
+
+// This function returns the path of the daily bars file of the ticker.
+func (store *TickStore) DailyPath(ticker string) string {
+	return filepath.Join(store.dir, `daily`, storeName(ticker)+`.csv`)
+}
+
+// -----------------------------------------------------------------------------
+func (store *TickStore) appendTick(ticker, day string, current tick) error {
+	dir := filepath.Join(store.dir, `ticks`, storeName(ticker))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, day+`.csv`), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%d,%s,%d\n", current.time.Unix(), formatPrice(current.price), current.volume)
+	return err
+}
+
+// -----------------------------------------------------------------------------
+func (store *TickStore) compact(before time.Time) (int, error) {
+	cutoff := before.Format(storeDayLayout)
+	files, err := filepath.Glob(filepath.Join(store.dir, `ticks`, `*`, `*.csv`))
+	if err != nil {
+		return 0, err
+	}
+
+	compacted := 0
+	for _, filename := range files {
+		day := strings.TrimSuffix(filepath.Base(filename), `.csv`)
+		if day >= cutoff {
+			continue
+		}
+		ticker := filepath.Base(filepath.Dir(filename))
+		if err := store.compactFile(ticker, day, filename); err != nil {
+			return compacted, fmt.Errorf("compacting %s: %v", filename, err)
+		}
+		compacted++
+	}
+
+	return compacted, nil
+}
+
+// -----------------------------------------------------------------------------
+func (store *TickStore) compactFile(ticker, day, filename string) error {
+	ticks, err := readTicks(filename)
+	if err != nil {
+		return err
+	}
+	if len(ticks) > 0 {
+		daily := filepath.Join(store.dir, `daily`, ticker+`.csv`)
+		days, err := readBarsFile(daily)
+		if err != nil {
+			return err
+		}
+		// Ticks recorded after the day was compacted once, e.g. by `store compact -today`, continue from the cumulative volume reached then.
+		counted := ticks[0].volume
+		today := rollTicks(ticks, true, 0)
+		for _, bar := range days {
+			if bar.Time.Equal(today[0].Time) && bar.Volume < counted {
+				counted = bar.Volume
+			}
+		}
+
+		minutes := filepath.Join(store.dir, `1m`, ticker, day+`.csv`)
+		if err := mergeBarsFile(minutes, rollTicks(ticks, false, counted), false); err != nil {
+			return err
+		}
+		if err := mergeBarsFile(daily, today, true); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(filename)
+}
+
+// -----------------------------------------------------------------------------
+func readTicks(filename string) ([]tick, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ticks []tick
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), `,`)
+		if len(fields) != 3 {
+			continue
+		}
+		seconds, err1 := strconv.ParseInt(fields[0], 10, 64)
+		price, err2 := strconv.ParseFloat(fields[1], 64)
+		volume, err3 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		ticks = append(ticks, tick{time: time.Unix(seconds, 0), price: price, volume: volume})
+	}
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].time.Before(ticks[j].time) })
+
+	return ticks, scanner.Err()
+}
+
+// This function groups ticks into 1-minute bars, or into one bar per day when `daily` is set. Bar times are the local wall clock
+// expressed in UTC, which is what `ReadBars` yields for the timestamps `WriteBars` writes. Minute volume is the growth of the
+// cumulative volume during the minute from the `counted` volume on, daily volume the largest cumulative volume seen.
+func rollTicks(ticks []tick, daily bool, counted int64) []Bar {
+	var bars []Bar
+	previousVolume := counted
+
+	for _, current := range ticks {
+		local := current.time.Local()
+		start := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC)
+		if daily {
+			start = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		}
+
+		if len(bars) == 0 || !bars[len(bars)-1].Time.Equal(start) {
+			bars = append(bars, Bar{Time: start, Open: current.price, High: current.price, Low: current.price})
+		}
+		bar := &bars[len(bars)-1]
+		if current.price > bar.High {
+			bar.High = current.price
+		}
+		if current.price < bar.Low {
+			bar.Low = current.price
+		}
+		bar.Close = current.price
+
+		if daily {
+			if current.volume > bar.Volume {
+				bar.Volume = current.volume
+			}
+		} else if current.volume > previousVolume {
+			bar.Volume += current.volume - previousVolume
+		}
+		previousVolume = current.volume
+	}
+
+	return bars
+}
+
+// -----------------------------------------------------------------------------
+func mergeBarsFile(filename string, fresh []Bar, daily bool) error {
+	existing, err := readBarsFile(filename)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[int64]Bar)
+	for _, bar := range existing {
+		merged[bar.Time.Unix()] = bar
+	}
+	for _, bar := range fresh {
+		if earlier, ok := merged[bar.Time.Unix()]; ok {
+			bar.Open = earlier.Open
+			if earlier.High > bar.High {
+				bar.High = earlier.High
+			}
+			if earlier.Low < bar.Low {
+				bar.Low = earlier.Low
+			}
+			if !daily {
+				bar.Volume += earlier.Volume
+			} else if earlier.Volume > bar.Volume {
+				bar.Volume = earlier.Volume
+			}
+		}
+		merged[bar.Time.Unix()] = bar
+	}
+
+	bars := make([]Bar, 0, len(merged))
+	for _, bar := range merged {
+		bars = append(bars, bar)
+	}
+	sort.Slice(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	temporary, err := ioutil.TempFile(filepath.Dir(filename), `.compact-*`)
+	if err != nil {
+		return err
+	}
+	if err := WriteBars(temporary, bars); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+
+	return os.Rename(temporary.Name(), filename)
+}
+
+// This function reads the bars of a file, none when it does not exist.
+func readBarsFile(filename string) ([]Bar, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadBars(file)
+}
+
+// -----------------------------------------------------------------------------
+func storeName(ticker string) string {
+	return strings.NewReplacer(`/`, `_`, `\`, `_`, `:`, `_`).Replace(ticker)
+}
@@ -0,0 +1,86 @@
+package mop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// This function returns a snapshot of AAPL.
+func tickOf(price float64, volume int64) []Stock {
+	return []Stock{{Ticker: `AAPL`, LastTrade: Number{price, true}, Volume: Integer{volume, true}}}
+}
+
+func TestCompactInChunks(t *testing.T) {
+	store := NewTickStore(t.TempDir())
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2024, time.January, 2, hour, minute, second, 0, time.Local)
+	}
+
+	// The day is compacted after the second tick, as `store compact -today` does, then once the day is over.
+	store.Append(at(9, 59, 30), tickOf(10, 100))
+	store.Append(at(10, 0, 10), tickOf(11, 150))
+	if _, err := store.Compact(at(23, 0, 0).AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	store.Append(at(10, 0, 40), tickOf(12, 200))
+	store.Append(at(10, 1, 10), tickOf(11, 260))
+	if _, err := store.Compact(at(23, 0, 0).AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	minute := func(hour, minute int) time.Time {
+		return time.Date(2024, time.January, 2, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		file string
+		want []Bar
+	}{
+		// The minute where the chunks meet has the volume traded in both.
+		{filepath.Join(`1m`, `AAPL`, `2024-01-02.csv`), []Bar{
+			{Time: minute(9, 59), Open: 10, High: 10, Low: 10, Close: 10},
+			{Time: minute(10, 0), Open: 11, High: 12, Low: 11, Close: 12, Volume: 100},
+			{Time: minute(10, 1), Open: 11, High: 11, Low: 11, Close: 11, Volume: 60},
+		}},
+		{filepath.Join(`daily`, `AAPL.csv`), []Bar{
+			{Time: minute(0, 0), Open: 10, High: 12, Low: 10, Close: 11, Volume: 260},
+		}},
+	}
+	for _, test := range tests {
+		bars, err := readBarsFile(filepath.Join(store.dir, test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bars) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.file, bars, test.want)
+			continue
+		}
+		for i, want := range test.want {
+			got := bars[i]
+			if !got.Time.Equal(want.Time) || got.Open != want.Open || got.High != want.High || got.Low != want.Low || got.Close != want.Close || got.Volume != want.Volume {
+				t.Errorf("%s: bar %d is %+v, want %+v", test.file, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestAppendClearsError(t *testing.T) {
+	dir := t.TempDir()
+	store := NewTickStore(dir)
+	at := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+
+	// A file in the way of the tick directories fails the append until it is removed.
+	blocker := filepath.Join(dir, `ticks`)
+	if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(at, tickOf(10, 100)); err == nil || store.Err() == nil {
+		t.Fatalf("got %v and %v, want the append to fail", err, store.Err())
+	}
+	os.Remove(blocker)
+	if err := store.Append(at.Add(time.Minute), tickOf(11, 150)); err != nil || store.Err() != nil {
+		t.Errorf("got %v and %v, want the error cleared", err, store.Err())
+	}
+}
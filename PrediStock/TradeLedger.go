@@ -0,0 +1,426 @@
+package mop
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+The ledger records every transaction as a row of a CSV file kept next to the profile:
+
+	Date,Type,Ticker,Quantity,Price,Fees,Lot
+	2023-03-01,buy,AAPL,10,150.25,1,
+	2023-06-15,dividend,AAPL,,2.40,,
+	2024-02-01,split,AAPL,4,,,
+	2024-05-02,sell,AAPL,20,45.10,1,2023-03-01#1
+
+- `buy` and `sell`: quantity shares at price per share. Buys open a lot named by the Lot column, or by their
+  date and position in the file when it is empty; a sell with a Lot only closes that lot.
+- `dividend`: price is the cash received.
+- `split`: quantity is the number of new shares per old share, e.g. 4 for a 4:1 split or 0.5 for 1:2.
+
+Replaying the ledger with FIFO or LIFO matching yields the realised gain of every sell and the lots still open,
+which are merged into the holdings shown in the quotes table.
+*/
+
+// Transaction is a row of the ledger.
+type Transaction struct {
+	Date     time.Time
+	Type     string
+	Ticker   string
+	Quantity float64
+	Price    float64
+	Fees     float64
+	Lot      string
+}
+
+// RealizedGain is the part of a sell matched against one lot. Amounts are in the currency of the transactions.
+type RealizedGain struct {
+	Ticker   string    `json:"ticker"`
+	Lot      string    `json:"lot"`
+	Opened   time.Time `json:"opened"`
+	Closed   time.Time `json:"closed"`
+	Quantity float64   `json:"quantity"`
+	Cost     float64   `json:"cost"`
+	Proceeds float64   `json:"proceeds"`
+	Gain     float64   `json:"gain"`
+}
+
+// LedgerResult is the outcome of replaying a ledger.
+type LedgerResult struct {
+	Gains     []RealizedGain
+	Dividends []Transaction
+	Open      Holdings // Lots still held per ticker, oldest first.
+}
+
+// Matching methods accepted by ReplayLedger. Sells that name a lot are matched against it with either method.
+var LedgerMethods = []string{`fifo`, `lifo`, `specific`}
+
+var ledgerHeader = []string{`Date`, `Type`, `Ticker`, `Quantity`, `Price`, `Fees`, `Lot`}
+
+// This function reads the transactions of a ledger in CSV format. The transactions are returned sorted by date, rows of the same date in file order.
+func ReadLedger(reader io.Reader) ([]Transaction, error) {
+	rows := csv.NewReader(reader)
+	rows.FieldsPerRecord = -1
+	rows.TrimLeadingSpace = true
+
+	var transactions []Transaction
+	for line := 1; ; line++ {
+		record, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && len(record) > 0 && strings.EqualFold(record[0], ledgerHeader[0]) {
+			continue
+		}
+
+		transaction, err := parseTransaction(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].Date.Before(transactions[j].Date) })
+
+	return transactions, nil
+}
+
+// This function loads the ledger file. A missing file is an empty ledger.
+func LoadLedger(filename string) ([]Transaction, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	transactions, err := ReadLedger(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return transactions, nil
+}
+
+// This function appends a transaction to the ledger file, creating the file with its header when it does not exist.
+func AppendTransaction(filename string, transaction Transaction) error {
+	if err := validateTransaction(transaction); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	_, statErr := os.Stat(filename)
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		out.Write(ledgerHeader)
+	}
+	number := func(value float64) string {
+		if value == 0 {
+			return ``
+		}
+		return formatPrice(value)
+	}
+	out.Write([]string{
+		transaction.Date.Format(storeDayLayout),
+		transaction.Type,
+		transaction.Ticker,
+		number(transaction.Quantity),
+		number(transaction.Price),
+		number(transaction.Fees),
+		transaction.Lot,
+	})
+	out.Flush()
+
+	return out.Error()
+}
+
+// This function replays the transactions in order and matches every sell against the open lots of its ticker with the given method. Selling more shares than are held is an error.
+func ReplayLedger(transactions []Transaction, method string) (*LedgerResult, error) {
+	method = strings.ToLower(method)
+	if method != `fifo` && method != `lifo` && method != `specific` {
+		return nil, fmt.Errorf("unknown matching method %q (available: %s)", method, strings.Join(LedgerMethods, `, `))
+	}
+
+	result := &LedgerResult{Open: make(Holdings)}
+	counts := make(map[string]int)
+	for _, transaction := range transactions {
+		ticker := transaction.Ticker
+		switch transaction.Type {
+		case `buy`:
+			counts[transaction.Date.Format(storeDayLayout)]++
+			id := transaction.Lot
+			if id == `` {
+				id = fmt.Sprintf(`%s#%d`, transaction.Date.Format(storeDayLayout), counts[transaction.Date.Format(storeDayLayout)])
+			}
+			result.Open[ticker] = append(result.Open[ticker], Lot{
+				ID:       id,
+				Quantity: transaction.Quantity,
+				Price:    transaction.Price,
+				Date:     transaction.Date.Format(storeDayLayout),
+				Fees:     transaction.Fees,
+			})
+		case `sell`:
+			gains, lots, err := matchSell(result.Open[ticker], transaction, method)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", transaction.Date.Format(storeDayLayout), ticker, err)
+			}
+			result.Gains = append(result.Gains, gains...)
+			if len(lots) == 0 {
+				delete(result.Open, ticker)
+			} else {
+				result.Open[ticker] = lots
+			}
+		case `dividend`:
+			result.Dividends = append(result.Dividends, transaction)
+		case `split`:
+			for i := range result.Open[ticker] {
+				result.Open[ticker][i].Quantity *= transaction.Quantity
+				result.Open[ticker][i].Price /= transaction.Quantity
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// This function renders the realised gains and dividends per year and ticker using the markup tags understood by `Markup`. Only the given year is reported when it is not 0.
+func FormatGains(result *LedgerResult, year int) string {
+	type row struct {
+		Year                                   int
+		Ticker                                 string
+		Proceeds, Cost, Gain, Dividends, Total float64
+	}
+	rows := make(map[[2]string]*row)
+	get := func(date time.Time, ticker string) *row {
+		key := [2]string{strconv.Itoa(date.Year()), ticker}
+		if rows[key] == nil {
+			rows[key] = &row{Year: date.Year(), Ticker: ticker}
+		}
+		return rows[key]
+	}
+	for _, gain := range result.Gains {
+		if year == 0 || gain.Closed.Year() == year {
+			r := get(gain.Closed, gain.Ticker)
+			r.Proceeds += gain.Proceeds
+			r.Cost += gain.Cost
+			r.Gain += gain.Gain
+		}
+	}
+	for _, dividend := range result.Dividends {
+		if year == 0 || dividend.Date.Year() == year {
+			get(dividend.Date, dividend.Ticker).Dividends += dividend.Price
+		}
+	}
+
+	var sorted []*row
+	totals := make(map[int]*row)
+	for _, r := range rows {
+		r.Total = r.Gain + r.Dividends
+		sorted = append(sorted, r)
+		if totals[r.Year] == nil {
+			totals[r.Year] = &row{Year: r.Year, Ticker: `Total`}
+		}
+		total := totals[r.Year]
+		total.Proceeds, total.Cost, total.Gain = total.Proceeds+r.Proceeds, total.Cost+r.Cost, total.Gain+r.Gain
+		total.Dividends, total.Total = total.Dividends+r.Dividends, total.Total+r.Total
+	}
+	for _, total := range totals {
+		sorted = append(sorted, total)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Year != sorted[j].Year {
+			return sorted[i].Year < sorted[j].Year
+		}
+		if (sorted[i].Ticker == `Total`) != (sorted[j].Ticker == `Total`) {
+			return sorted[j].Ticker == `Total`
+		}
+		return sorted[i].Ticker < sorted[j].Ticker
+	})
+
+	buffer := new(bytes.Buffer)
+	gainsTemplate.Execute(buffer, sorted)
+	return buffer.String()
+}
+
+var gainsTemplate = template.Must(template.New(`gains`).Funcs(template.FuncMap{
+	`num`: func(value float64) string { return fmt.Sprintf(`%13.2f`, value) },
+}).Parse(`<header><u>{{printf "%-6s %-9s%13s%13s%13s%13s%13s" "Year" "Ticker" "Proceeds" "Cost" "Gain" "Dividends" "Total"}}</u></>
+{{range .}}{{if eq .Ticker "Total"}}<b>{{end}}{{if gt .Total 0.0}}<gain>{{else if lt .Total 0.0}}<loss>{{end}}{{printf "%-6d %-9s" .Year .Ticker}}{{num .Proceeds}}{{num .Cost}}{{num .Gain}}{{num .Dividends}}{{num .Total}}</>{{if eq .Ticker "Total"}}</b>{{end}}
+{{end}}`))
+
+// Ledger keeps the open lots of the ledger file up to date for the quotes table. The file is replayed with FIFO matching whenever its modification time changes.
+type Ledger struct {
+	filename string
+	mutex    sync.Mutex
+	modified time.Time
+	open     Holdings
+	err      error
+}
+
+// This function creates a ledger backed by the file, which does not need to exist yet.
+func NewLedger(filename string) *Ledger {
+	return &Ledger{filename: filename}
+}
+
+// This function returns the lots open according to the ledger, reloading the file when it has changed, and the last error reading it.
+func (ledger *Ledger) Holdings() (Holdings, error) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+
+	info, err := os.Stat(ledger.filename)
+	if err != nil {
+		ledger.open, ledger.err = nil, nil
+		if !os.IsNotExist(err) {
+			ledger.err = err
+		}
+		return ledger.open, ledger.err
+	}
+	if info.ModTime().Equal(ledger.modified) {
+		return ledger.open, ledger.err
+	}
+
+	ledger.modified = info.ModTime()
+	transactions, err := LoadLedger(ledger.filename)
+	if err == nil {
+		var result *LedgerResult
+		if result, err = ReplayLedger(transactions, `fifo`); err == nil {
+			ledger.open = result.Open
+		}
+	}
+	ledger.err = err
+
+	return ledger.open, ledger.err
+}
+
+// -----------------------------------------------------------------------------
+func matchSell(lots []Lot, sell Transaction, method string) ([]RealizedGain, []Lot, error) {
+	if sell.Lot == `` && method == `specific` {
+		return nil, lots, fmt.Errorf("sell does not name a lot")
+	}
+
+	lots = append([]Lot(nil), lots...)
+	order := make([]int, 0, len(lots))
+	for i := range lots {
+		if sell.Lot == `` || lots[i].ID == sell.Lot {
+			order = append(order, i)
+		}
+	}
+	if method == `lifo` {
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+
+	var gains []RealizedGain
+	remaining := sell.Quantity
+	for _, i := range order {
+		if remaining <= 0 {
+			break
+		}
+		lot := &lots[i]
+		quantity := lot.Quantity
+		if remaining < quantity {
+			quantity = remaining
+		}
+		fees := lot.Fees * quantity / lot.Quantity
+		cost := quantity*lot.Price + fees
+		proceeds := quantity*sell.Price - sell.Fees*quantity/sell.Quantity
+		opened, _ := time.Parse(storeDayLayout, lot.Date)
+		gains = append(gains, RealizedGain{
+			Ticker:   sell.Ticker,
+			Lot:      lot.ID,
+			Opened:   opened,
+			Closed:   sell.Date,
+			Quantity: quantity,
+			Cost:     cost,
+			Proceeds: proceeds,
+			Gain:     proceeds - cost,
+		})
+		lot.Quantity -= quantity
+		lot.Fees -= fees
+		remaining -= quantity
+	}
+	if remaining > 1e-9 {
+		return nil, lots, fmt.Errorf("selling %s more shares than held", formatPrice(remaining))
+	}
+
+	open := lots[:0]
+	for _, lot := range lots {
+		if lot.Quantity > 1e-9 {
+			open = append(open, lot)
+		}
+	}
+	return gains, open, nil
+}
+
+// -----------------------------------------------------------------------------
+func parseTransaction(record []string) (Transaction, error) {
+	field := func(i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ``
+	}
+	number := func(i int) (float64, error) {
+		if field(i) == `` {
+			return 0, nil
+		}
+		return strconv.ParseFloat(field(i), 64)
+	}
+
+	var transaction Transaction
+	var err error
+	if transaction.Date, err = time.Parse(storeDayLayout, field(0)); err != nil {
+		return transaction, fmt.Errorf("invalid date %q", field(0))
+	}
+	transaction.Type = strings.ToLower(field(1))
+	transaction.Ticker = strings.ToUpper(field(2))
+	values := []*float64{&transaction.Quantity, &transaction.Price, &transaction.Fees}
+	for i, value := range values {
+		if *value, err = number(3 + i); err != nil {
+			return transaction, fmt.Errorf("invalid %s %q", strings.ToLower(ledgerHeader[3+i]), field(3+i))
+		}
+	}
+	transaction.Lot = field(6)
+
+	return transaction, validateTransaction(transaction)
+}
+
+// -----------------------------------------------------------------------------
+func validateTransaction(transaction Transaction) error {
+	if transaction.Ticker == `` {
+		return fmt.Errorf("missing ticker")
+	}
+	switch transaction.Type {
+	case `buy`, `sell`, `split`:
+		if transaction.Quantity <= 0 {
+			return fmt.Errorf("%s needs a positive quantity", transaction.Type)
+		}
+	case `dividend`:
+	default:
+		return fmt.Errorf("unknown transaction type %q (expected buy, sell, dividend or split)", transaction.Type)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package mop
+
+import (
+	"strings"
+	"testing"
+)
+
+// Two lots of AAPL, the first with fees, then a 2:1 split: 20 shares at 50 and 20 shares at 60.
+const testLedger = `Date,Type,Ticker,Quantity,Price,Fees,Lot
+2023-03-01,buy,AAPL,10,100,2,
+2023-06-01,buy,AAPL,10,120,,
+2023-08-15,dividend,AAPL,,2.40,,
+2024-02-01,split,AAPL,2,,,
+`
+
+func TestReplayLedger(t *testing.T) {
+	tests := []struct {
+		name   string
+		sells  string
+		method string
+		gains  []RealizedGain
+		open   []Lot
+		err    string
+	}{
+		{`no sell`, ``, `fifo`, nil, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 20, Price: 50, Date: `2023-03-01`, Fees: 2},
+			{ID: `2023-06-01#1`, Quantity: 20, Price: 60, Date: `2023-06-01`},
+		}, ``},
+		// 15 of the 20 shares of the first lot, with 3/4 of its fees; the sell fees are all on that lot.
+		{`fifo partial`, `2024-05-02,sell,AAPL,15,70,3,`, `fifo`, []RealizedGain{
+			{Lot: `2023-03-01#1`, Quantity: 15, Cost: 751.5, Proceeds: 1047, Gain: 295.5},
+		}, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 5, Price: 50, Date: `2023-03-01`, Fees: 0.5},
+			{ID: `2023-06-01#1`, Quantity: 20, Price: 60, Date: `2023-06-01`},
+		}, ``},
+		{`lifo partial`, `2024-05-02,sell,AAPL,15,70,3,`, `lifo`, []RealizedGain{
+			{Lot: `2023-06-01#1`, Quantity: 15, Cost: 900, Proceeds: 1047, Gain: 147},
+		}, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 20, Price: 50, Date: `2023-03-01`, Fees: 2},
+			{ID: `2023-06-01#1`, Quantity: 5, Price: 60, Date: `2023-06-01`},
+		}, ``},
+		// The sell fees are shared by the lots in proportion to the shares taken from each.
+		{`fifo across lots`, `2024-05-02,sell,AAPL,25,70,5,`, `fifo`, []RealizedGain{
+			{Lot: `2023-03-01#1`, Quantity: 20, Cost: 1002, Proceeds: 1396, Gain: 394},
+			{Lot: `2023-06-01#1`, Quantity: 5, Cost: 300, Proceeds: 349, Gain: 49},
+		}, []Lot{
+			{ID: `2023-06-01#1`, Quantity: 15, Price: 60, Date: `2023-06-01`},
+		}, ``},
+		{`lifo across lots`, `2024-05-02,sell,AAPL,25,70,5,`, `lifo`, []RealizedGain{
+			{Lot: `2023-06-01#1`, Quantity: 20, Cost: 1200, Proceeds: 1396, Gain: 196},
+			{Lot: `2023-03-01#1`, Quantity: 5, Cost: 250.5, Proceeds: 349, Gain: 98.5},
+		}, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 15, Price: 50, Date: `2023-03-01`, Fees: 1.5},
+		}, ``},
+		{`specific`, `2024-05-02,sell,AAPL,15,70,3,2023-06-01#1`, `specific`, []RealizedGain{
+			{Lot: `2023-06-01#1`, Quantity: 15, Cost: 900, Proceeds: 1047, Gain: 147},
+		}, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 20, Price: 50, Date: `2023-03-01`, Fees: 2},
+			{ID: `2023-06-01#1`, Quantity: 5, Price: 60, Date: `2023-06-01`},
+		}, ``},
+		// A sell naming a lot only closes that lot, whatever the method.
+		{`named lot with fifo`, `2024-05-02,sell,AAPL,20,70,,2023-06-01#1`, `fifo`, []RealizedGain{
+			{Lot: `2023-06-01#1`, Quantity: 20, Cost: 1200, Proceeds: 1400, Gain: 200},
+		}, []Lot{
+			{ID: `2023-03-01#1`, Quantity: 20, Price: 50, Date: `2023-03-01`, Fees: 2},
+		}, ``},
+		{`sold out`, "2024-05-02,sell,AAPL,15,70,,\n2024-06-03,sell,AAPL,25,80,,", `fifo`, []RealizedGain{
+			{Lot: `2023-03-01#1`, Quantity: 15, Cost: 751.5, Proceeds: 1050, Gain: 298.5},
+			{Lot: `2023-03-01#1`, Quantity: 5, Cost: 250.5, Proceeds: 400, Gain: 149.5},
+			{Lot: `2023-06-01#1`, Quantity: 20, Cost: 1200, Proceeds: 1600, Gain: 400},
+		}, nil, ``},
+		// Lots bought after a split keep their price; a second split applies to every open lot.
+		{`split after a sell`, "2024-05-02,sell,AAPL,20,70,,\n2024-05-03,buy,AAPL,10,65,,\n2024-06-03,split,AAPL,0.5,,,", `fifo`, []RealizedGain{
+			{Lot: `2023-03-01#1`, Quantity: 20, Cost: 1002, Proceeds: 1400, Gain: 398},
+		}, []Lot{
+			{ID: `2023-06-01#1`, Quantity: 10, Price: 120, Date: `2023-06-01`},
+			{ID: `2024-05-03#1`, Quantity: 5, Price: 130, Date: `2024-05-03`},
+		}, ``},
+		{`specific without lot`, `2024-05-02,sell,AAPL,15,70,,`, `specific`, nil, nil, `2024-05-02 AAPL: sell does not name a lot`},
+		{`more than held`, `2024-05-02,sell,AAPL,41,70,,`, `fifo`, nil, nil, `2024-05-02 AAPL: selling 1 more shares than held`},
+		{`more than the lot`, `2024-05-02,sell,AAPL,21,70,,2023-06-01#1`, `specific`, nil, nil, `selling 1 more shares than held`},
+		{`unknown method`, ``, `average`, nil, nil, `unknown matching method "average"`},
+	}
+	for _, test := range tests {
+		transactions, err := ReadLedger(strings.NewReader(testLedger + test.sells))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		result, err := ReplayLedger(transactions, test.method)
+		if test.err != `` {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(result.Gains) != len(test.gains) {
+			t.Errorf("%s: got %d gains, want %d: %+v", test.name, len(result.Gains), len(test.gains), result.Gains)
+		} else {
+			for i, want := range test.gains {
+				got := result.Gains[i]
+				if got.Ticker != `AAPL` || got.Lot != want.Lot || !closeTo(got.Quantity, want.Quantity) ||
+					!closeTo(got.Cost, want.Cost) || !closeTo(got.Proceeds, want.Proceeds) || !closeTo(got.Gain, want.Gain) {
+					t.Errorf("%s: gain %d is %+v, want %+v", test.name, i+1, got, want)
+				}
+			}
+		}
+		open := result.Open[`AAPL`]
+		if len(open) != len(test.open) {
+			t.Errorf("%s: got open lots %+v, want %+v", test.name, open, test.open)
+		} else {
+			for i, want := range test.open {
+				got := open[i]
+				if got.ID != want.ID || got.Date != want.Date || !closeTo(got.Quantity, want.Quantity) ||
+					!closeTo(got.Price, want.Price) || !closeTo(got.Fees, want.Fees) {
+					t.Errorf("%s: open lot %d is %+v, want %+v", test.name, i+1, got, want)
+				}
+			}
+		}
+		if len(result.Dividends) != 1 || result.Dividends[0].Price != 2.4 {
+			t.Errorf("%s: got dividends %+v", test.name, result.Dividends)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package mop
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+The forecasting models predict future closing prices from a series of historical bars:
+
+- `LinearTrend`: ordinary least squares line through the closes, with a proper regression prediction interval.
+- `MovingAverageCrossover`: extrapolates the slope implied by the gap between a fast and a slow simple moving average.
+- `ExponentialSmoothing`: Holt's double exponential smoothing of level and trend.
+
+Every model is fitted on the bars it is given and nothing else, so the backtester can refit it on the data
+available at any point in time. Confidence bands are derived from the model's in-sample one step ahead errors.
+*/
+
+// Prediction is a forecasted close with the lower and upper bounds of its confidence band.
+type Prediction struct {
+	Time  time.Time
+	Close float64
+	Lower float64
+	Upper float64
+}
+
+// Forecast is the output of one model for one ticker.
+type Forecast struct {
+	Ticker      string
+	Model       string
+	Predictions []Prediction
+}
+
+// Forecaster is implemented by every prediction model.
+type Forecaster interface {
+	Name() string
+	Fit(bars []Bar) error
+	Forecast(horizon int, confidence float64) []Prediction
+}
+
+var forecasterNames = []string{`linear`, `crossover`, `smoothing`}
+
+// This function returns the names of all available models in their canonical order.
+func ForecasterNames() []string {
+	return append([]string(nil), forecasterNames...)
+}
+
+// This function creates a model with its default parameters from its name.
+func NewForecaster(name string) (Forecaster, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case `linear`:
+		return &LinearTrend{Window: 60}, nil
+	case `crossover`:
+		return &MovingAverageCrossover{Fast: 10, Slow: 30}, nil
+	case `smoothing`:
+		return &ExponentialSmoothing{Alpha: 0.5, Beta: 0.1}, nil
+	}
+	return nil, fmt.Errorf("unknown model %q (available: %s)", name, strings.Join(forecasterNames, `, `))
+}
+
+// This function writes forecasts as CSV with one row per ticker, model and predicted day.
+func WriteForecasts(writer io.Writer, forecasts []Forecast) error {
+	out := csv.NewWriter(writer)
+	out.Write([]string{`Ticker`, `Model`, `Date`, `Close`, `Lower`, `Upper`})
+
+	for _, forecast := range forecasts {
+		for _, prediction := range forecast.Predictions {
+			out.Write([]string{
+				forecast.Ticker,
+				forecast.Model,
+				prediction.Time.Format(barTimeLayouts[0]),
+				strconv.FormatFloat(prediction.Close, 'f', 4, 64),
+				strconv.FormatFloat(prediction.Lower, 'f', 4, 64),
+				strconv.FormatFloat(prediction.Upper, 'f', 4, 64),
+			})
+		}
+	}
+	out.Flush()
+
+	return out.Error()
+}
+
+// LinearTrend fits a least squares line through the last Window closes (all of them when Window is 0).
+type LinearTrend struct {
+	Window    int
+	intercept float64
+	slope     float64
+	sigma     float64
+	n         float64
+	meanX     float64
+	sumXX     float64
+	last      time.Time
+}
+
+func (model *LinearTrend) Name() string { return `linear` }
+
+// This function fits the regression line and the standard error of its residuals.
+func (model *LinearTrend) Fit(bars []Bar) error {
+	if model.Window > 0 && len(bars) > model.Window {
+		bars = bars[len(bars)-model.Window:]
+	}
+	if len(bars) < 3 {
+		return fmt.Errorf("linear: need at least 3 bars, got %d", len(bars))
+	}
+
+	closes := Closes(bars)
+	n := float64(len(closes))
+	meanX, meanY := (n-1)/2, mean(closes)
+	sumXY, sumXX := 0.0, 0.0
+	for i, y := range closes {
+		dx := float64(i) - meanX
+		sumXY += dx * (y - meanY)
+		sumXX += dx * dx
+	}
+
+	model.slope = sumXY / sumXX
+	model.intercept = meanY - model.slope*meanX
+	residuals := 0.0
+	for i, y := range closes {
+		e := y - (model.intercept + model.slope*float64(i))
+		residuals += e * e
+	}
+	model.sigma = math.Sqrt(residuals / (n - 2))
+	model.n, model.meanX, model.sumXX = n, meanX, sumXX
+	model.last = bars[len(bars)-1].Time
+
+	return nil
+}
+
+// This function extends the regression line horizon days ahead.
+func (model *LinearTrend) Forecast(horizon int, confidence float64) []Prediction {
+	z := zScore(confidence)
+	predictions := make([]Prediction, horizon)
+	day := model.last
+	for h := 1; h <= horizon; h++ {
+		x := model.n - 1 + float64(h)
+		y := model.intercept + model.slope*x
+		se := model.sigma * math.Sqrt(1+1/model.n+(x-model.meanX)*(x-model.meanX)/model.sumXX)
+		day = nextTradingDay(day)
+		predictions[h-1] = Prediction{Time: day, Close: y, Lower: y - z*se, Upper: y + z*se}
+	}
+	return predictions
+}
+
+// MovingAverageCrossover projects the trend implied by a fast and a slow simple moving average. The two averages are centered
+// (Fast-1)/2 and (Slow-1)/2 days in the past, so their difference divided by the distance between the centers is the recent slope.
+type MovingAverageCrossover struct {
+	Fast  int
+	Slow  int
+	fast  float64
+	slope float64
+	sigma float64
+	last  time.Time
+}
+
+func (model *MovingAverageCrossover) Name() string { return `crossover` }
+
+// This function computes the current averages and the one step ahead errors the model would have made over the series.
+func (model *MovingAverageCrossover) Fit(bars []Bar) error {
+	if model.Fast < 1 || model.Slow <= model.Fast {
+		return fmt.Errorf("crossover: invalid windows %d/%d", model.Fast, model.Slow)
+	}
+	if len(bars) <= model.Slow {
+		return fmt.Errorf("crossover: need more than %d bars, got %d", model.Slow, len(bars))
+	}
+
+	closes := Closes(bars)
+	sums := make([]float64, len(closes)+1)
+	for i, price := range closes {
+		sums[i+1] = sums[i] + price
+	}
+	project := func(end int) (float64, float64) {
+		fast := (sums[end] - sums[end-model.Fast]) / float64(model.Fast)
+		slow := (sums[end] - sums[end-model.Slow]) / float64(model.Slow)
+		return fast, (fast - slow) / (float64(model.Slow-model.Fast) / 2)
+	}
+
+	squares := 0.0
+	for t := model.Slow; t < len(closes); t++ {
+		fast, slope := project(t)
+		e := closes[t] - (fast + slope*(float64(model.Fast-1)/2+1))
+		squares += e * e
+	}
+	model.sigma = math.Sqrt(squares / float64(len(closes)-model.Slow))
+	model.fast, model.slope = project(len(closes))
+	model.last = bars[len(bars)-1].Time
+
+	return nil
+}
+
+// This function extends the slope from the center of the fast average horizon days ahead.
+func (model *MovingAverageCrossover) Forecast(horizon int, confidence float64) []Prediction {
+	z := zScore(confidence)
+	predictions := make([]Prediction, horizon)
+	day := model.last
+	for h := 1; h <= horizon; h++ {
+		y := model.fast + model.slope*(float64(model.Fast-1)/2+float64(h))
+		se := model.sigma * math.Sqrt(float64(h))
+		day = nextTradingDay(day)
+		predictions[h-1] = Prediction{Time: day, Close: y, Lower: y - z*se, Upper: y + z*se}
+	}
+	return predictions
+}
+
+// ExponentialSmoothing is Holt's linear method: Alpha smooths the level and Beta the trend, both in (0, 1].
+type ExponentialSmoothing struct {
+	Alpha float64
+	Beta  float64
+	level float64
+	trend float64
+	sigma float64
+	last  time.Time
+}
+
+func (model *ExponentialSmoothing) Name() string { return `smoothing` }
+
+// This function runs the smoothing recursion over the series and records its one step ahead errors.
+func (model *ExponentialSmoothing) Fit(bars []Bar) error {
+	if model.Alpha <= 0 || model.Alpha > 1 || model.Beta <= 0 || model.Beta > 1 {
+		return fmt.Errorf("smoothing: invalid parameters alpha=%g beta=%g", model.Alpha, model.Beta)
+	}
+	if len(bars) < 3 {
+		return fmt.Errorf("smoothing: need at least 3 bars, got %d", len(bars))
+	}
+
+	closes := Closes(bars)
+	level, trend := closes[0], closes[1]-closes[0]
+	squares := 0.0
+	for _, y := range closes[1:] {
+		e := y - (level + trend)
+		squares += e * e
+		previous := level
+		level = model.Alpha*y + (1-model.Alpha)*(level+trend)
+		trend = model.Beta*(level-previous) + (1-model.Beta)*trend
+	}
+
+	model.level, model.trend = level, trend
+	model.sigma = math.Sqrt(squares / float64(len(closes)-1))
+	model.last = bars[len(bars)-1].Time
+
+	return nil
+}
+
+// This function extends the smoothed level along the smoothed trend horizon days ahead.
+func (model *ExponentialSmoothing) Forecast(horizon int, confidence float64) []Prediction {
+	z := zScore(confidence)
+	predictions := make([]Prediction, horizon)
+	day := model.last
+	variance := 0.0
+	for h := 1; h <= horizon; h++ {
+		if h > 1 {
+			c := model.Alpha * (1 + float64(h-1)*model.Beta)
+			variance += c * c
+		}
+		y := model.level + float64(h)*model.trend
+		se := model.sigma * math.Sqrt(1+variance)
+		day = nextTradingDay(day)
+		predictions[h-1] = Prediction{Time: day, Close: y, Lower: y - z*se, Upper: y + z*se}
+	}
+	return predictions
+}
+
+// -----------------------------------------------------------------------------
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// -----------------------------------------------------------------------------
+func zScore(confidence float64) float64 {
+	if confidence <= 0 || confidence >= 1 {
+		confidence = 0.95
+	}
+	return math.Sqrt2 * math.Erfinv(confidence)
+}
+
+// -----------------------------------------------------------------------------
+func nextTradingDay(day time.Time) time.Time {
+	day = day.AddDate(0, 0, 1)
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
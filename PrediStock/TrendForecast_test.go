@@ -0,0 +1,97 @@
+package mop
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This function returns daily bars with the given closes, on the trading days from Monday January 1st, 2024.
+func dailyBars(closes ...float64) []Bar {
+	bars := make([]Bar, len(closes))
+	day := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, price := range closes {
+		bars[i] = Bar{Time: day, Open: price, High: price, Low: price, Close: price}
+		day = nextTradingDay(day)
+	}
+	return bars
+}
+
+func TestForecasters(t *testing.T) {
+	z := zScore(0.95)
+	// The straight line 10, 12, ... 28 ends on Friday January 12th: the next trading days are the 15th and the 16th.
+	line := dailyBars(10, 12, 14, 16, 18, 20, 22, 24, 26, 28)
+	monday := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		model Forecaster
+		bars  []Bar
+		want  []Prediction // Times are only checked when set.
+	}{
+		{`linear on a line`, &LinearTrend{}, line, []Prediction{
+			{monday, 30, 30, 30}, {monday.AddDate(0, 0, 1), 32, 32, 32},
+		}},
+		// Fitted line 1.3 + 0.8x, residuals ±0.3 and ±0.9: sigma is √0.9, and the standard error at x = 4 is sigma·√2.5 = 1.5.
+		{`linear with residuals`, &LinearTrend{}, dailyBars(1, 3, 2, 4), []Prediction{
+			{Close: 4.5, Lower: 4.5 - 1.5*z, Upper: 4.5 + 1.5*z},
+		}},
+		{`linear on the window only`, &LinearTrend{Window: 3}, dailyBars(50, 0, 1, 2, 3), []Prediction{
+			{Close: 4, Lower: 4, Upper: 4},
+		}},
+		// The fast average 27 is centered half a day back, the slow one 25 a day and a half back: the slope is 2.
+		{`crossover on a line`, &MovingAverageCrossover{Fast: 2, Slow: 4}, line, []Prediction{
+			{monday, 30, 30, 30}, {monday.AddDate(0, 0, 1), 32, 32, 32},
+		}},
+		{`smoothing on a line`, &ExponentialSmoothing{Alpha: 0.5, Beta: 0.1}, line, []Prediction{
+			{monday, 30, 30, 30}, {monday.AddDate(0, 0, 1), 32, 32, 32},
+		}},
+		// Level 10 and trend 2, then 12 is predicted exactly, and 11 is predicted as 14: the level becomes 12.5 and the trend 1.25.
+		// Sigma is √(9/2), widened by √(1 + (0.5·1.5)²) = 1.25 on the second day.
+		{`smoothing with errors`, &ExponentialSmoothing{Alpha: 0.5, Beta: 0.5}, dailyBars(10, 12, 11), []Prediction{
+			{Close: 13.75, Lower: 13.75 - math.Sqrt(4.5)*z, Upper: 13.75 + math.Sqrt(4.5)*z},
+			{Close: 15, Lower: 15 - 1.25*math.Sqrt(4.5)*z, Upper: 15 + 1.25*math.Sqrt(4.5)*z},
+		}},
+	}
+	for _, test := range tests {
+		if err := test.model.Fit(test.bars); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := test.model.Forecast(len(test.want), 0.95)
+		for i, want := range test.want {
+			if !want.Time.IsZero() && !got[i].Time.Equal(want.Time) {
+				t.Errorf("%s: day %d is %s, want %s", test.name, i+1, got[i].Time.Format(`2006-01-02`), want.Time.Format(`2006-01-02`))
+			}
+			if !closeTo(got[i].Close, want.Close) || !closeTo(got[i].Lower, want.Lower) || !closeTo(got[i].Upper, want.Upper) {
+				t.Errorf("%s: day %d predicts %.6f in [%.6f, %.6f], want %.6f in [%.6f, %.6f]", test.name, i+1,
+					got[i].Close, got[i].Lower, got[i].Upper, want.Close, want.Lower, want.Upper)
+			}
+		}
+	}
+}
+
+func TestForecasterErrors(t *testing.T) {
+	tests := []struct {
+		model Forecaster
+		bars  []Bar
+		err   string
+	}{
+		{&LinearTrend{}, dailyBars(1, 2), `linear: need at least 3 bars, got 2`},
+		{&MovingAverageCrossover{Fast: 4, Slow: 4}, dailyBars(1, 2, 3, 4, 5), `crossover: invalid windows 4/4`},
+		{&MovingAverageCrossover{Fast: 2, Slow: 4}, dailyBars(1, 2, 3, 4), `crossover: need more than 4 bars, got 4`},
+		{&ExponentialSmoothing{Alpha: 0, Beta: 0.1}, dailyBars(1, 2, 3), `smoothing: invalid parameters alpha=0 beta=0.1`},
+		{&ExponentialSmoothing{Alpha: 0.5, Beta: 0.1}, dailyBars(1, 2), `smoothing: need at least 3 bars, got 2`},
+	}
+	for _, test := range tests {
+		if err := test.model.Fit(test.bars); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want %q", test.model.Name(), err, test.err)
+		}
+	}
+}
+
+// This function compares floats computed along different paths.
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
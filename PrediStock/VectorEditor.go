@@ -1,8 +1,13 @@
 package mop
 
 import (
+	"context"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nsf/termbox-go"
 )
@@ -14,12 +19,25 @@ type LineEditor struct {
 	screen  *Screen       
 	quotes  *Quotes       
 	regex   *regexp.Regexp 
+	chart   string             // Ticker entered at the chart prompt.
+	failed  error              // Why the command entered failed; the prompt stays open to show it.
+	matches []SymbolMatch      // Symbols matching the ticker being typed at the `+` prompt.
+	warned  string             // Input whose unknown tickers were reported; entering it again adds them anyway.
+	search  context.CancelFunc // Cancels the search under way, if any.
+	found   chan FetchResult   // Results of the searches, applied by the owner of the screen.
+	closed  bool               // Set once the prompt is closed.
 }
+
+// How long typing must pause before the provider is searched, and how long checking tickers may take.
+const searchDelay = 300 * time.Millisecond
+const searchTimeout = 3 * time.Second
+
 func NewLineEditor(screen *Screen, quotes *Quotes) *LineEditor {
 	return &LineEditor{
 		screen: screen,
 		quotes: quotes,
 		regex:  regexp.MustCompile(`[,\s]+`),
+		found:  make(chan FetchResult, 1),
 	}
 }
 func (editor *LineEditor) Prompt(command rune) *LineEditor {
@@ -29,11 +47,31 @@ func (editor *LineEditor) Prompt(command rune) *LineEditor {
 		filterPrompt = `Set filter (` + filter + `): `
 	}
 
+	removePrompt := `Remove tickers: `
+	if stock, ok := editor.quotes.Selected(); ok {
+		removePrompt = `Remove tickers (` + stock.Ticker + `): `
+	}
+
+	alertPrompt, lotPrompt := ``, ``
+	if stock, ok := editor.quotes.Selected(); ok {
+		alertPrompt = `Alert ` + stock.Ticker + ` when: `
+		lots := make([]string, 0, len(editor.quotes.profile.Holdings[stock.Ticker]))
+		for i, lot := range editor.quotes.profile.Holdings[stock.Ticker] {
+			lots = append(lots, fmt.Sprintf("%d: %s", i+1, lot))
+		}
+		lotPrompt = `Lot of ` + stock.Ticker + ` (quantity@price [date] [fees], set N quantity@price, delete N): `
+		if len(lots) > 0 {
+			lotPrompt = `Lot of ` + stock.Ticker + ` [` + strings.Join(lots, `, `) + `] (quantity@price [date] [fees], set N quantity@price, delete N): `
+		}
+	}
+
 	prompts := map[rune]string{
-		'+': `Add tickers: `, '-': `Remove tickers: `,
-		'f': filterPrompt,
+		'+': `Add tickers: `, '-': removePrompt,
+		'f': filterPrompt, 'c': `Chart ticker: `,
+		'a': alertPrompt, 'l': lotPrompt,
+		'w': `Watchlist (new NAME, rename NAME, delete): `,
 	}
-	if prompt, ok := prompts[command]; ok {
+	if prompt, ok := prompts[command]; ok && prompt != `` {
 		editor.prompt = prompt
 		editor.command = command
 
@@ -46,13 +84,23 @@ func (editor *LineEditor) Prompt(command rune) *LineEditor {
 }
 func (editor *LineEditor) Handle(ev termbox.Event) bool {
 	defer termbox.Flush()
+	before := editor.input
 
 	switch ev.Key {
 	case termbox.KeyEsc:
 		return editor.done()
 
 	case termbox.KeyEnter:
-		return editor.execute().done()
+		if editor.command == '+' && editor.warned != editor.input {
+			if unverified := editor.unverified(); len(unverified) > 0 {
+				editor.verify(unverified)
+				return false
+			}
+		}
+		return editor.submit()
+
+	case termbox.KeyTab:
+		editor.complete()
 
 	case termbox.KeyBackspace, termbox.KeyBackspace2:
 		editor.deletePreviousCharacter()
@@ -77,10 +125,26 @@ func (editor *LineEditor) Handle(ev termbox.Event) bool {
 			editor.insertCharacter(ev.Ch)
 		}
 	}
+	if editor.command == '+' && editor.input != before && ev.Key != termbox.KeyTab {
+		editor.suggest()
+	}
 
 	return false
 }
 
+// This function returns the channel delivering the matches of the provider for the ticker being typed at the `+` prompt, and the verdict on the tickers entered, to be applied with FetchResult.Apply by the owner of the screen. It returns nil for a nil editor, so that it can be selected on while no prompt is open.
+func (editor *LineEditor) Searched() <-chan FetchResult {
+	if editor == nil {
+		return nil
+	}
+	return editor.found
+}
+
+// This function reports whether the prompt was closed, which applying a verdict received from Searched does once the tickers entered are added.
+func (editor *LineEditor) Closed() bool {
+	return editor.closed
+}
+
 // -----------------------------------------------------------------------------
 func (editor *LineEditor) deletePreviousCharacter() *LineEditor {
 	if editor.cursor > 0 {
@@ -156,6 +220,9 @@ func (editor *LineEditor) execute() *LineEditor {
 			}
 		}
 	case '-':
+		if stock, ok := editor.quotes.Selected(); ok && len(strings.Trim(editor.input, `, `)) == 0 {
+			editor.input = stock.Ticker
+		}
 		tickers := editor.tokenize()
 		if len(tickers) > 0 {
 			before := len(editor.quotes.profile.Tickers)
@@ -175,18 +242,252 @@ func (editor *LineEditor) execute() *LineEditor {
 		editor.quotes.profile.SetFilter(editor.input)
 	case 'F':
 		editor.quotes.profile.SetFilter("")
+	case 'a':
+		if stock, ok := editor.quotes.Selected(); ok && len(strings.TrimSpace(editor.input)) > 0 {
+			editor.failed = editor.quotes.profile.AddAlert(stock.Ticker, strings.TrimSpace(editor.input))
+		}
+	case 'l':
+		if stock, ok := editor.quotes.Selected(); ok && len(strings.TrimSpace(editor.input)) > 0 {
+			if editor.failed = editor.editLot(stock.Ticker); editor.failed == nil {
+				editor.screen.Draw(editor.quotes)
+			}
+		}
+	case 'w':
+		fields := strings.Fields(editor.input)
+		name := ``
+		if len(fields) > 1 {
+			name = strings.Join(fields[1:], ` `)
+		}
+		switch {
+		case len(fields) == 0:
+		case fields[0] == `new`:
+			editor.failed = editor.quotes.CreateWatchlist(name)
+		case fields[0] == `rename`:
+			editor.failed = editor.quotes.profile.RenameWatchlist(name)
+		case fields[0] == `delete` && len(fields) == 1:
+			editor.failed = editor.quotes.DeleteWatchlist()
+		default:
+			editor.failed = fmt.Errorf("expected new NAME, rename NAME or delete")
+		}
+	case 'c':
+		if tickers := editor.tokenize(); len(tickers) > 0 {
+			editor.chart = tickers[0]
+		}
 	}
 
 	return editor
 }
 
+// This function adds the lot entered at the `l` prompt to the holdings of the ticker, or replaces or removes the lot numbered in the prompt: `set N quantity@price [date] [fees]` or `delete N`.
+func (editor *LineEditor) editLot(ticker string) error {
+	fields := strings.Fields(editor.input)
+	if fields[0] != `set` && fields[0] != `delete` {
+		lot, err := ParseLot(editor.input)
+		if err != nil {
+			return err
+		}
+		return editor.quotes.profile.AddLot(ticker, lot)
+	}
+
+	if len(fields) < 2 || (fields[0] == `delete` && len(fields) > 2) {
+		return fmt.Errorf("expected set N quantity@price or delete N")
+	}
+	number, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid lot number %q", fields[1])
+	}
+	if fields[0] == `delete` {
+		return editor.quotes.profile.RemoveLot(ticker, number-1)
+	}
+	lot, err := ParseLot(strings.Join(fields[2:], ` `))
+	if err != nil {
+		return err
+	}
+	return editor.quotes.profile.SetLot(ticker, number-1, lot)
+}
+
+// This function shows the known symbols matching the ticker being typed, then asks the provider for more once typing pauses.
+func (editor *LineEditor) suggest() {
+	if editor.search != nil {
+		editor.search()
+	}
+	query := editor.typing()
+	editor.matches = editor.quotes.symbols.Match(query)
+	editor.drawMatches()
+	if query == `` {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	editor.search = cancel
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(searchDelay):
+		}
+		matches, err := editor.quotes.symbols.Search(ctx, editor.quotes.market.provider, query)
+		if ctx.Err() != nil || err != nil {
+			return
+		}
+		select {
+		case editor.found <- FetchResult{apply: func() {
+			if ctx.Err() == nil && editor.typing() == query {
+				editor.matches = matches
+				editor.drawMatches()
+				termbox.Flush()
+			}
+		}}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// This function replaces the ticker being typed by the first match.
+func (editor *LineEditor) complete() {
+	query := editor.typing()
+	if len(editor.matches) == 0 || query == `` {
+		return
+	}
+	editor.input = editor.input[:len(editor.input)-len(query)] + editor.matches[0].Symbol
+	editor.screen.DrawLine(len(editor.prompt), 3, editor.input)
+	editor.jumpToEnd()
+	editor.suggest()
+}
+
+// This function returns the tickers entered that are not known to exist yet.
+func (editor *LineEditor) unverified() []string {
+	var unverified []string
+	for _, ticker := range editor.tokenize() {
+		if _, ok := editor.quotes.symbols.Lookup(ticker); !ok && ticker != `` {
+			unverified = append(unverified, ticker)
+		}
+	}
+	return unverified
+}
+
+// This function checks the tickers with the provider, all at once and in the background. The verdict arrives on the Searched channel: applying it adds the tickers entered when they all exist, and otherwise shows the others, which entering the same input again adds anyway.
+func (editor *LineEditor) verify(tickers []string) {
+	if editor.search != nil {
+		editor.search()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	editor.search = cancel
+	input := editor.input
+	editor.matches = nil
+	editor.drawMatches()
+	editor.screen.DrawLine(len(editor.prompt)+len(input)+2, 3, `<stale>checking `+strings.Join(tickers, `, `)+`...</>`)
+
+	go func() {
+		errs := make([]error, len(tickers))
+		var wait sync.WaitGroup
+		for i, ticker := range tickers {
+			wait.Add(1)
+			go func(i int, ticker string) {
+				defer wait.Done()
+				check, stop := context.WithTimeout(ctx, searchTimeout)
+				defer stop()
+				_, errs[i] = editor.quotes.symbols.Search(check, editor.quotes.market.provider, ticker)
+			}(i, ticker)
+		}
+		wait.Wait()
+
+		var unknown, unchecked []string
+		for i, ticker := range tickers {
+			if _, ok := editor.quotes.symbols.Lookup(ticker); ok {
+				continue
+			}
+			if errs[i] != nil {
+				unchecked = append(unchecked, ticker)
+			} else {
+				unknown = append(unknown, ticker)
+			}
+		}
+		select {
+		case editor.found <- FetchResult{apply: func() {
+			if ctx.Err() == nil && editor.input == input {
+				editor.verdict(unknown, unchecked)
+			}
+		}}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// This function adds the tickers entered when none is unknown or unchecked, and otherwise shows those and keeps the prompt open.
+func (editor *LineEditor) verdict(unknown, unchecked []string) {
+	if len(unknown) == 0 && len(unchecked) == 0 {
+		editor.submit()
+		termbox.Flush()
+		return
+	}
+
+	var warning []string
+	if len(unknown) > 0 {
+		warning = append(warning, `unknown `+strings.Join(unknown, `, `))
+	}
+	if len(unchecked) > 0 {
+		warning = append(warning, `could not check `+strings.Join(unchecked, `, `))
+	}
+	editor.warned = editor.input
+	editor.screen.ClearLine(len(editor.prompt)+len(editor.input)+1, 3)
+	editor.screen.DrawLine(len(editor.prompt)+len(editor.input)+2, 3, `<loss>`+strings.Join(warning, `; `)+` (Enter to add anyway)</>`)
+	termbox.Flush()
+}
+
+// This function runs the command entered and closes the prompt, unless the command failed: the prompt then stays open to show why.
+func (editor *LineEditor) submit() bool {
+	if editor.execute().failed != nil {
+		editor.screen.ClearLine(len(editor.prompt)+len(editor.input), 3)
+		editor.screen.DrawLine(len(editor.prompt)+len(editor.input)+2, 3, `<loss>`+editor.failed.Error()+`</>`)
+		editor.failed = nil
+		return false
+	}
+	return editor.done()
+}
+
+// -----------------------------------------------------------------------------
+func (editor *LineEditor) typing() string {
+	tickers := editor.regex.Split(strings.ToUpper(editor.input), -1)
+	return tickers[len(tickers)-1]
+}
+
+// -----------------------------------------------------------------------------
+func (editor *LineEditor) drawMatches() {
+	x := len(editor.prompt) + len(editor.input) + 2
+	editor.screen.ClearLine(x-1, 3)
+
+	labels := make([]string, 0, len(editor.matches))
+	for _, match := range editor.matches {
+		label := match.Symbol
+		if match.Name != `` {
+			label += ` ` + match.Name
+		}
+		if match.Exchange != `` {
+			label += ` (` + match.Exchange + `)`
+		}
+		labels = append(labels, label)
+	}
+	if len(labels) > 0 {
+		editor.screen.DrawLine(x, 3, `<stale>`+strings.Join(labels, ` | `)+`</>`)
+	}
+}
+
 // -----------------------------------------------------------------------------
 func (editor *LineEditor) done() bool {
+	if editor.search != nil {
+		editor.search()
+	}
 	editor.screen.ClearLine(0, 3)
 	termbox.HideCursor()
+	editor.closed = true
 
 	return true
 }
+// This function returns the ticker entered at the chart prompt, or an empty string when the prompt was cancelled.
+func (editor *LineEditor) ChartTicker() string {
+	return editor.chart
+}
 func (editor *LineEditor) tokenize() []string {
 	input := strings.ToUpper(strings.Trim(editor.input, `, `))
 	return editor.regex.Split(input, -1)
@@ -0,0 +1,133 @@
+package mop
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"text/template"
+	"time"
+)
+
+/*
+The walk-forward backtester replays historical bars one day at a time. On every day it refits the model on the
+bars available up to the previous close, predicts the next close and compares the prediction with what actually
+happened. The prediction also drives a simple long/flat strategy: hold the stock for the day when the model
+predicts a higher close, stay in cash otherwise. The strategy is compared with buying and holding over the
+same period.
+*/
+
+// BacktestResult holds the accuracy and trading statistics of one model on one ticker. Ratios are in percent.
+type BacktestResult struct {
+	Ticker                string    `json:"ticker"`
+	Model                 string    `json:"model"`
+	From                  time.Time `json:"from"`
+	To                    time.Time `json:"to"`
+	Predictions           int       `json:"predictions"`
+	MAE                   float64   `json:"mae"`
+	RMSE                  float64   `json:"rmse"`
+	MAPE                  float64   `json:"mapePct"`
+	DirectionalAccuracy   float64   `json:"directionalAccuracyPct"`
+	StrategyReturn        float64   `json:"strategyReturnPct"`
+	BuyAndHoldReturn      float64   `json:"buyAndHoldReturnPct"`
+	DaysInMarket          int       `json:"daysInMarket"`
+	OutperformsBuyAndHold bool      `json:"outperformsBuyAndHold"`
+}
+
+// BacktestReport is the machine readable output of a backtest run.
+type BacktestReport struct {
+	Generated time.Time        `json:"generated"`
+	Warmup    int              `json:"warmup"`
+	Results   []BacktestResult `json:"results"`
+}
+
+// This function runs a walk-forward backtest of the model over the bars. The first `warmup` bars are only used for training. Days on which the model cannot be fitted are skipped; an error is returned when no prediction could be made at all.
+func Backtest(ticker string, bars []Bar, model Forecaster, warmup int) (BacktestResult, error) {
+	result := BacktestResult{Ticker: ticker, Model: model.Name()}
+	if warmup < 1 {
+		warmup = 1
+	}
+	if len(bars) <= warmup {
+		return result, fmt.Errorf("%s: need more than %d bars to backtest, got %d", ticker, warmup, len(bars))
+	}
+
+	absolute, squared, percentage, hits := 0.0, 0.0, 0.0, 0
+	strategy, lastErr := 1.0, error(nil)
+	for t := warmup; t < len(bars); t++ {
+		if err := model.Fit(bars[:t]); err != nil {
+			lastErr = err
+			continue
+		}
+		predicted := model.Forecast(1, 0.95)[0].Close
+		previous, actual := bars[t-1].Close, bars[t].Close
+
+		if result.Predictions == 0 {
+			result.From = bars[t].Time
+		}
+		result.To = bars[t].Time
+		result.Predictions++
+
+		e := actual - predicted
+		absolute += math.Abs(e)
+		squared += e * e
+		if actual != 0 {
+			percentage += math.Abs(e / actual)
+		}
+		if sign(predicted-previous) == sign(actual-previous) {
+			hits++
+		}
+		if predicted > previous && previous != 0 {
+			strategy *= actual / previous
+			result.DaysInMarket++
+		}
+	}
+	if result.Predictions == 0 {
+		return result, fmt.Errorf("%s: no predictions made: %v", ticker, lastErr)
+	}
+
+	n := float64(result.Predictions)
+	result.MAE = absolute / n
+	result.RMSE = math.Sqrt(squared / n)
+	result.MAPE = 100 * percentage / n
+	result.DirectionalAccuracy = 100 * float64(hits) / n
+	result.StrategyReturn = 100 * (strategy - 1)
+	if start := closeBefore(bars, result.From); start != 0 {
+		result.BuyAndHoldReturn = 100 * (bars[len(bars)-1].Close/start - 1)
+	}
+	result.OutperformsBuyAndHold = result.StrategyReturn > result.BuyAndHoldReturn
+
+	return result, nil
+}
+
+// This function renders backtest results as a table using the markup tags understood by `Markup`. Rows where the strategy beats buying and holding are tagged as gains, the others as losses.
+func FormatBacktest(results []BacktestResult) string {
+	buffer := new(bytes.Buffer)
+	backtestTemplate.Execute(buffer, results)
+	return buffer.String()
+}
+
+var backtestTemplate = template.Must(template.New(`backtest`).Funcs(template.FuncMap{
+	`num`: func(value float64) string { return fmt.Sprintf(`%10.2f`, value) },
+	`pct`: func(value float64) string { return fmt.Sprintf(`%9.2f%%`, value) },
+}).Parse(`<header><u>{{printf "%-9s %-12s %-10s  %-10s%6s %10s %10s%10s%10s%10s%10s" "Ticker" "Model" "From" "To" "Days" "MAE" "RMSE" "MAPE" "DirAcc" "Strategy" "BuyHold"}}</u></>
+{{range .}}{{if .OutperformsBuyAndHold}}<gain>{{else}}<loss>{{end}}{{printf "%-9s %-12s" .Ticker .Model}} {{.From.Format "2006-01-02"}}  {{.To.Format "2006-01-02"}}{{printf "%6d" .Predictions}} {{num .MAE}} {{num .RMSE}}{{pct .MAPE}}{{pct .DirectionalAccuracy}}{{pct .StrategyReturn}}{{pct .BuyAndHoldReturn}}</>
+{{end}}`))
+
+// -----------------------------------------------------------------------------
+func sign(value float64) int {
+	if value > 0 {
+		return 1
+	} else if value < 0 {
+		return -1
+	}
+	return 0
+}
+
+// -----------------------------------------------------------------------------
+func closeBefore(bars []Bar, day time.Time) float64 {
+	for i := len(bars) - 1; i > 0; i-- {
+		if bars[i].Time.Equal(day) {
+			return bars[i-1].Close
+		}
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package mop
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// scriptedModel predicts, after being fitted on n bars, the n-th of its closes. It cannot be fitted on fewer than `from` bars.
+type scriptedModel struct {
+	closes []float64
+	from   int
+	fitted int
+}
+
+func (model *scriptedModel) Name() string { return `scripted` }
+
+func (model *scriptedModel) Fit(bars []Bar) error {
+	if len(bars) < model.from {
+		return fmt.Errorf("scripted: need %d bars", model.from)
+	}
+	model.fitted = len(bars)
+	return nil
+}
+
+func (model *scriptedModel) Forecast(horizon int, confidence float64) []Prediction {
+	return []Prediction{{Close: model.closes[model.fitted-1]}}
+}
+
+func TestBacktest(t *testing.T) {
+	bars := dailyBars(10, 12, 11, 13)
+	tests := []struct {
+		name   string
+		model  *scriptedModel
+		warmup int
+		want   BacktestResult
+	}{
+		// Predicting 11, 13 and 12: errors 1, -2 and 1, the fall to 11 is missed, and the strategy is long every day: 12/10 · 11/12 · 13/11.
+		{`long every day`, &scriptedModel{closes: []float64{11, 13, 12}}, 1, BacktestResult{
+			Predictions: 3, MAE: 4.0 / 3, RMSE: math.Sqrt2, MAPE: 100 * (1.0/12 + 2.0/11 + 1.0/13) / 3,
+			DirectionalAccuracy: 200.0 / 3, StrategyReturn: 30, BuyAndHoldReturn: 30, DaysInMarket: 3,
+		}},
+		// Predicting the fall to 11 keeps the strategy in cash that day: 12/10 · 13/11.
+		{`flat on the fall`, &scriptedModel{closes: []float64{11, 11, 12}}, 1, BacktestResult{
+			Predictions: 3, MAE: 2.0 / 3, RMSE: math.Sqrt(2.0 / 3), MAPE: 100 * (1.0/12 + 1.0/13) / 3,
+			DirectionalAccuracy: 100, StrategyReturn: 100 * (1.2*13/11 - 1), BuyAndHoldReturn: 30, DaysInMarket: 2,
+			OutperformsBuyAndHold: true,
+		}},
+		// After two bars of warmup, buying and holding starts from the close of the second bar.
+		{`warmup`, &scriptedModel{closes: []float64{0, 13, 12}}, 2, BacktestResult{
+			Predictions: 2, MAE: 1.5, RMSE: math.Sqrt(2.5), MAPE: 100 * (2.0/11 + 1.0/13) / 2,
+			DirectionalAccuracy: 50, StrategyReturn: 100 * (11.0*13/12/11 - 1), BuyAndHoldReturn: 100 * (13.0/12 - 1), DaysInMarket: 2,
+		}},
+		// Days the model cannot be fitted on are skipped.
+		{`unfitted days`, &scriptedModel{closes: []float64{0, 0, 12}, from: 3}, 1, BacktestResult{
+			Predictions: 1, MAE: 1, RMSE: 1, MAPE: 100.0 / 13,
+			DirectionalAccuracy: 100, StrategyReturn: 100 * (13.0/11 - 1), BuyAndHoldReturn: 100 * (13.0/11 - 1), DaysInMarket: 1,
+		}},
+	}
+	for _, test := range tests {
+		got, err := Backtest(`AAPL`, bars, test.model, test.warmup)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := test.want
+		if got.Predictions != want.Predictions || got.DaysInMarket != want.DaysInMarket || got.OutperformsBuyAndHold != want.OutperformsBuyAndHold {
+			t.Errorf("%s: got %d predictions, %d days in the market and outperforming %v, want %d, %d and %v", test.name,
+				got.Predictions, got.DaysInMarket, got.OutperformsBuyAndHold, want.Predictions, want.DaysInMarket, want.OutperformsBuyAndHold)
+		}
+		metrics := []struct {
+			name      string
+			got, want float64
+		}{
+			{`MAE`, got.MAE, want.MAE},
+			{`RMSE`, got.RMSE, want.RMSE},
+			{`MAPE`, got.MAPE, want.MAPE},
+			{`directional accuracy`, got.DirectionalAccuracy, want.DirectionalAccuracy},
+			{`strategy return`, got.StrategyReturn, want.StrategyReturn},
+			{`buy and hold return`, got.BuyAndHoldReturn, want.BuyAndHoldReturn},
+		}
+		for _, metric := range metrics {
+			if !closeTo(metric.got, metric.want) {
+				t.Errorf("%s: %s is %.6f, want %.6f", test.name, metric.name, metric.got, metric.want)
+			}
+		}
+		if first := bars[len(bars)-want.Predictions]; !got.From.Equal(first.Time) || !got.To.Equal(bars[len(bars)-1].Time) {
+			t.Errorf("%s: got %s to %s", test.name, got.From.Format(`2006-01-02`), got.To.Format(`2006-01-02`))
+		}
+	}
+}
+
+func TestBacktestErrors(t *testing.T) {
+	bars := dailyBars(10, 12, 11)
+	if _, err := Backtest(`AAPL`, bars, &scriptedModel{}, 3); err == nil || !strings.Contains(err.Error(), `need more than 3 bars`) {
+		t.Errorf("too few bars: got %v", err)
+	}
+	if _, err := Backtest(`AAPL`, bars, &scriptedModel{from: 5}, 1); err == nil || !strings.Contains(err.Error(), `no predictions made: scripted: need 5 bars`) {
+		t.Errorf("no fit: got %v", err)
+	}
+}
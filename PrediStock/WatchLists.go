@@ -0,0 +1,248 @@
+package mop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Watchlists are named lists of tickers kept in the profile next to the main list in `Profile.Tickers`. Every
+list may override the sort column, sort order, filter and grouping of the profile; a setting changed while a
+list is active becomes an override of that list, settings it never changed follow the profile.
+
+While a list is active the exported fields of the profile hold its effective settings, so `Sorter`, `Filter`
+and `Layout` need no knowledge of watchlists. The settings of the main list are kept aside and written back
+to the top level fields by `Save`.
+*/
+
+// Watchlist is a named list of tickers. Nil settings follow the profile.
+type Watchlist struct {
+	Name       string
+	Tickers    []string
+	SortColumn *int    `json:",omitempty"`
+	Ascending  *bool   `json:",omitempty"`
+	Filter     *string `json:",omitempty"`
+	Grouped    *bool   `json:",omitempty"`
+}
+
+// listSettings are the profile fields a watchlist can have its own value for.
+type listSettings struct {
+	Tickers    []string
+	SortColumn int
+	Ascending  bool
+	Filter     string
+	Grouped    bool
+}
+
+// This function returns the name of the active watchlist, or an empty string for the main list.
+func (profile *Profile) ActiveWatchlist() string {
+	return profile.Watchlist
+}
+
+// This function returns the names of the watchlists, in the order `NextWatchlist` cycles through them after the main list.
+func (profile *Profile) WatchlistNames() []string {
+	names := make([]string, 0, len(profile.Watchlists))
+	for _, list := range profile.Watchlists {
+		names = append(names, list.Name)
+	}
+	return names
+}
+
+// This function returns the tickers of the named watchlist, or of the main list when the name is empty. It returns false for an unknown name.
+func (profile *Profile) WatchlistTickers(name string) ([]string, bool) {
+	switch {
+	case name == profile.Watchlist:
+		return append([]string(nil), profile.Tickers...), true
+	case name == ``:
+		return append([]string(nil), profile.main.Tickers...), true
+	}
+	for _, list := range profile.Watchlists {
+		if list.Name == name {
+			return append([]string(nil), list.Tickers...), true
+		}
+	}
+	return nil, false
+}
+
+// This function adds tickers to the main list, whichever list is active, e.g. those bought in the ledger. When any was added, it saves the profile.
+func (profile *Profile) AddMainTickers(tickers []string) (added int, err error) {
+	if profile.activeList() == nil {
+		return profile.AddTickers(tickers)
+	}
+	existing := make(map[string]bool, len(profile.main.Tickers))
+	for _, ticker := range profile.main.Tickers {
+		existing[ticker] = true
+	}
+	for _, ticker := range tickers {
+		if !existing[ticker] {
+			profile.main.Tickers, existing[ticker] = append(profile.main.Tickers, ticker), true
+			added++
+		}
+	}
+	if added > 0 {
+		sort.Strings(profile.main.Tickers)
+		err = profile.Save()
+	}
+	return
+}
+
+// This function switches to the list following the active one, the main list coming after the last watchlist. After switching, it saves the profile.
+func (profile *Profile) NextWatchlist() error {
+	names := append([]string{``}, profile.WatchlistNames()...)
+	next := 0
+	for i, name := range names {
+		if name == profile.Watchlist {
+			next = (i + 1) % len(names)
+		}
+	}
+
+	profile.switchWatchlist(names[next])
+	return profile.Save()
+}
+
+// This function switches to the watchlist with the given name, or to the main list when the name is empty. After switching, it saves the profile.
+func (profile *Profile) SelectWatchlist(name string) error {
+	if name != `` && !profile.hasWatchlist(name) {
+		return fmt.Errorf("unknown watchlist %q", name)
+	}
+	profile.switchWatchlist(name)
+
+	return profile.Save()
+}
+
+// This function creates an empty watchlist with the current settings and switches to it. After switching, it saves the profile.
+func (profile *Profile) CreateWatchlist(name string) error {
+	if err := profile.checkWatchlistName(name); err != nil {
+		return err
+	}
+	profile.Watchlists = append(profile.Watchlists, Watchlist{Name: name, Tickers: []string{}})
+	profile.switchWatchlist(name)
+
+	return profile.Save()
+}
+
+// This function renames the active watchlist. The main list cannot be renamed.
+func (profile *Profile) RenameWatchlist(name string) error {
+	list := profile.activeList()
+	if list == nil {
+		return fmt.Errorf("the main list cannot be renamed")
+	}
+	if err := profile.checkWatchlistName(name); err != nil {
+		return err
+	}
+	list.Name, profile.Watchlist = name, name
+
+	return profile.Save()
+}
+
+// This function deletes the active watchlist and switches back to the main list. The main list cannot be deleted.
+func (profile *Profile) DeleteWatchlist() error {
+	if profile.activeList() == nil {
+		return fmt.Errorf("the main list cannot be deleted")
+	}
+	deleted := profile.Watchlist
+	profile.switchWatchlist(``)
+	for i, list := range profile.Watchlists {
+		if list.Name == deleted {
+			profile.Watchlists = append(profile.Watchlists[:i], profile.Watchlists[i+1:]...)
+			break
+		}
+	}
+
+	return profile.Save()
+}
+
+// -----------------------------------------------------------------------------
+func (profile *Profile) activeList() *Watchlist {
+	for i := range profile.Watchlists {
+		if profile.Watchlists[i].Name == profile.Watchlist && profile.Watchlist != `` {
+			return &profile.Watchlists[i]
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+func (profile *Profile) checkWatchlistName(name string) error {
+	if strings.TrimSpace(name) == `` {
+		return fmt.Errorf("missing watchlist name")
+	}
+	if profile.hasWatchlist(name) {
+		return fmt.Errorf("watchlist %q already exists", name)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+func (profile *Profile) hasWatchlist(name string) bool {
+	for _, list := range profile.Watchlists {
+		if list.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+func (profile *Profile) current() listSettings {
+	return listSettings{profile.Tickers, profile.SortColumn, profile.Ascending, profile.Filter, profile.Grouped}
+}
+
+// This function records the effective settings: in the main settings when no watchlist is active, otherwise as the tickers of the active list and as overrides for the settings that differ from the main ones or were already overridden.
+func (profile *Profile) captureWatchlist() {
+	list := profile.activeList()
+	if list == nil {
+		profile.main = profile.current()
+		return
+	}
+
+	list.Tickers = profile.Tickers
+	if list.SortColumn != nil || profile.SortColumn != profile.main.SortColumn {
+		sortColumn := profile.SortColumn
+		list.SortColumn = &sortColumn
+	}
+	if list.Ascending != nil || profile.Ascending != profile.main.Ascending {
+		ascending := profile.Ascending
+		list.Ascending = &ascending
+	}
+	if list.Filter != nil || profile.Filter != profile.main.Filter {
+		filter := profile.Filter
+		list.Filter = &filter
+	}
+	if list.Grouped != nil || profile.Grouped != profile.main.Grouped {
+		grouped := profile.Grouped
+		list.Grouped = &grouped
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (profile *Profile) switchWatchlist(name string) {
+	profile.captureWatchlist()
+	profile.Watchlist = name
+
+	settings := profile.main
+	if list := profile.activeList(); list != nil {
+		settings.Tickers = list.Tickers
+		if list.SortColumn != nil {
+			settings.SortColumn = *list.SortColumn
+		}
+		if list.Ascending != nil {
+			settings.Ascending = *list.Ascending
+		}
+		if list.Filter != nil {
+			settings.Filter = *list.Filter
+		}
+		if list.Grouped != nil {
+			settings.Grouped = *list.Grouped
+		}
+	} else {
+		profile.Watchlist = ``
+	}
+
+	profile.Tickers = settings.Tickers
+	profile.SortColumn = settings.SortColumn
+	profile.Ascending = settings.Ascending
+	profile.Grouped = settings.Grouped
+	profile.SetFilter(settings.Filter)
+}
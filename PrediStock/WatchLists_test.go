@@ -0,0 +1,74 @@
+package mop
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatchlistRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `.moprc`)
+	profile, err := NewProfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.SortColumn, profile.Ascending = 2, true
+	profile.SetFilter(`last > 10`)
+	if err := profile.Save(); err != nil {
+		t.Fatal(err)
+	}
+	mainTickers := strings.Join(profile.Tickers, `,`)
+
+	// The settings changed on a list become its overrides; the others follow the main list.
+	if err := profile.CreateWatchlist(`tech`); err != nil {
+		t.Fatal(err)
+	}
+	profile.Tickers = []string{`MSFT`, `NVDA`}
+	profile.SortColumn, profile.Ascending = 5, false
+	if err := profile.SelectWatchlist(``); err != nil {
+		t.Fatal(err)
+	}
+	profile.Grouped = true
+	if err := profile.SelectWatchlist(`tech`); err != nil {
+		t.Fatal(err)
+	}
+	if !profile.Grouped || profile.Filter != `last > 10` || profile.SortColumn != 5 || profile.Ascending {
+		t.Errorf("tech has %+v, want its sort with the main filter and grouping", profile.current())
+	}
+	if err := profile.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file keeps the main settings at the top level, whichever list was active when saved.
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Profile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(saved.Tickers, `,`) != mainTickers || saved.SortColumn != 2 || !saved.Ascending || saved.Filter != `last > 10` || !saved.Grouped {
+		t.Errorf("saved main settings %v %d %v %q %v", saved.Tickers, saved.SortColumn, saved.Ascending, saved.Filter, saved.Grouped)
+	}
+	if len(saved.Watchlists) != 1 || saved.Watchlists[0].Filter != nil || saved.Watchlists[0].Grouped != nil {
+		t.Errorf("saved watchlists %+v, want tech without filter or grouping overrides", saved.Watchlists)
+	}
+
+	// Reloaded, the profile is back on tech, and the main list still has its own settings.
+	reloaded, err := NewProfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.ActiveWatchlist() != `tech` || strings.Join(reloaded.Tickers, `,`) != `MSFT,NVDA` || reloaded.SortColumn != 5 || reloaded.Ascending {
+		t.Errorf("reloaded %q with %+v", reloaded.ActiveWatchlist(), reloaded.current())
+	}
+	if err := reloaded.SelectWatchlist(``); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(reloaded.Tickers, `,`) != mainTickers || reloaded.SortColumn != 2 || !reloaded.Ascending || !reloaded.Grouped {
+		t.Errorf("reloaded main list has %+v", reloaded.current())
+	}
+}
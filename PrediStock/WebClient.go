@@ -0,0 +1,167 @@
+package mop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+All the requests of a provider go through one `WebClient`:
+
+- It owns one `http.Transport`, so connections are reused from one refresh to the next, and sends them through
+  the `Proxy` of the profile, or the proxy of the environment (`HTTPS_PROXY`) when there is none.
+- Every attempt has a deadline, `RequestTimeout` seconds of the profile or 10 by default, within the context of
+  the caller, which cancels the request when the tickers it was made for changed.
+- Network errors and answers 429 and 5xx are retried up to 3 times, after an exponential back-off with jitter
+  starting at half a second, or after the delay of the `Retry-After` header.
+
+A request failing for good is returned as a `*RequestError`. Other answers, 401 included, are returned to the
+caller, which knows what they mean.
+*/
+
+// Default deadline of one attempt of a request.
+const defaultRequestTimeout = 10 * time.Second
+
+// Retries of a request, and bounds of the back-off between them.
+const requestRetries = 3
+const retryMin = 500 * time.Millisecond
+const retryMax = 10 * time.Second
+
+// WebClient sends HTTP requests with deadlines and retries, see NewWebClient.
+type WebClient struct {
+	client  *http.Client
+	timeout time.Duration // Deadline of one attempt.
+}
+
+// RequestError describes a request that failed after its retries.
+type RequestError struct {
+	URL      string // Host and path requested, without the query, which may hold credentials.
+	Status   int    // HTTP status of the last answer, 0 when none was received.
+	Attempts int    // Number of attempts made.
+	Err      error  // Why no answer was received, nil when Status is set.
+}
+
+// This function creates a client sending its requests through the proxy, e.g. `http://proxy:3128` or `socks5://127.0.0.1:1080`, or the proxy of the environment when it is empty. A timeout of 0 or less stands for the default of 10 seconds.
+func NewWebClient(proxy string, timeout time.Duration) (*WebClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 8
+	if proxy != `` {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == `` {
+			return nil, fmt.Errorf("invalid proxy %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+
+	return &WebClient{client: &http.Client{Transport: transport}, timeout: timeout}, nil
+}
+
+// This function sends the request made by `build`, once per attempt, and returns the answer with its body, read and closed. The error is a `*RequestError` unless the request could not be built.
+func (client *WebClient) Do(ctx context.Context, build func() (*http.Request, error)) (*http.Response, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		request, err := build()
+		if err != nil {
+			return nil, nil, err
+		}
+		response, body, err := client.attempt(ctx, request)
+		if err == nil && response.StatusCode != http.StatusTooManyRequests && response.StatusCode < 500 {
+			return response, body, nil
+		}
+
+		failure := &RequestError{URL: request.URL.Host + request.URL.Path, Attempts: attempt, Err: err}
+		if err == nil {
+			failure.Status = response.StatusCode
+		}
+		if attempt > requestRetries || ctx.Err() != nil {
+			if ctx.Err() != nil {
+				failure.Err = ctx.Err()
+			}
+			return response, body, failure
+		}
+		select {
+		case <-ctx.Done():
+			failure.Err = ctx.Err()
+			return response, body, failure
+		case <-time.After(retryDelay(attempt, response)):
+		}
+	}
+}
+
+// This function describes the failure, with the HTTP status when there was an answer.
+func (err *RequestError) Error() string {
+	if err.Status != 0 {
+		return fmt.Sprintf("%s answered %d %s (%d attempts)", err.URL, err.Status, http.StatusText(err.Status), err.Attempts)
+	}
+	return fmt.Sprintf("requesting %s failed (%d attempts): %v", err.URL, err.Attempts, err.Err)
+}
+
+// This function returns the reason no answer was received.
+func (err *RequestError) Unwrap() error {
+	return err.Err
+}
+
+// This function reports whether the last attempt ran out of time.
+func (err *RequestError) Timeout() bool {
+	var timeout net.Error
+	return errors.Is(err.Err, context.DeadlineExceeded) || (errors.As(err.Err, &timeout) && timeout.Timeout())
+}
+
+// This function describes a failed fetch of the given data for the status line, e.g. "quotes: timed out after 4 attempts".
+func describeFetchError(what string, err error) string {
+	var failure *RequestError
+	switch {
+	case !errors.As(err, &failure):
+		return fmt.Sprintf("%s: %v", what, err)
+	case failure.Timeout():
+		return fmt.Sprintf("%s: timed out after %d attempts", what, failure.Attempts)
+	case failure.Status != 0:
+		return fmt.Sprintf("%s: %s answered %d %s", what, failure.URL, failure.Status, http.StatusText(failure.Status))
+	}
+	return fmt.Sprintf("%s: no answer from %s after %d attempts", what, failure.URL, failure.Attempts)
+}
+
+// -----------------------------------------------------------------------------
+func (client *WebClient) attempt(ctx context.Context, request *http.Request) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.timeout)
+	defer cancel()
+
+	response, err := client.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response, body, nil
+}
+
+// This function returns how long to wait before the next attempt: the `Retry-After` delay of the answer, or an exponential back-off with jitter.
+func retryDelay(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get(`Retry-After`)); err == nil && seconds >= 0 {
+			if delay := time.Duration(seconds) * time.Second; delay < retryMax {
+				return delay
+			}
+			return retryMax
+		}
+	}
+	delay := retryMin << uint(attempt-1)
+	if delay > retryMax {
+		delay = retryMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,394 @@
+package mop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const yahooQuotesURL = `https://query1.finance.yahoo.com/v7/finance/quote?crumb=%s&symbols=%s`
+const yahooChartURL = `https://query1.finance.yahoo.com/v8/finance/chart/%s?range=%s&interval=%s&includePrePost=false&crumb=%s`
+const yahooSummaryURL = `https://query1.finance.yahoo.com/v10/finance/quoteSummary/%s?modules=summaryDetail&crumb=%s`
+const yahooSearchURL = `https://query1.finance.yahoo.com/v1/finance/search?q=%s&quotesCount=8&newsCount=0&listsCount=0&crumb=%s`
+const yahooQuotesURLQueryParts = `&range=1d&interval=5m&indicators=close&includeTimestamps=false&includePrePost=false&corsDomain=finance.yahoo.com&.tsrc=finance`
+
+// Range and interval parameters of the chart API for each of the ChartPeriods.
+var yahooChartPeriods = map[string][2]string{
+	`1d`: {`1d`, `5m`},
+	`5d`: {`5d`, `30m`},
+	`1m`: {`1mo`, `1d`},
+	`6m`: {`6mo`, `1d`},
+	`1y`: {`1y`, `1d`},
+	`5y`: {`5y`, `1wk`},
+}
+
+// YahooProvider fetches quotes from the Yahoo Finance v7 quote API using the cookie and crumb of its YahooSession.
+type YahooProvider struct {
+	client   *WebClient       // Sends the requests, see WebClient.
+	session  *YahooSession    // Cookie and crumb sent with every request.
+	recorder *SessionRecorder // Receives every response when the session is recorded.
+}
+
+// This function creates a `YahooProvider` sending its requests through the client, whose cookie and crumb are cached in the given file, see YahooSession. Nothing is requested until the first fetch, which signs in when the cache holds no valid credentials.
+func NewYahooProvider(cacheFile string, client *WebClient) *YahooProvider {
+	return &YahooProvider{client: client, session: NewYahooSession(cacheFile, client)}
+}
+
+// This function fetches real time quotes for the given tickers.
+func (provider *YahooProvider) FetchQuotes(ctx context.Context, tickers []string) ([]Stock, error) {
+	return provider.fetch(ctx, sessionQuotes, strings.Join(tickers, `,`), func(crumb string) string {
+		return fmt.Sprintf(yahooQuotesURL, url.QueryEscape(crumb), querySymbols(tickers))
+	})
+}
+
+// This function fetches the index, yield, currency and commodity snapshots shown in the market header.
+func (provider *YahooProvider) FetchMarket(ctx context.Context, symbols []string) ([]Stock, error) {
+	return provider.fetch(ctx, sessionMarket, ``, func(crumb string) string {
+		return fmt.Sprintf(yahooQuotesURL, url.QueryEscape(crumb), querySymbols(symbols)) + yahooQuotesURLQueryParts
+	})
+}
+
+// This function fetches the bars of one ticker from the v8 chart API.
+func (provider *YahooProvider) FetchChart(ctx context.Context, ticker string, period string) ([]Bar, error) {
+	parameters, ok := yahooChartPeriods[period]
+	if !ok {
+		return nil, fmt.Errorf("unsupported chart period %q", period)
+	}
+	body, err := provider.get(ctx, sessionChart, ticker+` `+period, func(crumb string) string {
+		return fmt.Sprintf(yahooChartURL, url.PathEscape(ticker), parameters[0], parameters[1], url.QueryEscape(crumb))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseYahooChart(body)
+}
+
+// This function fetches the fundamentals of one ticker. Most of them come with the v7 quote; beta is only available from the quote summary, which is queried separately and left missing when it fails.
+func (provider *YahooProvider) FetchFundamentals(ctx context.Context, ticker string) (Fundamentals, error) {
+	body, err := provider.get(ctx, sessionFundamentals, ticker, func(crumb string) string {
+		return fmt.Sprintf(yahooQuotesURL, url.QueryEscape(crumb), querySymbols([]string{ticker}))
+	})
+	if err != nil {
+		return Fundamentals{}, err
+	}
+	fundamentals, err := parseYahooFundamentals(body)
+	if err != nil {
+		return fundamentals, err
+	}
+
+	summaryURL := func(crumb string) string {
+		return fmt.Sprintf(yahooSummaryURL, url.PathEscape(ticker), url.QueryEscape(crumb))
+	}
+	if body, err := provider.get(ctx, sessionSummary, ticker, summaryURL); err == nil {
+		fundamentals.Beta = parseYahooBeta(body)
+	}
+
+	return fundamentals, nil
+}
+
+// This function looks up the symbols whose ticker or name matches the query.
+func (provider *YahooProvider) SearchSymbols(ctx context.Context, query string) ([]SymbolMatch, error) {
+	body, err := provider.get(ctx, sessionSearch, query, func(crumb string) string {
+		return fmt.Sprintf(yahooSearchURL, url.QueryEscape(query), url.QueryEscape(crumb))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseYahooSearch(body)
+}
+
+// This function describes the sign-in to Yahoo while it is failing, and returns an empty string otherwise.
+func (provider *YahooProvider) AuthStatus() string {
+	return provider.session.Status()
+}
+
+// This function passes every response received from now on to the recorder, see SessionRecorder.
+func (provider *YahooProvider) Record(recorder *SessionRecorder) {
+	provider.recorder = recorder
+}
+
+// This function escapes each symbol for the query of a URL, e.g. ^GSPC or BRK&B, and joins them with commas.
+func querySymbols(symbols []string) string {
+	escaped := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		escaped[i] = url.QueryEscape(symbol)
+	}
+	return strings.Join(escaped, `,`)
+}
+
+// -----------------------------------------------------------------------------
+func (provider *YahooProvider) fetch(ctx context.Context, kind, key string, url func(crumb string) string) ([]Stock, error) {
+	body, err := provider.get(ctx, kind, key, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseYahooQuotes(body)
+}
+
+// This function requests the URL built with the crumb of the session. When Yahoo rejects the crumb or the cookie, the session signs in again and the request is sent once more.
+func (provider *YahooProvider) get(ctx context.Context, kind, key string, url func(crumb string) string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		cookies, crumb, err := provider.session.Credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		response, body, err := provider.client.Do(ctx, func() (*http.Request, error) {
+			return provider.request(url(crumb), cookies)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if yahooRejected(response.StatusCode, body) {
+			provider.session.Invalidate(crumb)
+			if attempt < 2 {
+				continue
+			}
+			return nil, fmt.Errorf("yahoo rejected the session twice (%d)", response.StatusCode)
+		}
+
+		if provider.recorder != nil {
+			provider.recorder.Record(`yahoo`, kind, key, body)
+		}
+		return body, nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+func (provider *YahooProvider) request(url, cookies string) (*http.Request, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header = http.Header{
+		"Accept":          {"*/*"},
+		"Accept-Language": {"en-US,en;q=0.5"},
+		"Connection":      {"keep-alive"},
+		"Content-Type":    {"application/json"},
+		"Cookie":          {cookies},
+		"Host":            {"query1.finance.yahoo.com"},
+		"Origin":          {"https://finance.yahoo.com"},
+		"Referer":         {"https://finance.yahoo.com"},
+		"Sec-Fetch-Dest":  {"empty"},
+		"Sec-Fetch-Mode":  {"cors"},
+		"Sec-Fetch-Site":  {"same-site"},
+		"TE":              {"trailers"},
+		"User-Agent":      {userAgent},
+	}
+	return request, nil
+}
+
+// This function reports whether Yahoo refused the cookie or the crumb of a request.
+func yahooRejected(status int, body []byte) bool {
+	return status == http.StatusUnauthorized || bytes.Contains(body, []byte(`Invalid Crumb`)) || bytes.Contains(body, []byte(`Invalid Cookie`))
+}
+
+// -----------------------------------------------------------------------------
+func parseYahooQuotes(body []byte) ([]Stock, error) {
+	d := map[string]map[string][]map[string]interface{}{}
+	err := json.Unmarshal(body, &d)
+	if err != nil {
+		return nil, err
+	}
+	results := d["quoteResponse"]["result"]
+
+	stocks := make([]Stock, len(results))
+	for i, result := range results {
+		number := func(key string) Number {
+			value, ok := result[key].(float64)
+			return Number{Value: value, Valid: ok}
+		}
+		integer := func(key string) Integer {
+			value, ok := result[key].(float64)
+			return Integer{Value: int64(value), Valid: ok}
+		}
+
+		stocks[i].Ticker, _ = result["symbol"].(string)
+		stocks[i].LastTrade = number("regularMarketPrice")
+		stocks[i].Change = number("regularMarketChange")
+		stocks[i].ChangePct = number("regularMarketChangePercent")
+		stocks[i].Open = number("regularMarketOpen")
+		stocks[i].Low = number("regularMarketDayLow")
+		stocks[i].High = number("regularMarketDayHigh")
+		stocks[i].Low52 = number("fiftyTwoWeekLow")
+		stocks[i].High52 = number("fiftyTwoWeekHigh")
+		stocks[i].Volume = integer("regularMarketVolume")
+		stocks[i].AvgVolume = integer("averageDailyVolume10Day")
+		stocks[i].PeRatio = number("trailingPE")
+		stocks[i].PeRatioX = number("trailingPE")
+		stocks[i].Dividend = number("trailingAnnualDividendRate")
+		stocks[i].Yield = number("trailingAnnualDividendYield")
+		stocks[i].MarketCap = number("marketCap")
+		stocks[i].MarketCapX = number("marketCap")
+		stocks[i].Currency, _ = result["currency"].(string)
+		stocks[i].PreOpen = number("preMarketChangePercent")
+		stocks[i].AfterHours = number("postMarketChangePercent")
+		stocks[i].Direction = 0
+		if stocks[i].Change.Valid {
+			if stocks[i].Change.Value < 0.0 {
+				stocks[i].Direction = -1
+			} else if stocks[i].Change.Value > 0.0 {
+				stocks[i].Direction = 1
+			}
+		}
+	}
+	return stocks, nil
+}
+
+// -----------------------------------------------------------------------------
+func parseYahooFundamentals(body []byte) (Fundamentals, error) {
+	d := map[string]map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return Fundamentals{}, err
+	}
+	results := d["quoteResponse"]["result"]
+	if len(results) == 0 {
+		return Fundamentals{}, fmt.Errorf("no quote returned")
+	}
+
+	result := results[0]
+	number := func(key string) Number {
+		value, ok := result[key].(float64)
+		return Number{Value: value, Valid: ok}
+	}
+	integer := func(key string) Integer {
+		value, ok := result[key].(float64)
+		return Integer{Value: int64(value), Valid: ok}
+	}
+
+	fundamentals := Fundamentals{
+		EPS:                  number("epsTrailingTwelveMonths"),
+		ForwardPE:            number("forwardPE"),
+		SharesOutstanding:    integer("sharesOutstanding"),
+		FiftyDayAverage:      number("fiftyDayAverage"),
+		TwoHundredDayAverage: number("twoHundredDayAverage"),
+		Bid:                  number("bid"),
+		Ask:                  number("ask"),
+		BidSize:              integer("bidSize"),
+		AskSize:              integer("askSize"),
+	}
+	fundamentals.Exchange, _ = result["fullExchangeName"].(string)
+	if seconds, ok := result["earningsTimestamp"].(float64); ok {
+		fundamentals.EarningsDate = time.Unix(int64(seconds), 0)
+	}
+
+	return fundamentals, nil
+}
+
+// -----------------------------------------------------------------------------
+func parseYahooBeta(body []byte) Number {
+	var response struct {
+		QuoteSummary struct {
+			Result []struct {
+				SummaryDetail struct {
+					Beta struct {
+						Raw *float64
+					}
+				}
+			}
+		}
+	}
+	if json.Unmarshal(body, &response) != nil || len(response.QuoteSummary.Result) == 0 {
+		return Number{}
+	}
+	if beta := response.QuoteSummary.Result[0].SummaryDetail.Beta.Raw; beta != nil {
+		return Number{Value: *beta, Valid: true}
+	}
+	return Number{}
+}
+
+// -----------------------------------------------------------------------------
+func parseYahooSearch(body []byte) ([]SymbolMatch, error) {
+	var response struct {
+		Quotes []struct {
+			Symbol    string
+			ShortName string
+			LongName  string
+			ExchDisp  string
+			TypeDisp  string
+		}
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	matches := make([]SymbolMatch, 0, len(response.Quotes))
+	for _, quote := range response.Quotes {
+		name := quote.LongName
+		if name == `` {
+			name = quote.ShortName
+		}
+		if quote.Symbol != `` {
+			matches = append(matches, SymbolMatch{Symbol: quote.Symbol, Name: name, Exchange: quote.ExchDisp, Type: quote.TypeDisp})
+		}
+	}
+	return matches, nil
+}
+
+// -----------------------------------------------------------------------------
+func parseYahooChart(body []byte) ([]Bar, error) {
+	var response struct {
+		Chart struct {
+			Result []struct {
+				Timestamp  []int64
+				Indicators struct {
+					Quote []struct {
+						Open   []*float64
+						High   []*float64
+						Low    []*float64
+						Close  []*float64
+						Volume []*float64
+					}
+				}
+			}
+			Error *struct {
+				Code        string
+				Description string
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Chart.Error != nil {
+		return nil, fmt.Errorf("%s: %s", response.Chart.Error.Code, response.Chart.Error.Description)
+	}
+	if len(response.Chart.Result) == 0 || len(response.Chart.Result[0].Indicators.Quote) == 0 {
+		return nil, nil
+	}
+
+	result := response.Chart.Result[0]
+	quote := result.Indicators.Quote[0]
+	value := func(series []*float64, i int) (float64, bool) {
+		if i < len(series) && series[i] != nil {
+			return *series[i], true
+		}
+		return 0, false
+	}
+
+	bars := make([]Bar, 0, len(result.Timestamp))
+	for i, timestamp := range result.Timestamp {
+		bar := Bar{Time: time.Unix(timestamp, 0)}
+		var ok [4]bool
+		bar.Open, ok[0] = value(quote.Open, i)
+		bar.High, ok[1] = value(quote.High, i)
+		bar.Low, ok[2] = value(quote.Low, i)
+		bar.Close, ok[3] = value(quote.Close, i)
+		if !ok[0] || !ok[1] || !ok[2] || !ok[3] {
+			continue
+		}
+		volume, _ := value(quote.Volume, i)
+		bar.Volume = int64(volume)
+		bars = append(bars, bar)
+	}
+
+	return bars, nil
+}
@@ -0,0 +1,188 @@
+package mop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Yahoo only answers requests carrying the A1 cookie of a browser session and the crumb issued for it, both
+obtained by the handshake in DataCrumb.go. The `YahooSession` keeps them in a cache file, so that PrediStock
+does not sign in again at every start, and renews them once they expire or as soon as Yahoo rejects them
+with a 401 or an "Invalid Crumb" error. A failed sign-in does not stop the program: it is retried with an
+exponential back-off, from 5 seconds up to 5 minutes, and reported by `Status` until it succeeds.
+*/
+
+// How long cached credentials are used before signing in again.
+const yahooSessionLifetime = 24 * time.Hour
+
+// Bounds of the back-off between failed sign-ins.
+const yahooRetryMin = 5 * time.Second
+const yahooRetryMax = 5 * time.Minute
+
+// yahooCredentials is what the cache file holds.
+type yahooCredentials struct {
+	Cookies string    `json:"cookies"`
+	Crumb   string    `json:"crumb"`
+	Expires time.Time `json:"expires"`
+}
+
+// YahooSession owns the cookie and crumb of the Yahoo provider.
+type YahooSession struct {
+	filename    string           // Cache file, none when empty.
+	credentials yahooCredentials // Current credentials, empty when signed out.
+	err         error            // Last sign-in failure, nil once signed in.
+	failures    int              // Consecutive sign-in failures.
+	retryAt     time.Time        // No sign-in is attempted before then.
+	signingIn   chan struct{}    // Closed once the sign-in in progress ends, nil when there is none.
+	mutex       sync.Mutex       // Guards the fields above, which are used by overlapping fetches. It is not held while signing in.
+	login       func(ctx context.Context) (yahooCredentials, error)
+}
+
+// This function creates a session signing in through the client and cached in the given file, reading the credentials it holds. An empty file name disables the cache.
+func NewYahooSession(filename string, client *WebClient) *YahooSession {
+	session := &YahooSession{filename: filename}
+	session.login = func(ctx context.Context) (yahooCredentials, error) { return yahooLogin(ctx, client) }
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		json.Unmarshal(data, &session.credentials)
+	}
+	return session
+}
+
+// This function returns the cookie and crumb to send, signing in first when there are none or they expired. During the back-off after a failed sign-in, expired credentials are still returned, and an error when there are none.
+func (session *YahooSession) Credentials(ctx context.Context) (cookies, crumb string, err error) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	// Overlapping fetches wait for the sign-in in progress instead of signing in again.
+	for session.signingIn != nil {
+		signingIn := session.signingIn
+		session.mutex.Unlock()
+		select {
+		case <-signingIn:
+		case <-ctx.Done():
+			session.mutex.Lock()
+			return ``, ``, ctx.Err()
+		}
+		session.mutex.Lock()
+	}
+
+	now := time.Now()
+	current := session.credentials
+	if current.Crumb != `` && now.Before(current.Expires) {
+		return current.Cookies, current.Crumb, nil
+	}
+	if now.Before(session.retryAt) {
+		if current.Crumb != `` {
+			return current.Cookies, current.Crumb, nil
+		}
+		return ``, ``, session.failure(now)
+	}
+
+	// The lock is released during the handshake, so that Status and Invalidate never wait on the network.
+	signingIn := make(chan struct{})
+	session.signingIn = signingIn
+	session.mutex.Unlock()
+	renewed, err := session.login(ctx)
+	session.mutex.Lock()
+	session.signingIn = nil
+	close(signingIn)
+
+	// A sign-in cancelled because its fetch is no longer wanted says nothing about Yahoo: the next fetch signs in again right away.
+	if ctx.Err() != nil {
+		return ``, ``, ctx.Err()
+	}
+	if err != nil {
+		session.err = err
+		session.failures++
+		wait := yahooRetryMax
+		if session.failures <= 6 {
+			wait = yahooRetryMin << uint(session.failures-1)
+		}
+		session.retryAt = now.Add(wait)
+		if current.Crumb != `` {
+			return current.Cookies, current.Crumb, nil
+		}
+		return ``, ``, session.failure(now)
+	}
+
+	renewed.Expires = now.Add(yahooSessionLifetime)
+	session.credentials = renewed
+	session.err, session.failures, session.retryAt = nil, 0, time.Time{}
+	session.save()
+
+	return renewed.Cookies, renewed.Crumb, nil
+}
+
+// This function drops the credentials after Yahoo rejected the given crumb, so that the next request signs in again. Credentials renewed in the meantime by an overlapping fetch are kept.
+func (session *YahooSession) Invalidate(crumb string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.credentials.Crumb == crumb {
+		session.credentials = yahooCredentials{}
+		session.retryAt = time.Time{}
+		session.save()
+	}
+}
+
+// This function describes the sign-in while it is failing, and returns an empty string otherwise.
+func (session *YahooSession) Status() string {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.err == nil {
+		return ``
+	}
+	return session.failure(time.Now()).Error()
+}
+
+// -----------------------------------------------------------------------------
+func (session *YahooSession) failure(now time.Time) error {
+	wait := session.retryAt.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return fmt.Errorf("yahoo sign-in failed, retrying in %s: %v", wait.Round(time.Second), session.err)
+}
+
+// -----------------------------------------------------------------------------
+func (session *YahooSession) save() {
+	if session.filename == `` {
+		return
+	}
+	if session.credentials.Crumb == `` {
+		os.Remove(session.filename)
+		return
+	}
+	data, err := json.Marshal(session.credentials)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(session.filename), 0755) == nil {
+		ioutil.WriteFile(session.filename, data, 0600)
+	}
+}
+
+// This function performs the cookie and crumb handshake of DataCrumb.go.
+func yahooLogin(ctx context.Context, client *WebClient) (yahooCredentials, error) {
+	cookies, err := fetchCookies(ctx, client)
+	if err != nil {
+		return yahooCredentials{}, err
+	}
+	crumb, err := fetchCrumb(ctx, client, cookies)
+	if err != nil {
+		return yahooCredentials{}, err
+	}
+	if crumb == `` {
+		return yahooCredentials{}, fmt.Errorf("no crumb received")
+	}
+
+	return yahooCredentials{Cookies: cookies, Crumb: crumb}, nil
+}
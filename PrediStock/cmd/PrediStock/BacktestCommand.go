@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mop-tracker/mop"
+)
+
+const backtestUsage = `Usage: PrediStock [-profile path] backtest [options] FILE.csv...
+
+Replays the daily OHLCV bars of every CSV file day by day, refitting each model on
+the data available at that point, and reports its accuracy and the result of a
+long/flat strategy driven by its predictions against buying and holding.
+
+Options:
+`
+
+// The backtestCommand function implements `PrediStock backtest`. The results table is printed with the profile's colors, and `-json` additionally writes the machine readable report to a file (or to stdout instead of the table when the file is `-`).
+func backtestCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`backtest`, flag.ExitOnError)
+	models := flags.String(`models`, strings.Join(mop.ForecasterNames(), `,`), `comma separated list of models`)
+	warmup := flags.Int(`warmup`, 60, `number of bars used for training before the first prediction`)
+	report := flags.String(`json`, ``, "file to write the JSON report to, `-` for stdout")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, backtestUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var forecasters []mop.Forecaster
+	for _, name := range strings.Split(*models, `,`) {
+		model, err := mop.NewForecaster(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		forecasters = append(forecasters, model)
+	}
+
+	status := 0
+	results := mop.BacktestReport{Generated: time.Now(), Warmup: *warmup}
+	for _, filename := range flags.Args() {
+		ticker, bars, err := mop.LoadBars(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, model := range forecasters {
+			result, err := mop.Backtest(ticker, bars, model, *warmup)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+				continue
+			}
+			results.Results = append(results.Results, result)
+		}
+	}
+
+	if *report != `` {
+		data, err := json.MarshalIndent(results, ``, `    `)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *report == `-` {
+			fmt.Println(string(data))
+			return status
+		}
+		if err := ioutil.WriteFile(*report, data, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	markup := mop.NewMarkup(profile)
+	fmt.Print(markup.Render(mop.FormatBacktest(results.Results), isTerminal(os.Stdout)))
+
+	return status
+}
@@ -16,39 +16,66 @@ import (
 
 const defaultProfile = `.moprc`
 
+// Subcommands run instead of the interactive screen when their name follows the global flags.
+var commands = map[string]func(profileName string, args []string) int{
+	`predict`:  predictCommand,
+	`backtest`: backtestCommand,
+	`compact`:  compactCommand,
+	`ledger`:   ledgerCommand,
+	`gains`:    gainsCommand,
+	`quote`:    quoteCommand,
+	`serve`:    serveCommand,
+	`stream`:   streamCommand,
+}
+
 const help = `
 <u>Command</u>    <u>Description                                </u>
    +                  Add stocks to list
-   -                  Remove stocks from list
+   -                  Remove stocks from list (the selected one by default)
    ? h H              Display this help screen
+   a                  Add an alert for the selected stock, e.g. last > 200
+   A                  Remove the alerts of the selected stock
+   c Enter            Show the price chart of the selected stock
+   C                  Show the price chart of another ticker
+   d D                Show the details of the selected stock
    f                  Set filtering expression
+   l                  Add, replace or remove a lot of the selected stock
+   L                  Remove the holdings of the selected stock
    F                  Unset filtering expression
    g G                Group stocks by advancing/declining issues
    o                  Change column sort order
    p P                Pause market data and stock updates
+   s S                Toggle intraday sparkline column on/off
    t                  Toggle timestamp on/off
+   v V                Toggle holdings value and P&L columns on/off
+   w                  Switch to the next watchlist
+   W                  Create, rename or delete a watchlist
    Mouse Scroll       Scroll up/down
+   Mouse Click        Select stock
    PgUp/PgDn          Scroll up/down
-   Up/Down arrows     Scroll up
-   j J                Scroll up
-   k K                Scroll down
+   Up/Down arrows     Move selection up/down
+   k j                Move selection up/down
+   K J                Scroll up/down
    q esc              Quit mop
 
-Enter comma-delimited list of stock tickers when prompted.
+Enter comma-delimited list of stock tickers when prompted. While adding
+tickers, matching symbols are shown as you type; Tab completes the first.
 
 <r> Press any key to continue </r>
 `
 
 // The mainLoop method is responsible for initiating the event loop in a terminal-based application, managing user input through keyboard and mouse and periodically updating data. The profile's intervals for updating market data, quotes, and timestamps are specified by the timers. Screen rendering, market and quote data generation, as well as asynchronous keyboard input in sane goroutine are also handled by the function. Flags are employed to manage display actions like offering help or halting updates.either way.
-func mainLoop(screen *mop.Screen, profile *mop.Profile) {
+func mainLoop(screen *mop.Screen, profile *mop.Profile, provider mop.QuoteProvider, speed float64) {
 	var lineEditor *mop.LineEditor
 	var columnEditor *mop.ColumnEditor
+	var chartView *mop.ChartView
+	var detailPane *mop.DetailPane
 	termbox.SetInputMode(termbox.InputMouse)
 	keyboardQueue := make(chan termbox.Event, 128)
 
 	timestampQueue := time.NewTicker(1 * time.Second)
-	quotesQueue := time.NewTicker(time.Duration(profile.QuotesRefresh) * time.Second)
-	marketQueue := time.NewTicker(time.Duration(profile.MarketRefresh) * time.Second)
+	quotesQueue := time.NewTicker(time.Duration(float64(profile.QuotesRefresh) * float64(time.Second) / speed))
+	marketQueue := time.NewTicker(time.Duration(float64(profile.MarketRefresh) * float64(time.Second) / speed))
 	showingHelp := false
 	paused := false
 	showingTimestamp := profile.ShowTimestamp
@@ -62,8 +89,9 @@ func mainLoop(screen *mop.Screen, profile *mop.Profile) {
 		}
 	}()
 
-	market := mop.NewMarket()
+	market := mop.NewMarket(provider, profile)
 	quotes := mop.NewQuotes(market, profile)
+	defer quotes.CloseStream()
 	screen.Draw(market)
 	screen.Draw(quotes)
 
@@ -74,7 +102,7 @@ loop:
 		case event := <-keyboardQueue:
 			switch event.Type {
 			case termbox.EventKey:
-				if lineEditor == nil && columnEditor == nil && !showingHelp {
+				if lineEditor == nil && columnEditor == nil && chartView == nil && detailPane == nil && !showingHelp {
 					if event.Key == termbox.KeyEsc || event.Ch == 'q' || event.Ch == 'Q' {
 						break loop
 					} else if event.Ch == '+' || event.Ch == '-' {
@@ -83,6 +111,50 @@ loop:
 					} else if event.Ch == 'f' {
 						lineEditor = mop.NewLineEditor(screen, quotes)
 						lineEditor.Prompt(event.Ch)
+					} else if event.Ch == 'c' || event.Key == termbox.KeyEnter {
+						if stock, ok := quotes.Selected(); ok {
+							chartView = mop.NewChartView(screen, provider, stock.Ticker)
+						} else {
+							lineEditor = mop.NewLineEditor(screen, quotes)
+							lineEditor.Prompt('c')
+						}
+					} else if event.Ch == 'd' || event.Ch == 'D' {
+						if stock, ok := quotes.Selected(); ok {
+							detailPane = mop.NewDetailPane(screen, provider, stock)
+						}
+					} else if event.Ch == 'C' {
+						lineEditor = mop.NewLineEditor(screen, quotes)
+						lineEditor.Prompt('c')
+					} else if event.Ch == 'a' {
+						if _, ok := quotes.Selected(); ok {
+							lineEditor = mop.NewLineEditor(screen, quotes)
+							lineEditor.Prompt(event.Ch)
+						}
+					} else if event.Ch == 'A' {
+						if stock, ok := quotes.Selected(); ok {
+							profile.RemoveAlerts(stock.Ticker)
+						}
+					} else if event.Ch == 'l' {
+						if _, ok := quotes.Selected(); ok {
+							lineEditor = mop.NewLineEditor(screen, quotes)
+							lineEditor.Prompt(event.Ch)
+						}
+					} else if event.Ch == 'L' {
+						if stock, ok := quotes.Selected(); ok && profile.RemoveLots(stock.Ticker) == nil {
+							screen.Draw(quotes)
+						}
+					} else if event.Ch == 'v' || event.Ch == 'V' {
+						if profile.ToggleHoldings() == nil {
+							screen.Clear().Draw(market, quotes)
+						}
+					} else if event.Ch == 'w' {
+						if quotes.NextWatchlist() == nil {
+							screen.Clear().Draw(market, quotes)
+							screen.DrawStatus(quotes)
+						}
+					} else if event.Ch == 'W' {
+						lineEditor = mop.NewLineEditor(screen, quotes)
+						lineEditor.Prompt('w')
 					} else if event.Ch == 'F' {
 						profile.SetFilter("")
 					} else if event.Ch == 'o' || event.Ch == 'O' {
@@ -106,10 +178,10 @@ loop:
 						screen.DecreaseOffset(upDownJump)
 						redrawQuotesFlag = true
 					} else if event.Key == termbox.KeyArrowUp || event.Ch == 'k' {
-						screen.DecreaseOffset(1)
+						screen.MoveSelection(profile, -1)
 						redrawQuotesFlag = true
 					} else if event.Key == termbox.KeyArrowDown || event.Ch == 'j' {
-						screen.IncreaseOffset(1)
+						screen.MoveSelection(profile, 1)
 						redrawQuotesFlag = true
 					} else if event.Key == termbox.KeyHome {
 						screen.ScrollTop()
@@ -117,6 +189,10 @@ loop:
 					} else if event.Key == termbox.KeyEnd {
 						screen.ScrollBottom()
 						redrawQuotesFlag = true
+					} else if event.Ch == 's' || event.Ch == 'S' {
+						if profile.ToggleSparkline() == nil {
+							screen.Clear().Draw(market, quotes)
+						}
 					} else if event.Ch == 't' || event.Ch == 'T' {
 						if profile.ToggleTimestamp() == nil {
 							showingTimestamp = !showingTimestamp
@@ -125,26 +201,43 @@ loop:
 					}
 				} else if lineEditor != nil {
 					if done := lineEditor.Handle(event); done {
+						if ticker := lineEditor.ChartTicker(); ticker != `` {
+							chartView = mop.NewChartView(screen, provider, ticker)
+						}
 						lineEditor = nil
 					}
 				} else if columnEditor != nil {
 					if done := columnEditor.Handle(event); done {
 						columnEditor = nil
 					}
+				} else if chartView != nil {
+					if done := chartView.Handle(event); done {
+						chartView = nil
+						screen.Clear().Draw(market, quotes)
+					}
+				} else if detailPane != nil {
+					if done := detailPane.Handle(event); done {
+						detailPane = nil
+						screen.Clear().Draw(market, quotes)
+					}
 				} else if showingHelp {
 					showingHelp = false
 					screen.Clear().Draw(market, quotes)
 				}
 			case termbox.EventResize:
 				screen.Resize()
-				if !showingHelp {
+				if chartView != nil {
+					chartView.Draw()
+				} else if detailPane != nil {
+					detailPane.Draw()
+				} else if !showingHelp {
 					redrawQuotesFlag = true
 					redrawMarketFlag = true
 				} else {
 					screen.Draw(help)
 				}
 			case termbox.EventMouse:
-				if lineEditor == nil && columnEditor == nil && !showingHelp {
+				if lineEditor == nil && columnEditor == nil && chartView == nil && detailPane == nil && !showingHelp {
 					switch event.Key {
 					case termbox.MouseWheelUp:
 						screen.DecreaseOffset(5)
@@ -152,35 +245,86 @@ loop:
 					case termbox.MouseWheelDown:
 						screen.IncreaseOffset(5)
 						redrawQuotesFlag = true
+					case termbox.MouseLeft:
+						redrawQuotesFlag = screen.SelectAt(profile, event.MouseY)
 					}
 				}
 			}
 
+		case result := <-chartView.Fetched():
+			result.Apply()
+
+		case result := <-detailPane.Fetched():
+			result.Apply()
+
+		case result := <-lineEditor.Searched():
+			result.Apply()
+			if lineEditor.Closed() {
+				lineEditor = nil
+			}
+
 		case <-timestampQueue.C:
-			if !showingHelp && !paused && showingTimestamp {
+			if !showingHelp && chartView == nil && detailPane == nil && !paused && showingTimestamp {
 				screen.Draw(time.Now())
 			}
 
 		case <-quotesQueue.C:
-			if !showingHelp && !paused && len(keyboardQueue) == 0 {
-				go quotes.Fetch()
+			if !showingHelp && chartView == nil && detailPane == nil && !paused && len(keyboardQueue) == 0 && quotes.Polling() {
+				quotes.Refresh()
+			}
+
+		case result := <-quotes.Fetched():
+			result.Apply()
+			if !showingHelp && chartView == nil && detailPane == nil && !paused {
 				redrawQuotesFlag = true
 			}
 
+		case delta, ok := <-quotes.Updates():
+			if !ok {
+				quotes.CloseStream()
+				break
+			}
+			deltas := []mop.QuoteDelta{delta}
+			for pending := len(quotes.Updates()); pending > 0; pending-- {
+				deltas = append(deltas, <-quotes.Updates())
+			}
+			changed := quotes.Merge(deltas)
+			if len(changed) > 0 && !showingHelp && chartView == nil && detailPane == nil && !paused {
+				screen.DrawChanged(quotes, changed)
+				if lineEditor == nil {
+					screen.DrawStatus(quotes)
+				}
+			}
+
 		case <-marketQueue.C:
-			if !showingHelp && !paused {
-				screen.Draw(market)
+			if !showingHelp && chartView == nil && detailPane == nil && !paused {
+				market.Refresh()
+			}
+
+		case result := <-market.Fetched():
+			result.Apply()
+			if !showingHelp && chartView == nil && detailPane == nil && !paused {
+				redrawMarketFlag = true
 			}
 		}
 
 		if redrawQuotesFlag && len(keyboardQueue) == 0 {
 			screen.DrawOldQuotes(quotes)
+			if lineEditor == nil {
+				screen.DrawStatus(quotes)
+			}
 			redrawQuotesFlag = false
 		}
 		if redrawMarketFlag && len(keyboardQueue) == 0 {
-			screen.Draw(market)
+			screen.DrawOldMarket(market)
+			if lineEditor == nil {
+				screen.DrawStatus(quotes)
+			}
 			redrawMarketFlag = false
 		}
+		if quotes.AlertBell() {
+			screen.Bell()
+		}
 	}
 }
 
@@ -192,7 +336,23 @@ func main() {
 	}
 
 	profileName := flag.String("profile", path.Join(usr.HomeDir, defaultProfile), "path to profile")
+	record := flag.String("record", "", "record the responses of the quote provider to a session file")
+	replay := flag.String("replay", "", "replay a recorded session file instead of fetching quotes")
+	speed := flag.Float64("speed", 1, "speed of the replay, 10 plays a minute in 6 seconds")
 	flag.Parse()
+	if *speed <= 0 {
+		fmt.Fprintln(os.Stderr, "The replay speed must be positive")
+		os.Exit(2)
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command `%s`\n", flag.Arg(0))
+			os.Exit(2)
+		}
+		os.Exit(command(*profileName, flag.Args()[1:]))
+	}
 
 	profile, err := mop.NewProfile(*profileName)
 	if err != nil {
@@ -214,9 +374,74 @@ func main() {
 			}
 		}
 	}
+	var provider mop.QuoteProvider
+	if *replay != "" {
+		provider, err = replayProvider(profile, *replay, *speed)
+	} else {
+		provider, err = mop.NewQuoteProvider(profile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to initialize quote provider.\n\tError: %s\n", err)
+		os.Exit(1)
+	}
+	if *replay == "" {
+		*speed = 1
+	}
+	if *record != "" {
+		recorder, err := recordProvider(provider, *record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to record the session.\n\tError: %s\n", err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+	}
+
 	screen := mop.NewScreen(profile)
 	defer screen.Close()
 
-	mainLoop(screen, profile)
+	mainLoop(screen, profile, provider, *speed)
 	profile.Save()
 }
+
+// The replayProvider function loads a recorded session for `-replay`. The profile is not saved and the local store records nothing while replaying, so that the replayed quotes do not end up among the live ones.
+func replayProvider(profile *mop.Profile, filename string, speed float64) (mop.QuoteProvider, error) {
+	responses, err := mop.LoadSession(filename)
+	if err != nil {
+		return nil, err
+	}
+	profile.ReadOnly()
+	profile.NoStore = true
+
+	return mop.NewReplayProvider(responses, speed), nil
+}
+
+// The recordProvider function makes the provider record its responses to the session file for `-record`.
+func recordProvider(provider mop.QuoteProvider, filename string) (*mop.SessionRecorder, error) {
+	recording, ok := provider.(mop.RecordingProvider)
+	if !ok {
+		return nil, fmt.Errorf("the quote provider cannot record its responses")
+	}
+	recorder, err := mop.NewSessionRecorder(filename)
+	if err != nil {
+		return nil, err
+	}
+	recording.Record(recorder)
+
+	return recorder, nil
+}
+
+// The loadProfile function reads the profile for a subcommand. Unlike the interactive screen it never offers to overwrite a corrupted profile, it reports the error and lets the command fail instead.
+func loadProfile(profileName string) (*mop.Profile, bool) {
+	profile, err := mop.NewProfile(profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The profile read from `%s` is corrupted.\n\tError: %s\n", profileName, err)
+		return nil, false
+	}
+	return profile, true
+}
+
+// The isTerminal function reports whether the file is attached to a terminal, in which case markup is printed in color.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
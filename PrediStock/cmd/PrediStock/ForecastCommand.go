@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mop-tracker/mop"
+)
+
+const predictUsage = `Usage: PrediStock [-profile path] predict [options] FILE.csv...
+
+Reads daily OHLCV bars from one CSV file per ticker (the ticker is the file name)
+and writes predicted closes with confidence bands as CSV.
+
+Options:
+`
+
+// The predictCommand function implements `PrediStock predict`. Every model listed in `-models` is fitted on every input file and the predictions for the next `-days` trading days are written as CSV to `-output` or stdout. Files or models that fail are reported on stderr and make the command exit with status 1 while the remaining predictions are still written.
+func predictCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`predict`, flag.ExitOnError)
+	days := flags.Int(`days`, 5, `number of trading days to predict`)
+	models := flags.String(`models`, strings.Join(mop.ForecasterNames(), `,`), `comma separated list of models`)
+	confidence := flags.Float64(`confidence`, 0.95, `confidence level of the prediction bands`)
+	output := flags.String(`output`, ``, `CSV file to write the predictions to (default stdout)`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, predictUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || *days < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	var forecasts []mop.Forecast
+	for _, filename := range flags.Args() {
+		ticker, bars, err := mop.LoadBars(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, name := range strings.Split(*models, `,`) {
+			model, err := mop.NewForecaster(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			if err := model.Fit(bars); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", ticker, err)
+				status = 1
+				continue
+			}
+			forecasts = append(forecasts, mop.Forecast{
+				Ticker:      ticker,
+				Model:       model.Name(),
+				Predictions: model.Forecast(*days, *confidence),
+			})
+		}
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != `` {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := mop.WriteForecasts(writer, forecasts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return status
+}
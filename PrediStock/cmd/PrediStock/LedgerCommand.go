@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mop-tracker/mop"
+)
+
+const ledgerUsage = `Usage: PrediStock [-profile path] ledger [options] [buy|sell TICKER QUANTITY PRICE]
+       PrediStock [-profile path] ledger [options] dividend TICKER AMOUNT
+       PrediStock [-profile path] ledger [options] split TICKER RATIO
+
+Records a transaction in the ledger kept next to the profile. Without a
+transaction, lists the lots still open. Tickers bought are added to the main
+list of the profile.
+
+Options:
+`
+
+const gainsUsage = `Usage: PrediStock [-profile path] gains [options]
+
+Replays the ledger and reports the realised gains and dividends per year and
+ticker.
+
+Options:
+`
+
+// The ledgerCommand function implements `PrediStock ledger`, which appends a transaction to the ledger file of the profile, or prints the open lots when no transaction is given.
+func ledgerCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`ledger`, flag.ExitOnError)
+	date := flags.String(`date`, time.Now().Format(`2006-01-02`), `date of the transaction`)
+	fees := flags.Float64(`fees`, 0, `fees paid for the transaction`)
+	lot := flags.String(`lot`, ``, `name of the lot opened by a buy or closed by a sell`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, ledgerUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		return printOpenLots(profile.LedgerPath())
+	}
+
+	transaction := mop.Transaction{Type: strings.ToLower(flags.Arg(0)), Fees: *fees, Lot: *lot}
+	values := flags.Args()[1:]
+	arguments := map[string]int{`buy`: 3, `sell`: 3, `dividend`: 2, `split`: 2}
+	if arguments[transaction.Type] == 0 || len(values) != arguments[transaction.Type] {
+		flags.Usage()
+		return 2
+	}
+
+	var err error
+	if transaction.Date, err = time.Parse(`2006-01-02`, *date); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid date %q\n", *date)
+		return 2
+	}
+	transaction.Ticker = strings.ToUpper(values[0])
+	numbers := make([]float64, len(values)-1)
+	for i, value := range values[1:] {
+		if numbers[i], err = strconv.ParseFloat(value, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid number %q\n", value)
+			return 2
+		}
+	}
+	switch transaction.Type {
+	case `buy`, `sell`:
+		transaction.Quantity, transaction.Price = numbers[0], numbers[1]
+	case `dividend`:
+		transaction.Price = numbers[0]
+	case `split`:
+		transaction.Quantity = numbers[0]
+	}
+
+	transactions, err := mop.LoadLedger(profile.LedgerPath())
+	if err == nil {
+		_, err = mop.ReplayLedger(append(transactions, transaction), `fifo`)
+	}
+	if err == nil {
+		err = mop.AppendTransaction(profile.LedgerPath(), transaction)
+	}
+	if err == nil && transaction.Type == `buy` {
+		_, err = profile.AddMainTickers([]string{transaction.Ticker})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// The gainsCommand function implements `PrediStock gains`. The report is printed with the profile's colors, and `-json` additionally writes every matched lot to a file (or to stdout instead of the table when the file is `-`).
+func gainsCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`gains`, flag.ExitOnError)
+	method := flags.String(`method`, `fifo`, `lot matching method: `+strings.Join(mop.LedgerMethods, `, `))
+	year := flags.Int(`year`, 0, `only report the given year`)
+	report := flags.String(`json`, ``, "file to write the matched lots to as JSON, `-` for stdout")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, gainsUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	transactions, err := mop.LoadLedger(profile.LedgerPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	result, err := mop.ReplayLedger(transactions, *method)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *report != `` {
+		data, err := json.MarshalIndent(result.Gains, ``, `    `)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *report == `-` {
+			fmt.Println(string(data))
+			return 0
+		}
+		if err := ioutil.WriteFile(*report, data, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	markup := mop.NewMarkup(profile)
+	fmt.Print(markup.Render(mop.FormatGains(result, *year), isTerminal(os.Stdout)))
+
+	return 0
+}
+
+// The printOpenLots function lists the lots of the ledger that are still open, matched first in first out.
+func printOpenLots(filename string) int {
+	transactions, err := mop.LoadLedger(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	result, err := mop.ReplayLedger(transactions, `fifo`)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tickers := make([]string, 0, len(result.Open))
+	for ticker := range result.Open {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	for _, ticker := range tickers {
+		for _, lot := range result.Open[ticker] {
+			fmt.Printf("%-9s %-16s %-10s %12.4f @ %.4f\n", ticker, lot.ID, lot.Date, lot.Quantity, lot.Price)
+		}
+	}
+
+	return 0
+}
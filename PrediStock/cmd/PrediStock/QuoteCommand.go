@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mop-tracker/mop"
+)
+
+const quoteUsage = `Usage: PrediStock [-profile path] quote [options] [TICKER...]
+
+Fetches the quotes once and prints them without starting the terminal UI,
+filtered and sorted like the quotes table. Without tickers, the tickers of
+the profile (or of -watchlist) are quoted. The profile is not modified.
+
+Exit status is 0 on success, 1 when the quotes could not be fetched, 2 on
+usage errors and 3 when some tickers returned no quote or the market data
+used for currency conversion could not be fetched.
+
+Options:
+`
+
+// Output formats of `PrediStock quote`.
+var quoteFormats = []string{`table`, `json`, `csv`}
+
+// The quoteCommand function implements `PrediStock quote`, a one-shot snapshot of the quotes for scripts and cron jobs. Options may be given before or after the tickers. Alerts, the bell and the local store are disabled so that nothing but the quotes is written to stdout, and the quote stream is not opened, as the command exits after one fetch.
+func quoteCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`quote`, flag.ExitOnError)
+	format := flags.String(`format`, `table`, `output format: `+strings.Join(quoteFormats, `, `))
+	watchlist := flags.String(`watchlist`, ``, `quote the tickers of the named watchlist`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, quoteUsage)
+		flags.PrintDefaults()
+	}
+
+	var tickers []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		for _, ticker := range strings.Split(flags.Arg(0), `,`) {
+			if ticker = strings.ToUpper(strings.TrimSpace(ticker)); ticker != `` {
+				tickers = append(tickers, ticker)
+			}
+		}
+		args = flags.Args()[1:]
+	}
+	if *format != `table` && *format != `json` && *format != `csv` {
+		flags.Usage()
+		return 2
+	}
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	profile.ReadOnly()
+	if *watchlist != `` {
+		if err := profile.SelectWatchlist(*watchlist); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if len(tickers) > 0 {
+		profile.Tickers = tickers
+	}
+	if len(profile.Tickers) == 0 {
+		fmt.Fprintln(os.Stderr, `No tickers to quote`)
+		return 2
+	}
+	profile.Alerts, profile.AlertBell, profile.NoStore, profile.StreamURL = nil, false, true, ``
+
+	provider, err := mop.NewQuoteProvider(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to initialize quote provider.\n\tError: %s\n", err)
+		return 1
+	}
+	market := mop.NewMarket(provider, profile)
+	quotes := mop.NewQuotes(market, profile)
+
+	status := 0
+	if ok, err := market.Fetch().Ok(); !ok {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err))
+		status = 3
+	}
+	if ok, err := quotes.Fetch().Ok(); !ok {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err))
+		return 1
+	}
+
+	layout := mop.NewLayout()
+	stocks := layout.Stocks(quotes)
+	quoted := make(map[string]bool, len(profile.Tickers))
+	for _, stock := range quotes.Stocks() {
+		quoted[stock.Ticker] = true
+	}
+	for _, ticker := range profile.Tickers {
+		if err := quotes.Failure(ticker); err != nil {
+			fmt.Fprintf(os.Stderr, "No quote for %s: %s\n", ticker, err)
+			status = 3
+		} else if quotes.Unknown(ticker) {
+			fmt.Fprintf(os.Stderr, "Unknown symbol %s\n", ticker)
+			status = 3
+		} else if !quoted[ticker] {
+			fmt.Fprintf(os.Stderr, "No quote for %s\n", ticker)
+			status = 3
+		}
+	}
+
+	switch *format {
+	case `json`:
+		err = printQuotesJSON(stocks)
+	case `csv`:
+		err = printQuotesCSV(stocks)
+	default:
+		markup := mop.NewMarkup(profile)
+		fmt.Print(markup.Render(layout.Table(quotes), isTerminal(os.Stdout)))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return status
+}
+
+// The printQuotesJSON function prints the stocks as a JSON array. Values the provider did not report are null.
+func printQuotesJSON(stocks []mop.Stock) error {
+	if stocks == nil {
+		stocks = []mop.Stock{}
+	}
+	data, err := json.MarshalIndent(stocks, ``, `    `)
+	if err == nil {
+		fmt.Println(string(data))
+	}
+	return err
+}
+
+// The printQuotesCSV function prints the stocks as CSV with the JSON names of the fields as header. Values the provider did not report are empty, the intraday closes are left out.
+func printQuotesCSV(stocks []mop.Stock) error {
+	writer := csv.NewWriter(os.Stdout)
+	kind := reflect.TypeOf(mop.Stock{})
+
+	var header []string
+	for i := 0; i < kind.NumField(); i++ {
+		if name := csvColumn(kind.Field(i)); name != `` {
+			header = append(header, name)
+		}
+	}
+	writer.Write(header)
+
+	for _, stock := range stocks {
+		value := reflect.ValueOf(stock)
+		var record []string
+		for i := 0; i < kind.NumField(); i++ {
+			if csvColumn(kind.Field(i)) == `` {
+				continue
+			}
+			switch field := value.Field(i).Interface().(type) {
+			case mop.Number:
+				record = append(record, ``)
+				if field.Valid {
+					record[len(record)-1] = strconv.FormatFloat(field.Value, 'f', -1, 64)
+				}
+			case mop.Integer:
+				record = append(record, ``)
+				if field.Valid {
+					record[len(record)-1] = strconv.FormatInt(field.Value, 10)
+				}
+			default:
+				record = append(record, fmt.Sprint(field))
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// The csvColumn function returns the CSV column name of a stock field, or an empty string for fields that have no column.
+func csvColumn(field reflect.StructField) string {
+	if field.Type.Kind() == reflect.Slice {
+		return ``
+	}
+	return strings.Split(field.Tag.Get(`json`), `,`)[0]
+}
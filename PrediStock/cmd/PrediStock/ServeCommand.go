@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/mop-tracker/mop"
+)
+
+const serveUsage = `Usage: PrediStock [-profile path] serve [options]
+
+Refreshes the market and the quotes of the profile without a screen and
+serves them as JSON over HTTP, with a Server-Sent Events stream of updates at
+/api/events. The profile is read but never written back. The quotes are
+polled at the refresh interval of the profile, even when it sets StreamURL.
+
+Options:
+`
+
+// The serveCommand function implements `PrediStock serve`, which runs the refresh loop of the profile headless and answers HTTP requests until the process is stopped. The terminal bell is disabled; the alert command of the profile still runs. The quote stream is not opened: the server publishes what it polls, and nothing would read the stream.
+func serveCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`serve`, flag.ExitOnError)
+	address := flags.String(`addr`, `127.0.0.1:8080`, `address to listen on`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, serveUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	profile.ReadOnly()
+	profile.AlertBell, profile.StreamURL = false, ``
+
+	provider, err := mop.NewQuoteProvider(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to initialize quote provider.\n\tError: %s\n", err)
+		return 1
+	}
+	market := mop.NewMarket(provider, profile)
+	server := mop.NewServer(market, mop.NewQuotes(market, profile))
+
+	go server.Run(nil)
+	fmt.Fprintf(os.Stderr, "Serving on http://%s/api/quotes\n", *address)
+	if err := http.ListenAndServe(*address, server); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
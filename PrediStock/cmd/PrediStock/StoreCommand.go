@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mop-tracker/mop"
+)
+
+const compactUsage = `Usage: PrediStock [-profile path] compact [options]
+
+Rolls the intraday ticks recorded in the local quote store into 1-minute and
+daily OHLCV bars. Days before today are compacted automatically while
+PrediStock is running.
+
+Options:
+`
+
+// The compactCommand function implements `PrediStock compact`, which compacts the tick files of the store configured in the profile. With `-today` the ticks recorded so far today are compacted too; ticks recorded later are merged into the same bars on the next compaction.
+func compactCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`compact`, flag.ExitOnError)
+	today := flags.Bool(`today`, false, `also compact the ticks recorded today`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, compactUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+
+	before := time.Now()
+	if *today {
+		before = before.AddDate(0, 0, 1)
+	}
+	compacted, err := mop.NewTickStore(profile.StorePath()).Compact(before)
+	fmt.Printf("Compacted %d tick files in %s\n", compacted, profile.StorePath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/mop-tracker/mop"
+)
+
+const streamUsage = `Usage: PrediStock [-profile path] stream [options]
+
+Plays a day recorded in the local quote store as a WebSocket quote stream at
+ws://ADDR/stream, starting over after the last update. Point the StreamURL
+setting of a profile at it to watch the day again in the quotes table.
+
+Options:
+`
+
+// The streamCommand function implements `PrediStock stream`, a local replay server for demos and tests. The last recorded day is played unless `-day` is given.
+func streamCommand(profileName string, args []string) int {
+	flags := flag.NewFlagSet(`stream`, flag.ExitOnError)
+	address := flags.String(`addr`, `127.0.0.1:8765`, `address to listen on`)
+	day := flags.String(`day`, ``, `recorded day to play, 2006-01-02 (default the last one)`)
+	speed := flags.Float64(`speed`, 1, `playback speed, 60 plays an hour per minute`)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, streamUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || *speed <= 0 {
+		flags.Usage()
+		return 2
+	}
+
+	profile, ok := loadProfile(profileName)
+	if !ok {
+		return 1
+	}
+	store := mop.NewTickStore(profile.StorePath())
+	if *day == `` {
+		days := store.Days()
+		if len(days) == 0 {
+			fmt.Fprintf(os.Stderr, "No quotes recorded in %s\n", profile.StorePath())
+			return 1
+		}
+		*day = days[len(days)-1]
+	}
+	frames, err := store.ReplayFrames(*day)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No quotes recorded on %s in %s\n", *day, profile.StorePath())
+		return 1
+	}
+
+	http.Handle(`/stream`, mop.NewReplayServer(frames, *speed))
+	fmt.Fprintf(os.Stderr, "Playing %d updates of %s on ws://%s/stream\n", len(frames), *day, *address)
+	if err := http.ListenAndServe(*address, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
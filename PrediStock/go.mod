@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/Knetic/govaluate v3.0.0+incompatible
 	github.com/eiannone/keyboard v0.0.0-20200508000154-caf4b762e807
+	github.com/gorilla/websocket v1.5.0
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/nsf/termbox-go v1.1.1
 	golang.org/x/sys v0.1.0 // indirect